@@ -20,6 +20,7 @@ import (
 	"context"
 	"flag"
 	"fmt"
+	"hash/fnv"
 	"os"
 	"sync"
 	"syscall"
@@ -60,21 +61,29 @@ import (
 )
 
 var (
-	setupLog                     = ctrl.Log.WithName("setup")
-	shardKey                     string
-	version                      string
-	diagnosticsAddress           string
-	insecureDiagnostics          bool
-	workers                      int
-	concurrentReconciles         int
-	reportMode                   controllers.ReportMode
-	tmpReportMode                int
-	reloaderReportCollectionTime int
-	restConfigQPS                float32
-	restConfigBurst              int
-	webhookPort                  int
-	syncPeriod                   time.Duration
-	healthAddr                   string
+	setupLog                        = ctrl.Log.WithName("setup")
+	shardKey                        string
+	version                         string
+	diagnosticsAddress              string
+	insecureDiagnostics             bool
+	workers                         int
+	concurrentReconciles            int
+	reportMode                      controllers.ReportMode
+	tmpReportMode                   int
+	reloaderReportCollectionTime    int
+	healthCheckReportCollectionTime int
+	restConfigQPS                   float32
+	restConfigBurst                 int
+	webhookPort                     int
+	syncPeriod                      time.Duration
+	healthAddr                      string
+	collectionSettings              controllers.CollectionSettings
+	enableLeaderElection            bool
+	circuitBreakerFailures          int
+	circuitBreakerOpenTimeout       time.Duration
+	remoteClientCacheTTL            time.Duration
+	reportIngestionSettings         controllers.ReportIngestionSettings
+	healthCheckReportGCSettings     controllers.HealthCheckReportGCSettings
 )
 
 const (
@@ -88,6 +97,9 @@ const (
 //+kubebuilder:rbac:groups=authentication.k8s.io,resources=tokenreviews,verbs=create
 //+kubebuilder:rbac:groups=authorization.k8s.io,resources=subjectaccessreviews,verbs=create
 
+// Add RBAC for leader election.
+//+kubebuilder:rbac:groups=coordination.k8s.io,resources=leases,verbs=get;list;watch;create;update;patch;delete
+
 func main() {
 	scheme, err := controllers.InitScheme()
 	if err != nil {
@@ -116,6 +128,9 @@ func main() {
 		Cache: cache.Options{
 			SyncPeriod: &syncPeriod,
 		},
+		LeaderElection:                enableLeaderElection,
+		LeaderElectionID:              getLeaderElectionID(shardKey),
+		LeaderElectionReleaseOnCancel: true,
 	}
 
 	restConfig := ctrl.GetConfigOrDie()
@@ -139,6 +154,9 @@ func main() {
 	controllers.RegisterFeatures(d, setupLog)
 
 	controllers.SetManagementRecorder(mgr.GetEventRecorderFor("notification-recorder"))
+	controllers.SetSveltosVersion(version)
+	controllers.SetCircuitBreakerSettings(circuitBreakerFailures, circuitBreakerOpenTimeout)
+	controllers.SetRemoteClientCacheTTL(remoteClientCacheTTL)
 
 	var clusterHealthCheckController controller.Controller
 	clusterHealthCheckReconciler := getClusterHealthCheckReconciler(mgr)
@@ -149,7 +167,7 @@ func main() {
 		setupLog.Error(err, "unable to create controller", "controller", "ClusterHealthCheck")
 		os.Exit(1)
 	}
-	if err = (getHealthCheckReconciler(mgr)).SetupWithManager(mgr); err != nil {
+	if err = (getHealthCheckReconciler(mgr)).SetupWithManager(mgr, healthCheckReportCollectionTime); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "HealthCheck")
 		os.Exit(1)
 	}
@@ -165,6 +183,18 @@ func main() {
 		setupLog.Error(err, "unable to create controller", "controller", "ReloaderReport")
 		os.Exit(1)
 	}
+	// Cached clients to managed clusters are discarded when their kubeconfig Secret changes
+	if err = mgr.Add(controllers.NewKubeconfigSecretWatcher(mgr, ctrl.Log)); err != nil {
+		setupLog.Error(err, "unable to add kubeconfig Secret watcher")
+		os.Exit(1)
+	}
+	if reportIngestionSettings.BindAddress != "" {
+		if err = mgr.Add(controllers.NewReportIngestionServer(mgr.GetClient(), reportIngestionSettings,
+			ctrl.Log)); err != nil {
+			setupLog.Error(err, "unable to add report ingestion endpoint")
+			os.Exit(1)
+		}
+	}
 	//+kubebuilder:scaffold:builder
 
 	setupChecks(mgr)
@@ -182,11 +212,60 @@ func main() {
 
 func initFlags(fs *pflag.FlagSet) {
 	fs.IntVar(&tmpReportMode, "report-mode", defaulReportMode,
-		"Indicates how ClassifierReport needs to be collected")
+		"Indicates how ClassifierReport needs to be collected (0: collected by management cluster, "+
+			"1: sent by sveltos-agent, 2: watched by management cluster)")
 
 	fs.IntVar(&reloaderReportCollectionTime, "reloaderreport-time", defaultReloaderReportTime,
 		"Interval, in seconds, at which ReloaderReports are collected from managed cluster.")
 
+	fs.DurationVar(&collectionSettings.ResyncPeriod, "report-resync-period", controllers.DefaultReportResyncPeriod,
+		"When report-mode is set to watch, interval at which all reports are collected again from each "+
+			"watched managed cluster (e.g. 2m)")
+
+	fs.IntVar(&healthCheckReportCollectionTime, "healthcheckreport-time", 0,
+		"Interval, in seconds, at which HealthCheckReports are collected from managed cluster. "+
+			"Defaults to 10 seconds, 5 seconds when shard-key is set.")
+
+	fs.IntVar(&collectionSettings.Workers, "collection-workers", controllers.DefaultCollectionWorkers,
+		"Number of managed clusters HealthCheckReports and ReloaderReports are collected from in parallel")
+
+	fs.DurationVar(&collectionSettings.ClusterTimeout, "collection-cluster-timeout",
+		controllers.DefaultCollectionClusterTimeout,
+		"Maximum time spent collecting reports from a single managed cluster (e.g. 30s)")
+
+	fs.DurationVar(&collectionSettings.SweepTimeout, "collection-sweep-timeout",
+		controllers.DefaultCollectionSweepTimeout,
+		"Maximum time spent collecting reports from all managed clusters (e.g. 5m). "+
+			"Clusters not processed by then are skipped till next collection")
+
+	fs.IntVar(&circuitBreakerFailures, "circuit-breaker-failures", controllers.DefaultCircuitBreakerFailureThreshold,
+		"Number of consecutive failed calls to a managed cluster after which calls fail fast and the cluster "+
+			"is reported as unreachable. Set to 0 to disable")
+
+	fs.DurationVar(&circuitBreakerOpenTimeout, "circuit-breaker-open-timeout",
+		controllers.DefaultCircuitBreakerOpenTimeout,
+		"Time calls to an unreachable managed cluster fail fast before a call is attempted again (e.g. 1m)")
+
+	fs.DurationVar(&remoteClientCacheTTL, "remote-client-cache-ttl", controllers.DefaultRemoteClientCacheTTL,
+		"Time a client to a managed cluster is reused for (e.g. 10m). Cached clients are also discarded when "+
+			"the cluster kubeconfig Secret changes. Set to 0 to disable caching")
+
+	fs.DurationVar(&healthCheckReportGCSettings.Interval, "healthcheckreport-gc-interval",
+		controllers.DefaultHealthCheckReportGCInterval,
+		"Interval between garbage collections of HealthCheckReports whose HealthCheck, cluster or "+
+			"ClusterHealthCheck owner does not exist anymore (e.g. 10m). Set to 0 to disable")
+
+	fs.BoolVar(&healthCheckReportGCSettings.DryRun, "healthcheckreport-gc-dry-run", false,
+		"Only log and count orphaned HealthCheckReports, without deleting them")
+
+	fs.StringVar(&reportIngestionSettings.BindAddress, "report-ingestion-address", "",
+		"The address the HTTPS endpoint managed clusters can POST HealthCheckReports and ReloaderReports to "+
+			"binds to (e.g. :9444). Empty disables the endpoint")
+
+	fs.StringVar(&reportIngestionSettings.CertDir, "report-ingestion-cert-dir", "/tmp/report-ingestion-certs",
+		"Directory containing tls.crt and tls.key used by the report ingestion endpoint. "+
+			"config/default/manager_report_ingestion_patch.yaml mounts them from a Secret")
+
 	fs.StringVar(&diagnosticsAddress, "diagnostics-address", ":8443",
 		"The address the diagnostics endpoint binds to. Per default metrics are served via https and with"+
 			"authentication/authorization. To serve via http and without authentication/authorization set --insecure-diagnostics."+
@@ -195,6 +274,10 @@ func initFlags(fs *pflag.FlagSet) {
 	fs.BoolVar(&insecureDiagnostics, "insecure-diagnostics", false,
 		"Enable insecure diagnostics serving. For more details see the description of --diagnostics-address.")
 
+	fs.BoolVar(&enableLeaderElection, "leader-elect", false,
+		"Enable leader election. Only the leader reconciles and collects reports from managed clusters. "+
+			"Each shard elects its own leader.")
+
 	fs.StringVar(&shardKey, "shard-key", "",
 		"If set, and report-mode is set to collect, this deployment will fetch only from clusters matching this shard")
 
@@ -229,6 +312,19 @@ func initFlags(fs *pflag.FlagSet) {
 			defaultSyncPeriod))
 }
 
+// getLeaderElectionID returns the name of the Lease used for leader election.
+// Each shard has its own leader, so the shard key is part of the name.
+func getLeaderElectionID(shardKey string) string {
+	const leaderElectionID = "healthcheck-manager.projectsveltos.io"
+	if shardKey == "" {
+		return leaderElectionID
+	}
+
+	h := fnv.New32a()
+	h.Write([]byte(shardKey))
+	return fmt.Sprintf("%x.%s", h.Sum32(), leaderElectionID)
+}
+
 func setupChecks(mgr ctrl.Manager) {
 	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
 		setupLog.Error(err, "unable to set up health check")
@@ -313,6 +409,7 @@ func getClusterHealthCheckReconciler(mgr manager.Manager) *controllers.ClusterHe
 		ClusterLabels:        make(map[corev1.ObjectReference]map[string]string),
 		HealthCheckMap:       make(map[corev1.ObjectReference]*libsveltosset.Set),
 		CHCToHealthCheckMap:  make(map[types.NamespacedName]*libsveltosset.Set),
+		NextEvaluations:      make(map[types.NamespacedName]map[corev1.ObjectReference]time.Time),
 	}
 }
 
@@ -344,6 +441,8 @@ func getHealthCheckReconciler(mgr manager.Manager) *controllers.HealthCheckRecon
 		HealthCheckReportMode: reportMode,
 		ShardKey:              shardKey,
 		Version:               version,
+		CollectionSettings:    collectionSettings,
+		GCSettings:            healthCheckReportGCSettings,
 	}
 }
 
@@ -354,5 +453,6 @@ func getReloaderReportReconciler(mgr manager.Manager) *controllers.ReloaderRepor
 		ReloaderReportMode: reportMode,
 		ShardKey:           shardKey,
 		Version:            version,
+		CollectionSettings: collectionSettings,
 	}
 }
@@ -0,0 +1,368 @@
+/*
+Copyright 2023. projectsveltos.io. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	libsveltosv1beta1 "github.com/projectsveltos/libsveltos/api/v1beta1"
+)
+
+const (
+	// DefaultCircuitBreakerFailureThreshold is the default number of consecutive failed calls to a
+	// managed cluster after which the circuit breaker opens
+	DefaultCircuitBreakerFailureThreshold = 5
+
+	// DefaultCircuitBreakerOpenTimeout is the default time a circuit breaker stays open before a
+	// call to the managed cluster is attempted again (half-open probe)
+	DefaultCircuitBreakerOpenTimeout = time.Minute
+
+	// clusterUnreachableMessage is the message of liveness checks for a cluster whose circuit breaker is open
+	clusterUnreachableMessage = "cluster unreachable  \n"
+)
+
+var (
+	errClusterUnreachable = errors.New("cluster unreachable: circuit breaker open")
+
+	breakers = newCircuitBreakers(DefaultCircuitBreakerFailureThreshold, DefaultCircuitBreakerOpenTimeout)
+)
+
+// SetCircuitBreakerSettings sets the number of consecutive failed calls after which calls to a managed
+// cluster fail fast, and for how long. A failureThreshold of zero disables the circuit breaker.
+func SetCircuitBreakerSettings(failureThreshold int, openTimeout time.Duration) {
+	breakers = newCircuitBreakers(failureThreshold, openTimeout)
+}
+
+// circuitBreaker tracks failed calls to a managed cluster
+type circuitBreaker struct {
+	clusterNamespace string
+	clusterName      string
+	clusterType      libsveltosv1beta1.ClusterType
+	// failures is the number of consecutive failed calls
+	failures int
+	// openUntil is, when open, the time a call to the cluster is attempted again
+	openUntil time.Time
+	// probing is true while the single call allowed when open timeout expired (half-open) is in progress
+	probing bool
+}
+
+// circuitBreakers contains a circuit breaker per managed cluster.
+// A circuit breaker is closed while calls to the cluster succeed. After failureThreshold consecutive
+// calls failed because the cluster could not be reached, it opens: calls fail fast, with
+// errClusterUnreachable, for openTimeout. After that, a single call (probe) is let through: if it
+// succeeds the circuit breaker closes, otherwise it opens again.
+type circuitBreakers struct {
+	failureThreshold int
+	openTimeout      time.Duration
+	// now returns the current time
+	now func() time.Time
+
+	mux sync.Mutex
+	// key: managed cluster
+	breakers map[string]*circuitBreaker
+}
+
+func newCircuitBreakers(failureThreshold int, openTimeout time.Duration) *circuitBreakers {
+	if openTimeout <= 0 {
+		openTimeout = DefaultCircuitBreakerOpenTimeout
+	}
+
+	return &circuitBreakers{
+		failureThreshold: failureThreshold,
+		openTimeout:      openTimeout,
+		now:              time.Now,
+		breakers:         make(map[string]*circuitBreaker),
+	}
+}
+
+// isOpen returns true if the circuit breaker for cluster is open (including while waiting for, or
+// running, a probe)
+func (cb *circuitBreakers) isOpen(clusterNamespace, clusterName string,
+	clusterType libsveltosv1beta1.ClusterType) bool {
+
+	if cb.failureThreshold <= 0 {
+		return false
+	}
+
+	cb.mux.Lock()
+	defer cb.mux.Unlock()
+
+	b, ok := cb.breakers[getClusterKey(clusterNamespace, clusterName, clusterType)]
+	return ok && b.failures >= cb.failureThreshold
+}
+
+// isFailingFast returns true if calls to cluster currently fail fast
+func (cb *circuitBreakers) isFailingFast(clusterNamespace, clusterName string,
+	clusterType libsveltosv1beta1.ClusterType) bool {
+
+	if cb.failureThreshold <= 0 {
+		return false
+	}
+
+	cb.mux.Lock()
+	defer cb.mux.Unlock()
+
+	b, ok := cb.breakers[getClusterKey(clusterNamespace, clusterName, clusterType)]
+	return ok && b.failures >= cb.failureThreshold && (b.probing || cb.now().Before(b.openUntil))
+}
+
+// allow returns errClusterUnreachable if a call to cluster must fail fast. Otherwise the call can
+// proceed and its result must be passed to record.
+func (cb *circuitBreakers) allow(clusterNamespace, clusterName string,
+	clusterType libsveltosv1beta1.ClusterType) error {
+
+	if cb.failureThreshold <= 0 {
+		return nil
+	}
+
+	cb.mux.Lock()
+	defer cb.mux.Unlock()
+
+	b, ok := cb.breakers[getClusterKey(clusterNamespace, clusterName, clusterType)]
+	if !ok || b.failures < cb.failureThreshold {
+		return nil
+	}
+	if b.probing || cb.now().Before(b.openUntil) {
+		return errClusterUnreachable
+	}
+
+	// Half-open: let this call through as probe
+	b.probing = true
+	return nil
+}
+
+// record updates the circuit breaker for cluster with the result of a call
+func (cb *circuitBreakers) record(clusterNamespace, clusterName string,
+	clusterType libsveltosv1beta1.ClusterType, callErr error) {
+
+	if cb.failureThreshold <= 0 {
+		return
+	}
+
+	key := getClusterKey(clusterNamespace, clusterName, clusterType)
+
+	cb.mux.Lock()
+	defer cb.mux.Unlock()
+
+	b, ok := cb.breakers[key]
+	if errors.Is(callErr, context.Canceled) {
+		// Call did not complete. Says nothing about the cluster.
+		if ok {
+			b.probing = false
+		}
+		return
+	}
+
+	if !isClusterConnectionError(callErr) {
+		if ok {
+			delete(cb.breakers, key)
+			setCircuitBreakerMetric(clusterNamespace, clusterName, clusterType, false)
+		}
+		return
+	}
+
+	if !ok {
+		b = &circuitBreaker{clusterNamespace: clusterNamespace, clusterName: clusterName, clusterType: clusterType}
+		cb.breakers[key] = b
+	}
+	b.failures++
+	b.probing = false
+	if b.failures >= cb.failureThreshold {
+		b.openUntil = cb.now().Add(cb.openTimeout)
+		setCircuitBreakerMetric(clusterNamespace, clusterName, clusterType, true)
+	}
+}
+
+// remove removes the circuit breaker for a cluster. Called when the cluster is deleted.
+func (cb *circuitBreakers) remove(clusterNamespace, clusterName string, clusterType libsveltosv1beta1.ClusterType) {
+	cb.mux.Lock()
+	defer cb.mux.Unlock()
+
+	key := getClusterKey(clusterNamespace, clusterName, clusterType)
+	if _, ok := cb.breakers[key]; ok {
+		delete(cb.breakers, key)
+		deleteCircuitBreakerMetric(clusterNamespace, clusterName, clusterType)
+	}
+}
+
+// isClusterConnectionError returns true if err indicates the managed cluster could not be reached.
+// Errors returned by the managed cluster API server (for instance NotFound or Conflict) mean the cluster
+// is reachable.
+func isClusterConnectionError(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+
+	if apierrors.IsTimeout(err) || apierrors.IsServerTimeout(err) || apierrors.IsServiceUnavailable(err) {
+		return true
+	}
+
+	var status apierrors.APIStatus
+	if errors.As(err, &status) || meta.IsNoMatchError(err) || runtime.IsNotRegisteredError(err) {
+		return false
+	}
+
+	return true
+}
+
+// circuitBreakerClient is a client to a managed cluster. Calls fail fast while the cluster circuit
+// breaker is open.
+type circuitBreakerClient struct {
+	client.WithWatch
+	clusterNamespace string
+	clusterName      string
+	clusterType      libsveltosv1beta1.ClusterType
+}
+
+func (c *circuitBreakerClient) call(f func() error) error {
+	if err := breakers.allow(c.clusterNamespace, c.clusterName, c.clusterType); err != nil {
+		return err
+	}
+
+	err := f()
+	breakers.record(c.clusterNamespace, c.clusterName, c.clusterType, err)
+	if apierrors.IsUnauthorized(err) {
+		// Credentials were likely rotated. Next client is built from the current kubeconfig.
+		remoteClients.invalidate(c.clusterNamespace, c.clusterName, c.clusterType)
+	}
+	return err
+}
+
+func (c *circuitBreakerClient) Get(ctx context.Context, key client.ObjectKey, obj client.Object,
+	opts ...client.GetOption) error {
+
+	return c.call(func() error { return c.WithWatch.Get(ctx, key, obj, opts...) })
+}
+
+func (c *circuitBreakerClient) List(ctx context.Context, list client.ObjectList, opts ...client.ListOption) error {
+	return c.call(func() error { return c.WithWatch.List(ctx, list, opts...) })
+}
+
+func (c *circuitBreakerClient) Create(ctx context.Context, obj client.Object, opts ...client.CreateOption) error {
+	return c.call(func() error { return c.WithWatch.Create(ctx, obj, opts...) })
+}
+
+func (c *circuitBreakerClient) Delete(ctx context.Context, obj client.Object, opts ...client.DeleteOption) error {
+	return c.call(func() error { return c.WithWatch.Delete(ctx, obj, opts...) })
+}
+
+func (c *circuitBreakerClient) Update(ctx context.Context, obj client.Object, opts ...client.UpdateOption) error {
+	return c.call(func() error { return c.WithWatch.Update(ctx, obj, opts...) })
+}
+
+func (c *circuitBreakerClient) Patch(ctx context.Context, obj client.Object, patch client.Patch,
+	opts ...client.PatchOption) error {
+
+	return c.call(func() error { return c.WithWatch.Patch(ctx, obj, patch, opts...) })
+}
+
+func (c *circuitBreakerClient) DeleteAllOf(ctx context.Context, obj client.Object,
+	opts ...client.DeleteAllOfOption) error {
+
+	return c.call(func() error { return c.WithWatch.DeleteAllOf(ctx, obj, opts...) })
+}
+
+func (c *circuitBreakerClient) Watch(ctx context.Context, list client.ObjectList,
+	opts ...client.ListOption) (watch.Interface, error) {
+
+	var watcher watch.Interface
+	err := c.call(func() error {
+		var err error
+		watcher, err = c.WithWatch.Watch(ctx, list, opts...)
+		return err
+	})
+	return watcher, err
+}
+
+func (c *circuitBreakerClient) Status() client.SubResourceWriter {
+	return &circuitBreakerSubResourceClient{SubResourceClient: c.WithWatch.SubResource("status"), parent: c}
+}
+
+func (c *circuitBreakerClient) SubResource(subResource string) client.SubResourceClient {
+	return &circuitBreakerSubResourceClient{SubResourceClient: c.WithWatch.SubResource(subResource), parent: c}
+}
+
+// circuitBreakerSubResourceClient is a client to a managed cluster subresource. Calls are tracked by
+// the cluster circuit breaker as the ones of the parent client.
+type circuitBreakerSubResourceClient struct {
+	client.SubResourceClient
+	parent *circuitBreakerClient
+}
+
+func (c *circuitBreakerSubResourceClient) Get(ctx context.Context, obj, subResource client.Object,
+	opts ...client.SubResourceGetOption) error {
+
+	return c.parent.call(func() error { return c.SubResourceClient.Get(ctx, obj, subResource, opts...) })
+}
+
+func (c *circuitBreakerSubResourceClient) Create(ctx context.Context, obj, subResource client.Object,
+	opts ...client.SubResourceCreateOption) error {
+
+	return c.parent.call(func() error { return c.SubResourceClient.Create(ctx, obj, subResource, opts...) })
+}
+
+func (c *circuitBreakerSubResourceClient) Update(ctx context.Context, obj client.Object,
+	opts ...client.SubResourceUpdateOption) error {
+
+	return c.parent.call(func() error { return c.SubResourceClient.Update(ctx, obj, opts...) })
+}
+
+func (c *circuitBreakerSubResourceClient) Patch(ctx context.Context, obj client.Object, patch client.Patch,
+	opts ...client.SubResourcePatchOption) error {
+
+	return c.parent.call(func() error { return c.SubResourceClient.Patch(ctx, obj, patch, opts...) })
+}
+
+// getRemoteClient returns a (cached) client to the managed cluster. Calls through the client are tracked
+// by the cluster circuit breaker. Returns errClusterUnreachable if the circuit breaker is open.
+func getRemoteClient(ctx context.Context, c client.Client, clusterNamespace, clusterName string,
+	clusterType libsveltosv1beta1.ClusterType, logger logr.Logger) (client.Client, error) {
+
+	return getRemoteWatchClient(ctx, c, clusterNamespace, clusterName, clusterType, logger)
+}
+
+// getRemoteWatchClient is getRemoteClient returning a client which also supports watch
+func getRemoteWatchClient(ctx context.Context, c client.Client, clusterNamespace, clusterName string,
+	clusterType libsveltosv1beta1.ClusterType, logger logr.Logger) (client.WithWatch, error) {
+
+	if breakers.isFailingFast(clusterNamespace, clusterName, clusterType) {
+		return nil, errClusterUnreachable
+	}
+
+	remoteClient, err := remoteClients.get(ctx, c, clusterNamespace, clusterName, clusterType, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	return &circuitBreakerClient{WithWatch: remoteClient, clusterNamespace: clusterNamespace,
+		clusterName: clusterName, clusterType: clusterType}, nil
+}
+
+// isClusterUnreachable returns true if the circuit breaker for the cluster is open
+func isClusterUnreachable(clusterNamespace, clusterName string, clusterType libsveltosv1beta1.ClusterType) bool {
+	return breakers.isOpen(clusterNamespace, clusterName, clusterType)
+}
@@ -0,0 +1,216 @@
+/*
+Copyright 2023. projectsveltos.io. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers_test
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/klog/v2/textlogger"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+
+	"github.com/projectsveltos/healthcheck-manager/controllers"
+	libsveltosv1beta1 "github.com/projectsveltos/libsveltos/api/v1beta1"
+)
+
+var _ = Describe("Circuit breaker", func() {
+	var clusterNamespace string
+	var clusterName string
+	var calls int
+	var callErr error
+	var remoteClient client.WithWatch
+	var now time.Time
+
+	const clusterType = libsveltosv1beta1.ClusterTypeSveltos
+
+	BeforeEach(func() {
+		clusterNamespace = randomString()
+		clusterName = randomString()
+		calls = 0
+		callErr = nil
+
+		controllers.SetCircuitBreakerSettings(2, time.Minute)
+		now = time.Now()
+		controllers.SetCircuitBreakerClock(func() time.Time { return now })
+
+		c := fake.NewClientBuilder().WithScheme(scheme).WithInterceptorFuncs(interceptor.Funcs{
+			Get: func(ctx context.Context, c client.WithWatch, key client.ObjectKey, obj client.Object,
+				opts ...client.GetOption) error {
+
+				calls++
+				return callErr
+			},
+			SubResourceUpdate: func(ctx context.Context, c client.Client, subResourceName string, obj client.Object,
+				opts ...client.SubResourceUpdateOption) error {
+
+				calls++
+				return callErr
+			},
+			Watch: func(ctx context.Context, c client.WithWatch, obj client.ObjectList,
+				opts ...client.ListOption) (watch.Interface, error) {
+
+				calls++
+				if callErr != nil {
+					return nil, callErr
+				}
+				return c.Watch(ctx, obj, opts...)
+			},
+		}).Build()
+		remoteClient = controllers.NewCircuitBreakerClient(c, clusterNamespace, clusterName, clusterType)
+	})
+
+	AfterEach(func() {
+		controllers.SetCircuitBreakerSettings(controllers.DefaultCircuitBreakerFailureThreshold,
+			controllers.DefaultCircuitBreakerOpenTimeout)
+	})
+
+	get := func() error {
+		return remoteClient.Get(context.TODO(), types.NamespacedName{Name: randomString()}, &corev1.Namespace{})
+	}
+
+	It("opens after consecutive connection failures and closes once a probe succeeds", func() {
+		callErr = errors.New("dial tcp: connect: connection refused")
+
+		Expect(get()).ToNot(Succeed())
+		Expect(controllers.IsClusterUnreachable(clusterNamespace, clusterName, clusterType)).To(BeFalse())
+		Expect(get()).ToNot(Succeed())
+		Expect(controllers.IsClusterUnreachable(clusterNamespace, clusterName, clusterType)).To(BeTrue())
+		Expect(calls).To(Equal(2))
+
+		// Open: calls fail fast
+		Expect(errors.Is(get(), controllers.ErrClusterUnreachable)).To(BeTrue())
+		Expect(calls).To(Equal(2))
+
+		// Half-open: a failing probe opens the circuit breaker again
+		now = now.Add(time.Minute)
+		Expect(errors.Is(get(), controllers.ErrClusterUnreachable)).To(BeFalse())
+		Expect(calls).To(Equal(3))
+		Expect(errors.Is(get(), controllers.ErrClusterUnreachable)).To(BeTrue())
+
+		// Half-open: a successful probe closes the circuit breaker
+		now = now.Add(time.Minute)
+		callErr = nil
+		Expect(get()).To(Succeed())
+		Expect(controllers.IsClusterUnreachable(clusterNamespace, clusterName, clusterType)).To(BeFalse())
+		Expect(get()).To(Succeed())
+		Expect(calls).To(Equal(5))
+	})
+
+	It("failing watches open the circuit breaker", func() {
+		callErr = errors.New("dial tcp: connect: connection refused")
+
+		_, err := remoteClient.Watch(context.TODO(), &corev1.NamespaceList{})
+		Expect(err).ToNot(BeNil())
+		_, err = remoteClient.Watch(context.TODO(), &corev1.NamespaceList{})
+		Expect(err).ToNot(BeNil())
+		Expect(controllers.IsClusterUnreachable(clusterNamespace, clusterName, clusterType)).To(BeTrue())
+
+		_, err = remoteClient.Watch(context.TODO(), &corev1.NamespaceList{})
+		Expect(errors.Is(err, controllers.ErrClusterUnreachable)).To(BeTrue())
+		Expect(calls).To(Equal(2))
+	})
+
+	It("failing status updates open the circuit breaker", func() {
+		callErr = errors.New("dial tcp: connect: connection refused")
+
+		ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: randomString()}}
+		Expect(remoteClient.Status().Update(context.TODO(), ns)).ToNot(Succeed())
+		Expect(remoteClient.SubResource("status").Update(context.TODO(), ns)).ToNot(Succeed())
+		Expect(controllers.IsClusterUnreachable(clusterNamespace, clusterName, clusterType)).To(BeTrue())
+
+		Expect(errors.Is(remoteClient.Status().Update(context.TODO(), ns), controllers.ErrClusterUnreachable)).
+			To(BeTrue())
+		Expect(calls).To(Equal(2))
+	})
+
+	It("circuit breaker is removed when cluster is deleted", func() {
+		callErr = errors.New("dial tcp: connect: connection refused")
+		Expect(get()).ToNot(Succeed())
+		Expect(get()).ToNot(Succeed())
+		Expect(controllers.IsClusterUnreachable(clusterNamespace, clusterName, clusterType)).To(BeTrue())
+
+		reconciler := &controllers.SveltosClusterReconciler{
+			Client: fake.NewClientBuilder().WithScheme(scheme).Build(),
+			Scheme: scheme,
+		}
+		_, err := reconciler.Reconcile(context.TODO(), ctrl.Request{
+			NamespacedName: types.NamespacedName{Namespace: clusterNamespace, Name: clusterName},
+		})
+		Expect(err).To(BeNil())
+		Expect(controllers.IsClusterUnreachable(clusterNamespace, clusterName, clusterType)).To(BeFalse())
+	})
+
+	It("errors returned by the managed cluster API server do not open the circuit breaker", func() {
+		callErr = apierrors.NewNotFound(schema.GroupResource{Resource: "namespaces"}, randomString())
+
+		for range 5 {
+			Expect(apierrors.IsNotFound(get())).To(BeTrue())
+		}
+		Expect(controllers.IsClusterUnreachable(clusterNamespace, clusterName, clusterType)).To(BeFalse())
+		Expect(calls).To(Equal(5))
+	})
+
+	It("evaluateLivenessCheck reports only SveltosAgent liveness checks as failing when cluster is unreachable",
+		func() {
+			c := prepareClientWithClusterSummaryAndCHC(clusterNamespace, clusterName, clusterType)
+
+			chcs := &libsveltosv1beta1.ClusterHealthCheckList{}
+			Expect(c.List(context.TODO(), chcs)).To(Succeed())
+			Expect(len(chcs.Items)).To(Equal(1))
+
+			callErr = context.DeadlineExceeded
+			Expect(get()).ToNot(Succeed())
+			Expect(get()).ToNot(Succeed())
+
+			logger := textlogger.NewLogger(textlogger.NewConfig(textlogger.Verbosity(1)))
+
+			sveltosAgentCheck := libsveltosv1beta1.LivenessCheck{
+				Name: randomString(),
+				Type: controllers.LivenessTypeSveltosAgent,
+			}
+			status, statusChanged, message, err := controllers.EvaluateLivenessCheck(context.TODO(), c,
+				clusterNamespace, clusterName, clusterType, &chcs.Items[0], &sveltosAgentCheck, nil, logger)
+			Expect(err).To(BeNil())
+			Expect(status).To(Equal(corev1.ConditionFalse))
+			Expect(statusChanged).To(BeTrue())
+			Expect(message).To(ContainSubstring("cluster unreachable"))
+
+			// Addons liveness check only reads the management cluster: it is still evaluated
+			addonsCheck := libsveltosv1beta1.LivenessCheck{
+				Name: randomString(),
+				Type: libsveltosv1beta1.LivenessTypeAddons,
+			}
+			status, _, message, err = controllers.EvaluateLivenessCheck(context.TODO(), c,
+				clusterNamespace, clusterName, clusterType, &chcs.Items[0], &addonsCheck, nil, logger)
+			Expect(err).To(BeNil())
+			Expect(status).To(Equal(corev1.ConditionTrue))
+			Expect(message).ToNot(ContainSubstring("cluster unreachable"))
+		})
+})
@@ -60,8 +60,17 @@ func (r *ClusterReconciler) SetupWithManager(mgr ctrl.Manager) error {
 func processCluster(ctx context.Context, c client.Client, cluster client.Object, req ctrl.Request,
 	logger logr.Logger) (ctrl.Result, error) {
 
+	clusterType := libsveltosv1beta1.ClusterTypeCapi
+	if _, ok := cluster.(*libsveltosv1beta1.SveltosCluster); ok {
+		clusterType = libsveltosv1beta1.ClusterTypeSveltos
+	}
+
 	if err := c.Get(ctx, req.NamespacedName, cluster); err != nil {
 		if apierrors.IsNotFound(err) {
+			remoteClients.invalidate(req.Namespace, req.Name, clusterType)
+			breakers.remove(req.Namespace, req.Name, clusterType)
+			healthCheckReportCollectionStatus.remove(req.Namespace, req.Name, clusterType)
+			reloaderReportCollectionStatus.remove(req.Namespace, req.Name, clusterType)
 			err = removeHealthCheckReportsFromCluster(ctx, c, req.Namespace, req.Name,
 				libsveltosv1beta1.ClusterTypeCapi, logger)
 			if err != nil {
@@ -79,6 +88,10 @@ func processCluster(ctx context.Context, c client.Client, cluster client.Object,
 
 	// Handle deleted cluster
 	if !cluster.GetDeletionTimestamp().IsZero() {
+		remoteClients.invalidate(req.Namespace, req.Name, clusterType)
+		breakers.remove(req.Namespace, req.Name, clusterType)
+		healthCheckReportCollectionStatus.remove(req.Namespace, req.Name, clusterType)
+		reloaderReportCollectionStatus.remove(req.Namespace, req.Name, clusterType)
 		err := removeHealthCheckReportsFromCluster(ctx, c, req.Namespace, req.Name,
 			libsveltosv1beta1.ClusterTypeCapi, logger)
 		if err != nil {
@@ -87,5 +100,13 @@ func processCluster(ctx context.Context, c client.Client, cluster client.Object,
 		return reconcile.Result{}, nil
 	}
 
+	// A cached client to the cluster is discarded if cluster spec or kubeconfig changed
+	secretVersion, err := getKubeconfigSecretVersion(ctx, c, req.Namespace, getKubeconfigSecretName(cluster))
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+	remoteClients.invalidateOnClusterChange(req.Namespace, req.Name, clusterType, cluster.GetGeneration(),
+		secretVersion)
+
 	return reconcile.Result{}, nil
 }
@@ -0,0 +1,295 @@
+/*
+Copyright 2023. projectsveltos.io. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	libsveltosv1beta1 "github.com/projectsveltos/libsveltos/api/v1beta1"
+	"github.com/projectsveltos/libsveltos/lib/clusterproxy"
+	logs "github.com/projectsveltos/libsveltos/lib/logsettings"
+)
+
+const (
+	// aggregateConditionType is the prefix of the condition type reported for aggregate checks
+	aggregateConditionType = "Aggregate"
+
+	// aggregateClusterType is used when delivering notifications for aggregate checks
+	aggregateClusterType = libsveltosv1beta1.ClusterType("Fleet")
+
+	// aggregateStateKey is the key, in the ClusterHealthCheck state ConfigMap, storing aggregate checks
+	// results
+	aggregateStateKey = "aggregate"
+)
+
+// aggregateState contains the results of the aggregate checks of a ClusterHealthCheck.
+// Aggregate checks are not about a single cluster, so results are not stored in Status.ClusterConditions
+// but in the ClusterHealthCheck state ConfigMap.
+type aggregateState struct {
+	// Conditions contains one condition per aggregate check
+	Conditions []libsveltosv1beta1.Condition `json:"conditions,omitempty"`
+
+	// NotificationSummaries contains the delivery status of aggregate checks notifications
+	NotificationSummaries []libsveltosv1beta1.NotificationSummary `json:"notificationSummaries,omitempty"`
+}
+
+func getAggregateConditionType(aggregateCheck *aggregateCheckOptions) libsveltosv1beta1.ConditionType {
+	return libsveltosv1beta1.ConditionType(fmt.Sprintf("%s:%s", aggregateConditionType, aggregateCheck.Name))
+}
+
+// getAggregateNotificationSummaryName returns the name used in NotificationSummaries for a notification
+// delivered because of an aggregate check
+func getAggregateNotificationSummaryName(aggregateCheck *aggregateCheckOptions, n *libsveltosv1beta1.Notification,
+) string {
+
+	return fmt.Sprintf("%s:%s", aggregateCheck.Name, n.Name)
+}
+
+// getAggregateState returns the results of the aggregate checks of a ClusterHealthCheck. Empty if none
+// was stored yet.
+func getAggregateState(ctx context.Context, c client.Client, chcName string) (*aggregateState, error) {
+	state := &aggregateState{}
+	err := getStateValue(ctx, c, getStateConfigMapName(chcName), aggregateStateKey, state)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse aggregate checks state: %w", err)
+	}
+
+	return state, nil
+}
+
+// evaluateAggregateChecks evaluates all aggregate checks defined for a ClusterHealthCheck over
+// the liveness checks results of all matching clusters. It is invoked once per ClusterHealthCheck
+// reconciliation, after all clusters were processed. Results are stored in the ClusterHealthCheck state
+// ConfigMap. Notifications are delivered for each aggregate check that changed state.
+func evaluateAggregateChecks(ctx context.Context, c client.Client, chc *libsveltosv1beta1.ClusterHealthCheck,
+	logger logr.Logger) error {
+
+	options, err := getClusterHealthCheckOptions(chc)
+	if err != nil {
+		return err
+	}
+
+	previous, err := getAggregateState(ctx, c, chc.Name)
+	if err != nil {
+		return err
+	}
+
+	if len(options.AggregateChecks) == 0 {
+		if len(previous.Conditions) == 0 && len(previous.NotificationSummaries) == 0 {
+			return nil
+		}
+		return removeStateValues(ctx, c, getStateConfigMapName(chc.Name), aggregateStateKey)
+	}
+
+	var changed []libsveltosv1beta1.Condition
+	conditions := make([]libsveltosv1beta1.Condition, len(options.AggregateChecks))
+	for i := range options.AggregateChecks {
+		conditions[i] = evaluateAggregateCheck(chc, &options.AggregateChecks[i])
+		previousCondition := getConditionByName(previous.Conditions, conditions[i].Name)
+		if previousCondition == nil ||
+			hasStatusChanged(previousCondition, conditions[i].Status, conditions[i].Message) {
+
+			changed = append(changed, conditions[i])
+		} else {
+			conditions[i].LastTransitionTime = previousCondition.LastTransitionTime
+		}
+	}
+
+	notificationSummaries, sendNotificationError := sendAggregateNotifications(ctx, c, chc, options, conditions,
+		changed, previous.NotificationSummaries, logger)
+
+	current := &aggregateState{
+		Conditions:            conditions,
+		NotificationSummaries: notificationSummaries,
+	}
+	var updateError error
+	if !reflect.DeepEqual(previous, current) {
+		updateError = updateStateValue(ctx, c, chc, getStateConfigMapName(chc.Name), aggregateStateKey, current)
+		if updateError != nil {
+			logger.V(logs.LogInfo).Info(fmt.Sprintf("failed to store aggregate checks state: %v", updateError))
+		}
+	}
+
+	return errors.Join(sendNotificationError, updateError)
+}
+
+// evaluateAggregateCheck returns the condition for an aggregate check
+func evaluateAggregateCheck(chc *libsveltosv1beta1.ClusterHealthCheck, aggregateCheck *aggregateCheckOptions,
+) libsveltosv1beta1.Condition {
+
+	total := 0
+	healthy := 0
+	failingClusters := make([]string, 0)
+	for i := range chc.Status.ClusterConditions {
+		cc := &chc.Status.ClusterConditions[i]
+		evaluated, passing := isClusterHealthy(cc, aggregateCheck)
+		if !evaluated {
+			// Liveness checks not evaluated yet (or Unknown) for this cluster
+			continue
+		}
+
+		total++
+		if passing {
+			healthy++
+		} else {
+			failingClusters = append(failingClusters,
+				fmt.Sprintf("%s:%s/%s", clusterproxy.GetClusterType(&cc.ClusterInfo.Cluster),
+					cc.ClusterInfo.Cluster.Namespace, cc.ClusterInfo.Cluster.Name))
+		}
+	}
+
+	condition := libsveltosv1beta1.Condition{
+		Type:               getAggregateConditionType(aggregateCheck),
+		Name:               aggregateCheck.Name,
+		Status:             corev1.ConditionTrue,
+		LastTransitionTime: metav1.Time{Time: time.Now()},
+	}
+
+	passing := true
+	if aggregateCheck.MinHealthyClusters != nil && healthy < *aggregateCheck.MinHealthyClusters {
+		passing = false
+	}
+	if aggregateCheck.MinHealthyPercentage != nil && total > 0 &&
+		healthy*100 < *aggregateCheck.MinHealthyPercentage*total {
+
+		passing = false
+	}
+
+	if !passing {
+		condition.Status = corev1.ConditionFalse
+		condition.Severity = libsveltosv1beta1.ConditionSeverityWarning
+		condition.Message = fmt.Sprintf("%d/%d clusters healthy  \n", healthy, total)
+		if len(failingClusters) > 0 {
+			slices.Sort(failingClusters)
+			condition.Message += fmt.Sprintf("Failing clusters: %s  \n", strings.Join(failingClusters, ", "))
+		}
+	}
+
+	return condition
+}
+
+// isClusterHealthy returns whether liveness checks considered by the aggregate check have been
+// evaluated for the cluster (none of them is Unknown) and, if so, whether they are all passing
+func isClusterHealthy(cc *libsveltosv1beta1.ClusterCondition, aggregateCheck *aggregateCheckOptions,
+) (evaluated, passing bool) {
+
+	passing = true
+	for i := range cc.Conditions {
+		condition := &cc.Conditions[i]
+		if len(aggregateCheck.LivenessChecks) != 0 &&
+			!slices.Contains(aggregateCheck.LivenessChecks, condition.Name) {
+
+			continue
+		}
+		if condition.Status == corev1.ConditionUnknown {
+			// Cluster status cannot be established
+			return false, false
+		}
+		evaluated = true
+		if condition.Status != corev1.ConditionTrue {
+			passing = false
+		}
+	}
+
+	return evaluated, passing
+}
+
+// getConditionByName returns the condition with given name. Nil if not found
+func getConditionByName(conditions []libsveltosv1beta1.Condition, name string) *libsveltosv1beta1.Condition {
+	for i := range conditions {
+		if conditions[i].Name == name {
+			return &conditions[i]
+		}
+	}
+	return nil
+}
+
+// sendAggregateNotifications delivers notifications for the aggregate checks which changed state
+// and for notifications which previously failed to be delivered. Returns the notification summaries.
+func sendAggregateNotifications(ctx context.Context, c client.Client, chc *libsveltosv1beta1.ClusterHealthCheck,
+	options *clusterHealthCheckOptions, conditions, changed []libsveltosv1beta1.Condition,
+	previousSummaries []libsveltosv1beta1.NotificationSummary, logger logr.Logger,
+) ([]libsveltosv1beta1.NotificationSummary, error) {
+
+	notificationStatus := make(map[string]libsveltosv1beta1.NotificationStatus)
+	for i := range previousSummaries {
+		ns := &previousSummaries[i]
+		notificationStatus[ns.Name] = ns.Status
+	}
+
+	notificationSummaries := make([]libsveltosv1beta1.NotificationSummary, 0)
+	var sendNotificationError error
+	for i := range options.AggregateChecks {
+		aggregateCheck := &options.AggregateChecks[i]
+		condition := getConditionByName(conditions, aggregateCheck.Name)
+		if condition == nil {
+			continue
+		}
+		resend := getConditionByName(changed, aggregateCheck.Name) != nil
+
+		for j := range chc.Spec.Notifications {
+			n := &chc.Spec.Notifications[j]
+			if len(aggregateCheck.Notifications) != 0 && !slices.Contains(aggregateCheck.Notifications, n.Name) {
+				continue
+			}
+
+			summaryName := getAggregateNotificationSummaryName(aggregateCheck, n)
+			status, ok := notificationStatus[summaryName]
+			if !resend && ok && status == libsveltosv1beta1.NotificationStatusDelivered {
+				notificationSummaries = append(notificationSummaries,
+					libsveltosv1beta1.NotificationSummary{
+						Name:   summaryName,
+						Status: libsveltosv1beta1.NotificationStatusDelivered,
+					})
+				continue
+			}
+
+			if err := sendNotification(ctx, c, "", chc.Name, aggregateClusterType, chc, n,
+				[]libsveltosv1beta1.Condition{*condition}, "", logger); err != nil {
+				logger.V(logs.LogInfo).Info(fmt.Sprintf("failed to deliver notification %s:%s. Err: %v",
+					n.Type, n.Name, err))
+				sendNotificationError = errors.Join(sendNotificationError, err)
+				failureMessage := err.Error()
+				notificationSummaries = append(notificationSummaries,
+					libsveltosv1beta1.NotificationSummary{
+						Name:           summaryName,
+						Status:         libsveltosv1beta1.NotificationStatusFailedToDeliver,
+						FailureMessage: &failureMessage,
+					})
+			} else {
+				notificationSummaries = append(notificationSummaries,
+					libsveltosv1beta1.NotificationSummary{
+						Name:   summaryName,
+						Status: libsveltosv1beta1.NotificationStatusDelivered,
+					})
+			}
+		}
+	}
+
+	return notificationSummaries, sendNotificationError
+}
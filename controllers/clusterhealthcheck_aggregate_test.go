@@ -0,0 +1,125 @@
+/*
+Copyright 2023. projectsveltos.io. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers_test
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2/textlogger"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/projectsveltos/healthcheck-manager/controllers"
+	libsveltosv1beta1 "github.com/projectsveltos/libsveltos/api/v1beta1"
+)
+
+var _ = Describe("ClusterHealthCheck aggregate checks", func() {
+	getClusterCondition := func(livenessCheckName string, status corev1.ConditionStatus) libsveltosv1beta1.ClusterCondition {
+		return libsveltosv1beta1.ClusterCondition{
+			ClusterInfo: libsveltosv1beta1.ClusterInfo{
+				Cluster: corev1.ObjectReference{
+					Namespace:  randomString(),
+					Name:       randomString(),
+					Kind:       "Cluster",
+					APIVersion: clusterv1.GroupVersion.String(),
+				},
+			},
+			Conditions: []libsveltosv1beta1.Condition{
+				{
+					Type:   libsveltosv1beta1.ConditionType("HealthCheck:" + livenessCheckName),
+					Name:   livenessCheckName,
+					Status: status,
+				},
+			},
+		}
+	}
+
+	getAggregateConditions := func(c client.Client, chcName string) []libsveltosv1beta1.Condition {
+		state, err := controllers.GetAggregateState(context.TODO(), c, chcName)
+		Expect(err).To(BeNil())
+		return state.Conditions
+	}
+
+	It("evaluateAggregateChecks stores aggregate result in state ConfigMap", func() {
+		livenessCheckName := randomString()
+		aggregateName := randomString()
+
+		failing := getClusterCondition(livenessCheckName, corev1.ConditionFalse)
+		chc := &libsveltosv1beta1.ClusterHealthCheck{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: randomString(),
+				Annotations: map[string]string{
+					controllers.ClusterHealthCheckOptionsAnnotation: "aggregateChecks:\n- name: " + aggregateName +
+						"\n  livenessChecks: [" + livenessCheckName + "]\n  minHealthyPercentage: 90\n",
+				},
+			},
+			Status: libsveltosv1beta1.ClusterHealthCheckStatus{
+				ClusterConditions: []libsveltosv1beta1.ClusterCondition{
+					getClusterCondition(livenessCheckName, corev1.ConditionTrue),
+					getClusterCondition(livenessCheckName, corev1.ConditionTrue),
+					failing,
+				},
+			},
+		}
+
+		initObjects := []client.Object{chc}
+		c := fake.NewClientBuilder().WithScheme(scheme).WithStatusSubresource(initObjects...).
+			WithObjects(initObjects...).Build()
+
+		logger := textlogger.NewLogger(textlogger.NewConfig(textlogger.Verbosity(1)))
+		Expect(controllers.EvaluateAggregateChecks(context.TODO(), c, chc, logger)).To(Succeed())
+
+		conditions := getAggregateConditions(c, chc.Name)
+		Expect(len(conditions)).To(Equal(1))
+		Expect(conditions[0].Name).To(Equal(aggregateName))
+		Expect(conditions[0].Status).To(Equal(corev1.ConditionFalse))
+		Expect(conditions[0].Message).To(ContainSubstring("2/3 clusters healthy"))
+		Expect(conditions[0].Message).To(ContainSubstring(failing.ClusterInfo.Cluster.Name))
+
+		// Aggregate result is not stored among clusters
+		currentChc := &libsveltosv1beta1.ClusterHealthCheck{}
+		Expect(c.Get(context.TODO(), types.NamespacedName{Name: chc.Name}, currentChc)).To(Succeed())
+		Expect(len(currentChc.Status.ClusterConditions)).To(Equal(3))
+
+		// Relax threshold. Aggregate check is now passing
+		Expect(c.Get(context.TODO(), types.NamespacedName{Name: chc.Name}, currentChc)).To(Succeed())
+		currentChc.Annotations[controllers.ClusterHealthCheckOptionsAnnotation] =
+			"aggregateChecks:\n- name: " + aggregateName + "\n  minHealthyClusters: 2\n"
+		Expect(c.Update(context.TODO(), currentChc)).To(Succeed())
+
+		Expect(controllers.EvaluateAggregateChecks(context.TODO(), c, currentChc, logger)).To(Succeed())
+		conditions = getAggregateConditions(c, chc.Name)
+		Expect(len(conditions)).To(Equal(1))
+		Expect(conditions[0].Status).To(Equal(corev1.ConditionTrue))
+		Expect(conditions[0].Message).To(BeEmpty())
+
+		// Remove aggregate checks. Result is removed
+		Expect(c.Get(context.TODO(), types.NamespacedName{Name: chc.Name}, currentChc)).To(Succeed())
+		currentChc.Annotations = nil
+		Expect(c.Update(context.TODO(), currentChc)).To(Succeed())
+
+		Expect(controllers.EvaluateAggregateChecks(context.TODO(), c, currentChc, logger)).To(Succeed())
+		Expect(getAggregateConditions(c, chc.Name)).To(BeEmpty())
+	})
+})
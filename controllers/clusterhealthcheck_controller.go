@@ -59,6 +59,12 @@ const (
 	// SveltosAgent is provided with Kubeconfig to access
 	// management cluster and can only update HealthCheckReport (and ClassifierReport)
 	AgentSendReportsNoGateway
+
+	// In this mode, healthCheckManager running in the management cluster
+	// keeps a watch open against each Sveltos/CAPI cluster and processes
+	// HealthCheckReports (and ReloaderReports) as they change. Clusters
+	// which cannot be watched are still periodically polled.
+	WatchFromManagementCluster
 )
 
 const (
@@ -69,6 +75,10 @@ const (
 	// normalRequeueAfter is how long to wait before checking again to see if the cluster can be moved
 	// to ready after or workload features (for instance ingress or reporter) have failed
 	normalRequeueAfter = 20 * time.Second
+
+	// minStaleReportRequeueAfter is the minimum interval between reconciliations done to
+	// detect stale HealthCheckReports
+	minStaleReportRequeueAfter = 10 * time.Second
 )
 
 // ClusterHealthCheckReconciler reconciles a ClusterHealthCheck object
@@ -127,6 +137,10 @@ type ClusterHealthCheckReconciler struct {
 
 	// Key: ClusterHealthCheck: value: set of HealthChecks referenced
 	CHCToHealthCheckMap map[types.NamespacedName]*libsveltosset.Set
+
+	// Key: ClusterHealthCheck requesting periodic evaluation: value: for each matching Sveltos/CAPI Cluster,
+	// the next time ClusterHealthCheck needs to be evaluated in the cluster
+	NextEvaluations map[types.NamespacedName]map[corev1.ObjectReference]time.Time
 }
 
 //+kubebuilder:rbac:groups=lib.projectsveltos.io,resources=clusterhealthchecks,verbs=get;list;watch;create;update;patch;delete
@@ -134,12 +148,16 @@ type ClusterHealthCheckReconciler struct {
 //+kubebuilder:rbac:groups=lib.projectsveltos.io,resources=clusterhealthchecks/finalizers,verbs=update
 //+kubebuilder:rbac:groups=config.projectsveltos.io,resources=clustersummaries,verbs=get;list;watch
 //+kubebuilder:rbac:groups=config.projectsveltos.io,resources=clustersummaries/status,verbs=get;list;watch
+//+kubebuilder:rbac:groups="",resources=configmaps,verbs=get;watch;list;create;update;delete
 //+kubebuilder:rbac:groups="",resources=events,verbs=get;watch;list;create;update;patch
 //+kubebuilder:rbac:groups="",resources=secrets,verbs=get;watch;list
 //+kubebuilder:rbac:groups=cluster.x-k8s.io,resources=clusters,verbs=get;watch;list
 //+kubebuilder:rbac:groups=cluster.x-k8s.io,resources=clusters/status,verbs=get;watch;list
 //+kubebuilder:rbac:groups=cluster.x-k8s.io,resources=machines,verbs=get;watch;list
 //+kubebuilder:rbac:groups=cluster.x-k8s.io,resources=machines/status,verbs=get;watch;list
+//+kubebuilder:rbac:groups=cluster.x-k8s.io,resources=machinedeployments,verbs=get;watch;list
+//+kubebuilder:rbac:groups=cluster.x-k8s.io,resources=machinehealthchecks,verbs=get;watch;list
+//+kubebuilder:rbac:groups=controlplane.cluster.x-k8s.io,resources=k0scontrolplanes;k0smotroncontrolplanes;kubeadmcontrolplanes;rke2controlplanes;taloscontrolplanes,verbs=get;watch;list
 //+kubebuilder:rbac:groups=lib.projectsveltos.io,resources=sveltosclusters,verbs=get;watch;list
 //+kubebuilder:rbac:groups=lib.projectsveltos.io,resources=sveltosclusters/status,verbs=get;watch;list
 //+kubebuilder:rbac:groups=lib.projectsveltos.io,resources=healthchecks,verbs=get;watch;list
@@ -253,13 +271,59 @@ func (r *ClusterHealthCheckReconciler) reconcileNormal(
 	r.updateMaps(clusterHealthCheckScope)
 
 	f := getHandlersForFeature(libsveltosv1beta1.FeatureClusterHealthCheck)
-	if err := r.deployClusterHealthCheck(ctx, clusterHealthCheckScope, f, logger); err != nil {
-		logger.V(logs.LogInfo).Error(err, "failed to deploy")
+	deployErr := r.deployClusterHealthCheck(ctx, clusterHealthCheckScope, f, logger)
+	if deployErr != nil {
+		logger.V(logs.LogInfo).Error(deployErr, "failed to deploy")
+	}
+
+	// Aggregate checks are evaluated once all clusters have been processed
+	aggregateErr := evaluateAggregateChecks(ctx, r.Client, clusterHealthCheckScope.ClusterHealthCheck, logger)
+	if aggregateErr != nil {
+		logger.V(logs.LogInfo).Error(aggregateErr, "failed to evaluate aggregate checks")
+	}
+
+	if deployErr != nil || aggregateErr != nil {
 		return reconcile.Result{Requeue: true, RequeueAfter: normalRequeueAfter}, nil
 	}
 
 	logger.V(logs.LogInfo).Info("Reconcile success")
-	return reconcile.Result{}, nil
+	// No event is generated when a HealthCheckReport is not refreshed anymore or when time based
+	// checks need to run again. Periodically reconcile so those are evaluated.
+	requeueAfter := getStaleReportRequeueAfter(clusterHealthCheckScope.ClusterHealthCheck)
+	evaluationRequeueAfter := r.getEvaluationRequeueAfter(clusterHealthCheckScope.ClusterHealthCheck)
+	if evaluationRequeueAfter != 0 && (requeueAfter == 0 || evaluationRequeueAfter < requeueAfter) {
+		requeueAfter = evaluationRequeueAfter
+	}
+	return reconcile.Result{RequeueAfter: requeueAfter}, nil
+}
+
+// getStaleReportRequeueAfter returns how often ClusterHealthCheck needs to be reconciled to detect
+// stale HealthCheckReports. Zero if none of its HealthCheck liveness checks sets a MaxReportAge.
+func getStaleReportRequeueAfter(chc *libsveltosv1beta1.ClusterHealthCheck) time.Duration {
+	options, err := getClusterHealthCheckOptions(chc)
+	if err != nil {
+		return 0
+	}
+
+	var requeueAfter time.Duration
+	for i := range chc.Spec.LivenessChecks {
+		lc := &chc.Spec.LivenessChecks[i]
+		if lc.Type != libsveltosv1beta1.LivenessTypeHealthCheck {
+			continue
+		}
+		lcOptions, ok := options.LivenessChecks[lc.Name]
+		if !ok || lcOptions.MaxReportAge == nil {
+			continue
+		}
+		// Reconcile twice per MaxReportAge, so a report is detected as stale within
+		// half MaxReportAge
+		interval := max(lcOptions.MaxReportAge.Duration/2, minStaleReportRequeueAfter)
+		if requeueAfter == 0 || interval < requeueAfter {
+			requeueAfter = interval
+		}
+	}
+
+	return requeueAfter
 }
 
 // SetupWithManager sets up the controller with the Manager.
@@ -371,6 +435,8 @@ func (r *ClusterHealthCheckReconciler) cleanMaps(clusterHealthCheckScope *scope.
 
 	delete(r.CHCToClusterMap, types.NamespacedName{Name: clusterHealthCheckScope.Name()})
 
+	delete(r.NextEvaluations, types.NamespacedName{Name: clusterHealthCheckScope.Name()})
+
 	for k, l := range r.HealthCheckMap {
 		l.Erase(
 			&corev1.ObjectReference{
@@ -20,6 +20,7 @@ import (
 	"context"
 	"crypto/sha256"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"reflect"
 	"time"
@@ -239,7 +240,7 @@ func (r *ClusterHealthCheckReconciler) processClusterHealthCheck(ctx context.Con
 		}
 
 		if *status == libsveltosv1beta1.SveltosStatusProvisioned {
-			return clusterInfo, nil
+			return clusterInfo, r.evaluateIfDue(ctx, chcScope, cluster, logger)
 		}
 		if *status == libsveltosv1beta1.SveltosStatusProvisioning {
 			return clusterInfo, fmt.Errorf("clusterHealthCheck is still being provisioned")
@@ -248,6 +249,7 @@ func (r *ClusterHealthCheckReconciler) processClusterHealthCheck(ctx context.Con
 		logger.V(logs.LogInfo).Info("already deployed")
 		s := libsveltosv1beta1.SveltosStatusProvisioned
 		status = &s
+		err = r.evaluateIfDue(ctx, chcScope, cluster, logger)
 	} else {
 		logger.V(logs.LogInfo).Info("no result is available. queue job and mark status as provisioning")
 		s := libsveltosv1beta1.SveltosStatusProvisioning
@@ -259,6 +261,7 @@ func (r *ClusterHealthCheckReconciler) processClusterHealthCheck(ctx context.Con
 			false, processClusterHealthCheckForCluster, programDuration, deployer.Options{}); err != nil {
 			return nil, err
 		}
+		r.scheduleNextEvaluation(chc, cluster)
 	}
 
 	clusterInfo := &libsveltosv1beta1.ClusterInfo{
@@ -268,7 +271,7 @@ func (r *ClusterHealthCheckReconciler) processClusterHealthCheck(ctx context.Con
 		FailureMessage: nil,
 	}
 
-	return clusterInfo, nil
+	return clusterInfo, err
 }
 
 func (r *ClusterHealthCheckReconciler) removeClusterHealthCheck(ctx context.Context, chcScope *scope.ClusterHealthCheckScope,
@@ -282,6 +285,7 @@ func (r *ClusterHealthCheckReconciler) removeClusterHealthCheck(ctx context.Cont
 	// Remove any queued entry to deploy/evaluate
 	r.Deployer.CleanupEntries(cluster.Namespace, cluster.Name, chc.Name, f.id,
 		clusterproxy.GetClusterType(cluster), false)
+	r.unscheduleEvaluation(chc, cluster)
 
 	// If deploying feature is in progress, wait for it to complete.
 	// Otherwise, if we cleanup feature while same feature is still being provisioned, if two workers process those request in
@@ -443,6 +447,8 @@ func clusterHealthCheckHash(ctx context.Context, c client.Client,
 	h := sha256.New()
 	var config string
 	config += render.AsCode(chc.Spec)
+	config += render.AsCode(chc.Annotations[ClusterHealthCheckOptionsAnnotation])
+	config += getClusterAcknowledgementsHash(chc, cluster.Namespace, cluster.Name, clusterproxy.GetClusterType(cluster))
 
 	clusterSummaries, err := fetchClusterSummaries(ctx, c, cluster.Namespace, cluster.Name,
 		clusterproxy.GetClusterType(cluster))
@@ -493,6 +499,23 @@ func fetchReferencedResources(ctx context.Context, c client.Client,
 			for j := range list.Items {
 				config += render.AsCode(list.Items[j].Spec)
 			}
+
+			// A HealthCheckReport going stale must cause the liveness check to be re-evaluated
+			options, err := getLivenessCheckOptions(chc, lc)
+			if err != nil {
+				return "", err
+			}
+			if options.MaxReportAge != nil {
+				config += getStaleHealthCheckReports(list.Items, options.MaxReportAge.Duration)
+			}
+		} else if lc.Type == LivenessTypeClusterAPI &&
+			clusterproxy.GetClusterType(cluster) == libsveltosv1beta1.ClusterTypeCapi {
+
+			state, err := getClusterAPIState(ctx, c, cluster.Namespace, cluster.Name)
+			if err != nil {
+				return "", err
+			}
+			config += state
 		}
 	}
 
@@ -547,6 +570,16 @@ func processClusterHealthCheckForCluster(ctx context.Context, c client.Client,
 	logger.V(logs.LogDebug).Info("Deploy clusterHealthCheck")
 
 	err = deployHealthChecks(ctx, c, clusterNamespace, clusterName, clusterType, chc, logger)
+	if errors.Is(err, errClusterUnreachable) {
+		// Still evaluate, so the cluster is reported as unreachable. Deployment is retried later.
+		logger.V(logs.LogDebug).Info("cluster unreachable. Only evaluating health checks")
+		evaluationErr := evaluateHealthChecksAndSendNotificationsForCluster(ctx, c, clusterNamespace, clusterName,
+			clusterType, chc, logger)
+		if evaluationErr != nil {
+			return evaluationErr
+		}
+		return err
+	}
 	if err != nil {
 		logger.V(logs.LogDebug).Info("failed to deploy referenced HealthChecks")
 		return err
@@ -613,6 +646,12 @@ func undeployClusterHealthCheckResourcesFromCluster(ctx context.Context, c clien
 		return err
 	}
 
+	err = removeClusterStates(ctx, c, chc.Name, clusterNamespace, clusterName, clusterType)
+	if err != nil {
+		logger.V(logs.LogInfo).Info(fmt.Sprintf("failed to remove ClusterHealthCheck state: %v", err))
+		return err
+	}
+
 	logger.V(logs.LogDebug).Info("Undeployed clusterHealthCheck")
 	return nil
 }
@@ -626,6 +665,20 @@ func evaluateClusterHealthCheckForCluster(ctx context.Context, c client.Client,
 	clusterNamespace, clusterName string, clusterType libsveltosv1beta1.ClusterType,
 	chc *libsveltosv1beta1.ClusterHealthCheck, logger logr.Logger) ([]libsveltosv1beta1.Condition, bool, error) {
 
+	options, err := getClusterHealthCheckOptions(chc)
+	if err != nil {
+		return nil, false, err
+	}
+
+	// State of past evaluations is needed only when thresholds or flap detection are set
+	var states clusterLivenessCheckStates
+	if needsLivenessCheckState(options) {
+		states, err = getLivenessCheckStates(ctx, c, chc.Name, clusterNamespace, clusterName, clusterType)
+		if err != nil {
+			return nil, false, err
+		}
+	}
+
 	conditions := make([]libsveltosv1beta1.Condition, len(chc.Spec.LivenessChecks))
 
 	statusChanged := false
@@ -637,23 +690,62 @@ func evaluateClusterHealthCheckForCluster(ctx context.Context, c client.Client,
 			LastTransitionTime: metav1.Time{Time: time.Now()},
 		}
 
-		var tmpStatusChanged bool
-		passing, tmpStatusChanged, message, err := evaluateLivenessCheck(ctx, c, clusterNamespace, clusterName, clusterType, chc,
-			&livenessCheck, logger)
+		var state *livenessCheckState
+		if states != nil {
+			s := states[livenessCheck.Name]
+			state = &s
+		}
+
+		status, tmpStatusChanged, message, err := evaluateLivenessCheck(ctx, c, clusterNamespace, clusterName, clusterType, chc,
+			&livenessCheck, state, logger)
 		if err != nil {
 			logger.V(logs.LogDebug).Info("failed to evaluate livenessCheck %v. Err: %v", livenessCheck, err)
 			return nil, false, err
 		}
-		if tmpStatusChanged {
+		if state != nil {
+			states[livenessCheck.Name] = *state
+		}
+		previous := getPreviousLivenessCheckStatus(chc, clusterNamespace, clusterName, clusterType, &livenessCheck)
+		if tmpStatusChanged && isNotifiableChange(options, previous, status) {
 			statusChanged = true
 		}
 
 		conditions[i].Name = livenessCheck.Name
-		conditions[i].Status = getConditionStatus(passing)
-		if !passing {
-			conditions[i].Severity = libsveltosv1beta1.ConditionSeverityWarning
+		conditions[i].Status = status
+		conditions[i].Severity = getConditionSeverity(status)
+		preserveLastTransitionTime(previous, &conditions[i])
+		if status != corev1.ConditionTrue {
 			conditions[i].Message = message
 		}
+		if state != nil {
+			conditions[i].Reason = getFlappingReason(previous, state)
+		}
+	}
+
+	// Composite checks are evaluated over the liveness checks results
+	compositeConditions := evaluateCompositeChecks(options.CompositeChecks, conditions)
+	for i := range compositeConditions {
+		livenessCheck := &libsveltosv1beta1.LivenessCheck{Name: compositeConditions[i].Name, Type: compositeCheckType}
+		preserveLastTransitionTime(getPreviousLivenessCheckStatus(chc, clusterNamespace, clusterName, clusterType,
+			livenessCheck), &compositeConditions[i])
+	}
+	if haveCompositeChecksChanged(chc, options, clusterNamespace, clusterName, clusterType, compositeConditions) {
+		statusChanged = true
+	}
+	conditions = append(conditions, compositeConditions...)
+
+	err = processAcknowledgements(ctx, c, chc, clusterNamespace, clusterName, clusterType, conditions)
+	if err != nil {
+		logger.V(logs.LogInfo).Info(fmt.Sprintf("failed to process acknowledgements: %v", err))
+		return nil, false, err
+	}
+
+	if states != nil {
+		err = updateLivenessCheckStates(ctx, c, chc, clusterNamespace, clusterName, clusterType, states)
+		if err != nil {
+			logger.V(logs.LogInfo).Info(fmt.Sprintf("failed to store liveness check state: %v", err))
+			return nil, false, err
+		}
 	}
 
 	return conditions, statusChanged, nil
@@ -661,15 +753,46 @@ func evaluateClusterHealthCheckForCluster(ctx context.Context, c client.Client,
 
 // sendNotification sends notifications defined in ClusterHealthCheck.
 // if resendAll is set to true, all Notifications are sent. Otherwise only the ones which have not been
-// sent yet will be delivered.
+// sent yet will be delivered. Notifications limited to composite checks are sent again only when a
+// composite check changed state. Notifications with a reminder interval are sent again, as reminders,
+// while liveness checks keep failing. Notifications listed in escalation tiers are sent only when a
+// liveness check reaches their tier and when that liveness check recovers.
 func sendNotifications(ctx context.Context, c client.Client, clusterNamespace, clusterName string,
 	clusterType libsveltosv1beta1.ClusterType, chc *libsveltosv1beta1.ClusterHealthCheck, resendAll bool,
 	conditions []libsveltosv1beta1.Condition, logger logr.Logger) error {
 
-	notificationStatus := make(map[string]libsveltosv1beta1.NotificationStatus)
-	// Ignore status if all notifications need to be sent again
-	if !resendAll {
-		notificationStatus = buildNotificationStatusMap(clusterNamespace, clusterName, clusterType, chc)
+	options, err := getClusterHealthCheckOptions(chc)
+	if err != nil {
+		return err
+	}
+
+	notificationStatus := buildNotificationStatusMap(clusterNamespace, clusterName, clusterType, chc)
+	compositesChanged := haveCompositeChecksChanged(chc, options, clusterNamespace, clusterName, clusterType, conditions)
+
+	// State of past deliveries is needed only when reminders are set
+	var states clusterNotificationStates
+	if needsNotificationState(options) {
+		states, err = getNotificationStates(ctx, c, chc.Name, clusterNamespace, clusterName, clusterType)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Acknowledged liveness checks are neither reminded nor escalated
+	acknowledged := getAcknowledgedConditions(chc, clusterNamespace, clusterName, clusterType, conditions)
+
+	var escalationStates, previousEscalationStates clusterEscalationStates
+	var escalations, recoveries map[string][]libsveltosv1beta1.Condition
+	if needsEscalationState(options) {
+		escalationStates, err = getEscalationStates(ctx, c, chc.Name, clusterNamespace, clusterName, clusterType)
+		if err != nil {
+			return err
+		}
+		previousEscalationStates = make(clusterEscalationStates, len(escalationStates))
+		for k, v := range escalationStates {
+			previousEscalationStates[k] = v
+		}
+		escalations, recoveries = getEscalations(options, escalationStates, conditions, acknowledged)
 	}
 
 	notificationSummaries := make([]libsveltosv1beta1.NotificationSummary, 0)
@@ -677,12 +800,39 @@ func sendNotifications(ctx context.Context, c client.Client, clusterNamespace, c
 	var sendNotificationError error
 	for i := range chc.Spec.Notifications {
 		n := &chc.Spec.Notifications[i]
-		if doSendNotification(n, notificationStatus, resendAll) {
+		nConditions := getNotificationConditions(options, n, conditions)
+		// Notifications limited to composite checks are sent again only if a composite check changed
+		resend := resendAll
+		if options.Notifications[n.Name].CompositesOnly {
+			resend = compositesChanged
+		}
+		send := doSendNotification(n, notificationStatus, resend)
+		var header string
+		var escalated []libsveltosv1beta1.Condition
+		if isEscalationNotification(options, n.Name) {
+			escalated = append(escalated, escalations[n.Name]...)
+			escalated = append(escalated, recoveries[n.Name]...)
+			nConditions = getNotificationConditions(options, n, escalated)
+			send = len(nConditions) > 0
+			header = escalationHeader
+			if len(escalations[n.Name]) == 0 {
+				header = escalationRecoveryHeader
+			}
+		} else if !send && isReminderDue(options.Notifications[n.Name].ReminderInterval, states[n.Name], nConditions,
+			acknowledged) {
+			logger.V(logs.LogDebug).Info(fmt.Sprintf("reminder for notification %s is due", n.Name))
+			send = true
+			header = reminderHeader
+		}
+		if send {
 			if err := sendNotification(ctx, c, clusterNamespace, clusterName, clusterType,
-				chc, n, conditions, logger); err != nil {
+				chc, n, nConditions, header, logger); err != nil {
 				logger.V(logs.LogInfo).Info(fmt.Sprintf("failed to deliver notification %s:%s. Err: %v",
 					n.Type, n.Name, err))
 				sendNotificationError = err
+				if escalationStates != nil {
+					resetEscalations(previousEscalationStates, escalationStates, escalated)
+				}
 				failureMessage := err.Error()
 				notificationSummaries = append(notificationSummaries,
 					libsveltosv1beta1.NotificationSummary{
@@ -696,6 +846,9 @@ func sendNotifications(ctx context.Context, c client.Client, clusterNamespace, c
 						Name:   n.Name,
 						Status: libsveltosv1beta1.NotificationStatusDelivered,
 					})
+				if states != nil {
+					states[n.Name] = notificationState{LastSent: metav1.Time{Time: time.Now()}}
+				}
 			}
 		} else {
 			notificationSummaries = append(notificationSummaries,
@@ -704,6 +857,27 @@ func sendNotifications(ctx context.Context, c client.Client, clusterNamespace, c
 					Status: libsveltosv1beta1.NotificationStatusDelivered,
 				})
 		}
+		notificationSummaries = append(notificationSummaries,
+			getFlappingNotificationSummaries(n, nConditions, notificationSummaries[len(notificationSummaries)-1].Status)...)
+	}
+
+	if states != nil {
+		if err := updateNotificationStates(ctx, c, chc, clusterNamespace, clusterName, clusterType, states); err != nil {
+			logger.V(logs.LogInfo).Info(fmt.Sprintf("failed to store notification state: %v", err))
+			if sendNotificationError == nil {
+				sendNotificationError = err
+			}
+		}
+	}
+
+	if escalationStates != nil {
+		err := updateEscalationStates(ctx, c, chc, clusterNamespace, clusterName, clusterType, escalationStates)
+		if err != nil {
+			logger.V(logs.LogInfo).Info(fmt.Sprintf("failed to store escalation state: %v", err))
+			if sendNotificationError == nil {
+				sendNotificationError = err
+			}
+		}
 	}
 
 	if err := updateNotificationSummariesForCluster(ctx, c, clusterNamespace, clusterName, clusterType, chc,
@@ -742,7 +916,7 @@ func updateConditionsForCluster(ctx context.Context, c client.Client,
 				clusterType, clusterNamespace, clusterName)
 		}
 
-		return c.Status().Update(context.TODO(), currentChc)
+		return c.Status().Update(ctx, currentChc)
 	})
 
 	return err
@@ -775,7 +949,7 @@ func updateNotificationSummariesForCluster(ctx context.Context, c client.Client,
 			return fmt.Errorf("clusterConditions contains no entry for cluster %s:%s/%s",
 				clusterType, clusterNamespace, clusterName)
 		}
-		return c.Status().Update(context.TODO(), currentChc)
+		return c.Status().Update(ctx, currentChc)
 	})
 
 	return err
@@ -796,7 +970,7 @@ func removeConditionEntry(ctx context.Context, c client.Client,
 			cc := &currentChc.Status.ClusterConditions[i]
 			if isClusterConditionForCluster(cc, clusterNamespace, clusterName, clusterType) {
 				currentChc.Status.ClusterConditions = remove(currentChc.Status.ClusterConditions, i)
-				return c.Status().Update(context.TODO(), currentChc)
+				return c.Status().Update(ctx, currentChc)
 			}
 		}
 
@@ -833,8 +1007,7 @@ func removeStaleHealthChecks(ctx context.Context, c client.Client,
 	clusterNamespace, clusterName string, clusterType libsveltosv1beta1.ClusterType,
 	chc *libsveltosv1beta1.ClusterHealthCheck, logger logr.Logger) error {
 
-	remoteClient, err := clusterproxy.GetKubernetesClient(ctx, c, clusterNamespace, clusterName,
-		"", "", clusterType, logger)
+	remoteClient, err := getRemoteClient(ctx, c, clusterNamespace, clusterName, clusterType, logger)
 	if err != nil {
 		logger.V(logs.LogInfo).Info(fmt.Sprintf("failed to get managed cluster client: %v", err))
 		return err
@@ -901,8 +1074,7 @@ func deployHealthChecks(ctx context.Context, c client.Client,
 		return nil
 	}
 
-	remoteClient, err := clusterproxy.GetKubernetesClient(ctx, c, clusterNamespace, clusterName,
-		"", "", clusterType, logger)
+	remoteClient, err := getRemoteClient(ctx, c, clusterNamespace, clusterName, clusterType, logger)
 	if err != nil {
 		logger.V(logs.LogInfo).Info(fmt.Sprintf("failed to get managed cluster client: %v", err))
 		return err
@@ -968,7 +1140,7 @@ func createOrUpdateHealthCheck(ctx context.Context, remoteClient client.Client,
 	logger = logger.WithValues("healthCheck", healthCheck.Name)
 
 	currentHealthCheck := &libsveltosv1beta1.HealthCheck{}
-	err := remoteClient.Get(context.TODO(), types.NamespacedName{Name: healthCheck.Name}, currentHealthCheck)
+	err := remoteClient.Get(ctx, types.NamespacedName{Name: healthCheck.Name}, currentHealthCheck)
 	if err == nil {
 		logger.V(logs.LogDebug).Info("updating healthCheck")
 		currentHealthCheck.Spec = healthCheck.Spec
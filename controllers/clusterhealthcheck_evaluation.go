@@ -0,0 +1,215 @@
+/*
+Copyright 2023. projectsveltos.io. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"math/rand/v2"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	libsveltosv1beta1 "github.com/projectsveltos/libsveltos/api/v1beta1"
+	"github.com/projectsveltos/libsveltos/lib/clusterproxy"
+	logs "github.com/projectsveltos/libsveltos/lib/logsettings"
+
+	"github.com/projectsveltos/healthcheck-manager/pkg/scope"
+)
+
+const (
+	// evaluationJitterFactor is the maximum deviation (as a fraction of the evaluation interval) applied
+	// when scheduling the next periodic evaluation of a cluster. This spreads evaluations over time so a
+	// large fleet is not evaluated all at once.
+	evaluationJitterFactor = 0.1
+
+	// minEvaluationRequeueAfter is the minimum interval between reconciliations done to
+	// periodically evaluate clusters
+	minEvaluationRequeueAfter = time.Second
+
+	// defaultStateEvaluationInterval is the evaluation interval used when liveness check
+	// or notification state (thresholds, flap detection, notification delay, reminders, escalations) is
+	// needed but no evaluation interval is set
+	defaultStateEvaluationInterval = time.Minute
+)
+
+// getEvaluationInterval returns the interval at which ClusterHealthCheck must be periodically
+// evaluated in each matching cluster. Zero if periodic evaluation is not requested.
+func getEvaluationInterval(chc *libsveltosv1beta1.ClusterHealthCheck) time.Duration {
+	options, err := getClusterHealthCheckOptions(chc)
+	if err != nil {
+		return 0
+	}
+
+	if options.EvaluationInterval != nil {
+		return options.EvaluationInterval.Duration
+	}
+
+	// Thresholds count consecutive evaluations, while flapping stops and delayed notifications,
+	// reminders and escalations are sent only as time passes. Without periodic evaluation, those could
+	// wait indefinitely for the next evaluation.
+	if needsLivenessCheckState(options) || needsNotificationState(options) || needsEscalationState(options) {
+		return defaultStateEvaluationInterval
+	}
+
+	return 0
+}
+
+// getJitteredInterval returns interval randomly increased or decreased by up to evaluationJitterFactor
+func getJitteredInterval(interval time.Duration) time.Duration {
+	//nolint:gosec // jitter does not need a cryptographically secure random number
+	jitter := (rand.Float64()*2 - 1) * evaluationJitterFactor * float64(interval)
+	return interval + time.Duration(jitter)
+}
+
+// isPeriodicEvaluationDue returns true if ClusterHealthCheck requests periodic evaluation and
+// the next evaluation time for the cluster has passed.
+// If no evaluation is scheduled yet for the cluster (for instance after a restart), one is scheduled
+// at a random time within the evaluation interval.
+func (r *ClusterHealthCheckReconciler) isPeriodicEvaluationDue(chc *libsveltosv1beta1.ClusterHealthCheck,
+	cluster *corev1.ObjectReference) bool {
+
+	interval := getEvaluationInterval(chc)
+	if interval == 0 {
+		return false
+	}
+
+	r.Mux.Lock()
+	defer r.Mux.Unlock()
+
+	next, ok := r.NextEvaluations[types.NamespacedName{Name: chc.Name}][*cluster]
+	if !ok {
+		//nolint:gosec // spreading evaluations does not need a cryptographically secure random number
+		r.setNextEvaluation(chc, cluster, time.Now().Add(time.Duration(rand.Int64N(int64(interval)))))
+		return false
+	}
+
+	return !time.Now().Before(next)
+}
+
+// evaluateIfDue re-evaluates liveness checks (and sends notifications) in a cluster ClusterHealthCheck
+// is already provisioned in, if periodic evaluation is due. Nothing is redeployed to the cluster.
+func (r *ClusterHealthCheckReconciler) evaluateIfDue(ctx context.Context, chcScope *scope.ClusterHealthCheckScope,
+	cluster *corev1.ObjectReference, logger logr.Logger) error {
+
+	chc := chcScope.ClusterHealthCheck
+	if !r.isPeriodicEvaluationDue(chc, cluster) {
+		return nil
+	}
+
+	logger.V(logs.LogDebug).Info("periodic evaluation is due")
+	r.scheduleNextEvaluation(chc, cluster)
+
+	clusterType := clusterproxy.GetClusterType(cluster)
+	err := evaluateHealthChecksAndSendNotificationsForCluster(ctx, r.Client, cluster.Namespace, cluster.Name,
+		clusterType, chc, logger)
+	if err != nil {
+		return err
+	}
+
+	// Evaluation updated ClusterHealthCheck Status. Copy the result so it is not overwritten when the
+	// scope is patched at the end of the reconciliation.
+	currentChc := &libsveltosv1beta1.ClusterHealthCheck{}
+	err = r.Get(ctx, types.NamespacedName{Name: chc.Name}, currentChc)
+	if err != nil {
+		return err
+	}
+	for i := range currentChc.Status.ClusterConditions {
+		current := &currentChc.Status.ClusterConditions[i]
+		if !isClusterConditionForCluster(current, cluster.Namespace, cluster.Name, clusterType) {
+			continue
+		}
+		for j := range chc.Status.ClusterConditions {
+			cc := &chc.Status.ClusterConditions[j]
+			if isClusterConditionForCluster(cc, cluster.Namespace, cluster.Name, clusterType) {
+				cc.Conditions = current.Conditions
+				cc.NotificationSummaries = current.NotificationSummaries
+			}
+		}
+	}
+
+	return nil
+}
+
+// scheduleNextEvaluation records, when ClusterHealthCheck requests periodic evaluation, the next
+// time ClusterHealthCheck needs to be evaluated in the cluster.
+func (r *ClusterHealthCheckReconciler) scheduleNextEvaluation(chc *libsveltosv1beta1.ClusterHealthCheck,
+	cluster *corev1.ObjectReference) {
+
+	interval := getEvaluationInterval(chc)
+
+	r.Mux.Lock()
+	defer r.Mux.Unlock()
+
+	if interval == 0 {
+		delete(r.NextEvaluations, types.NamespacedName{Name: chc.Name})
+		return
+	}
+
+	r.setNextEvaluation(chc, cluster, time.Now().Add(getJitteredInterval(interval)))
+}
+
+// setNextEvaluation stores next evaluation time. Must be called with Mux held.
+func (r *ClusterHealthCheckReconciler) setNextEvaluation(chc *libsveltosv1beta1.ClusterHealthCheck,
+	cluster *corev1.ObjectReference, next time.Time) {
+
+	chcKey := types.NamespacedName{Name: chc.Name}
+	if r.NextEvaluations == nil {
+		r.NextEvaluations = make(map[types.NamespacedName]map[corev1.ObjectReference]time.Time)
+	}
+	if r.NextEvaluations[chcKey] == nil {
+		r.NextEvaluations[chcKey] = make(map[corev1.ObjectReference]time.Time)
+	}
+	r.NextEvaluations[chcKey][*cluster] = next
+}
+
+// unscheduleEvaluation removes any periodic evaluation of ClusterHealthCheck in the cluster
+func (r *ClusterHealthCheckReconciler) unscheduleEvaluation(chc *libsveltosv1beta1.ClusterHealthCheck,
+	cluster *corev1.ObjectReference) {
+
+	r.Mux.Lock()
+	defer r.Mux.Unlock()
+
+	chcKey := types.NamespacedName{Name: chc.Name}
+	delete(r.NextEvaluations[chcKey], *cluster)
+	if len(r.NextEvaluations[chcKey]) == 0 {
+		delete(r.NextEvaluations, chcKey)
+	}
+}
+
+// getEvaluationRequeueAfter returns when ClusterHealthCheck needs to be reconciled next so that
+// the first cluster whose periodic evaluation is due gets evaluated. Zero if periodic evaluation
+// is not requested.
+func (r *ClusterHealthCheckReconciler) getEvaluationRequeueAfter(chc *libsveltosv1beta1.ClusterHealthCheck,
+) time.Duration {
+
+	interval := getEvaluationInterval(chc)
+	if interval == 0 {
+		return 0
+	}
+
+	r.Mux.Lock()
+	defer r.Mux.Unlock()
+
+	requeueAfter := interval
+	for _, next := range r.NextEvaluations[types.NamespacedName{Name: chc.Name}] {
+		requeueAfter = min(requeueAfter, time.Until(next))
+	}
+
+	return max(requeueAfter, minEvaluationRequeueAfter)
+}
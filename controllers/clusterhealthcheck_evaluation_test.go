@@ -0,0 +1,180 @@
+/*
+Copyright 2023. projectsveltos.io. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers_test
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2/textlogger"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/projectsveltos/healthcheck-manager/controllers"
+	"github.com/projectsveltos/healthcheck-manager/pkg/scope"
+	libsveltosv1beta1 "github.com/projectsveltos/libsveltos/api/v1beta1"
+	fakedeployer "github.com/projectsveltos/libsveltos/lib/deployer/fake"
+)
+
+var _ = Describe("ClusterHealthCheck periodic evaluation", func() {
+	var cluster *corev1.ObjectReference
+
+	BeforeEach(func() {
+		cluster = &corev1.ObjectReference{
+			Namespace:  randomString(),
+			Name:       randomString(),
+			Kind:       libsveltosv1beta1.SveltosClusterKind,
+			APIVersion: libsveltosv1beta1.GroupVersion.String(),
+		}
+	})
+
+	It("periodic evaluation is not scheduled when evaluationInterval is not set", func() {
+		chc := &libsveltosv1beta1.ClusterHealthCheck{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: randomString(),
+			},
+		}
+
+		reconciler := getClusterHealthCheckReconciler(fake.NewClientBuilder().WithScheme(scheme).Build())
+
+		controllers.ScheduleNextEvaluation(reconciler, chc, cluster)
+		Expect(reconciler.NextEvaluations).To(BeEmpty())
+		Expect(controllers.IsPeriodicEvaluationDue(reconciler, chc, cluster)).To(BeFalse())
+		Expect(controllers.GetEvaluationRequeueAfter(reconciler, chc)).To(BeZero())
+	})
+
+	It("periodic evaluation is scheduled with jitter", func() {
+		chc := &libsveltosv1beta1.ClusterHealthCheck{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: randomString(),
+				Annotations: map[string]string{
+					controllers.ClusterHealthCheckOptionsAnnotation: "evaluationInterval: 10m",
+				},
+			},
+		}
+
+		reconciler := getClusterHealthCheckReconciler(fake.NewClientBuilder().WithScheme(scheme).Build())
+
+		// First time a cluster is seen, evaluation is scheduled within the interval
+		Expect(controllers.IsPeriodicEvaluationDue(reconciler, chc, cluster)).To(BeFalse())
+		next := reconciler.NextEvaluations[types.NamespacedName{Name: chc.Name}][*cluster]
+		Expect(next.After(time.Now().Add(10 * time.Minute))).To(BeFalse())
+
+		controllers.ScheduleNextEvaluation(reconciler, chc, cluster)
+		next = reconciler.NextEvaluations[types.NamespacedName{Name: chc.Name}][*cluster]
+		Expect(next.After(time.Now().Add(8 * time.Minute))).To(BeTrue())
+		Expect(next.Before(time.Now().Add(12 * time.Minute))).To(BeTrue())
+		Expect(controllers.IsPeriodicEvaluationDue(reconciler, chc, cluster)).To(BeFalse())
+
+		requeueAfter := controllers.GetEvaluationRequeueAfter(reconciler, chc)
+		Expect(requeueAfter > 8*time.Minute).To(BeTrue())
+		Expect(requeueAfter <= 10*time.Minute).To(BeTrue())
+
+		reconciler.NextEvaluations[types.NamespacedName{Name: chc.Name}][*cluster] = time.Now().Add(-time.Second)
+		Expect(controllers.IsPeriodicEvaluationDue(reconciler, chc, cluster)).To(BeTrue())
+		Expect(controllers.GetEvaluationRequeueAfter(reconciler, chc)).To(Equal(time.Second))
+	})
+
+	It("periodic evaluation of a provisioned cluster does not redeploy", func() {
+		logger := textlogger.NewLogger(textlogger.NewConfig())
+		clusterNamespace := randomString()
+		clusterName := randomString()
+		clusterType := libsveltosv1beta1.ClusterTypeCapi
+
+		c := prepareClientWithClusterSummaryAndCHC(clusterNamespace, clusterName, clusterType)
+
+		// Add machine to mark Cluster ready
+		cpMachine := &clusterv1.Machine{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: clusterNamespace,
+				Name:      randomString(),
+				Labels: map[string]string{
+					clusterv1.ClusterNameLabel:         clusterName,
+					clusterv1.MachineControlPlaneLabel: "ok",
+				},
+			},
+		}
+		cpMachine.Status.SetTypedPhase(clusterv1.MachinePhaseRunning)
+		Expect(c.Create(context.TODO(), cpMachine)).To(Succeed())
+
+		chcs := &libsveltosv1beta1.ClusterHealthCheckList{}
+		Expect(c.List(context.TODO(), chcs)).To(Succeed())
+		Expect(len(chcs.Items)).To(Equal(1))
+		chc := &chcs.Items[0]
+
+		chc.Annotations = map[string]string{
+			controllers.ClusterHealthCheckOptionsAnnotation: "evaluationInterval: 10m",
+		}
+		Expect(c.Update(context.TODO(), chc)).To(Succeed())
+
+		cluster = &chc.Status.MatchingClusterRefs[0]
+		hash, err := controllers.ClusterHealthCheckHash(context.TODO(), c, chc, cluster)
+		Expect(err).To(BeNil())
+
+		// ClusterHealthCheck is already provisioned in the cluster
+		chc.Status.ClusterConditions = []libsveltosv1beta1.ClusterCondition{
+			{
+				ClusterInfo: libsveltosv1beta1.ClusterInfo{
+					Cluster: *cluster,
+					Hash:    hash,
+					Status:  libsveltosv1beta1.SveltosStatusProvisioned,
+				},
+			},
+		}
+		Expect(c.Status().Update(context.TODO(), chc)).To(Succeed())
+
+		dep := fakedeployer.GetClient(context.TODO(), logger, c)
+		controllers.RegisterFeatures(dep, logger)
+
+		reconciler := getClusterHealthCheckReconciler(c)
+		reconciler.Deployer = dep
+
+		// Periodic evaluation is due
+		reconciler.NextEvaluations[types.NamespacedName{Name: chc.Name}] = map[corev1.ObjectReference]time.Time{
+			*cluster: time.Now().Add(-time.Second),
+		}
+
+		chcScope, err := scope.NewClusterHealthCheckScope(scope.ClusterHealthCheckScopeParams{
+			Client:             c,
+			Logger:             logger,
+			ClusterHealthCheck: chc,
+			ControllerName:     "clusterhealthcheck",
+		})
+		Expect(err).To(BeNil())
+
+		f := controllers.GetHandlersForFeature(libsveltosv1beta1.FeatureClusterHealthCheck)
+		clusterInfo, err := controllers.ProcessClusterHealthCheck(reconciler, context.TODO(), chcScope,
+			cluster, f, logger)
+		Expect(err).To(BeNil())
+		Expect(clusterInfo).ToNot(BeNil())
+		Expect(clusterInfo.Status).To(Equal(libsveltosv1beta1.SveltosStatusProvisioned))
+
+		// Nothing is queued to be redeployed
+		Expect(dep.IsInProgress(clusterNamespace, clusterName, chc.Name, libsveltosv1beta1.FeatureClusterHealthCheck,
+			clusterType, false)).To(BeFalse())
+
+		// Liveness checks were evaluated and next evaluation scheduled
+		Expect(chc.Status.ClusterConditions[0].Conditions).To(HaveLen(len(chc.Spec.LivenessChecks)))
+		Expect(controllers.IsPeriodicEvaluationDue(reconciler, chc, cluster)).To(BeFalse())
+	})
+})
@@ -0,0 +1,267 @@
+/*
+Copyright 2023. projectsveltos.io. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"fmt"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+
+	libsveltosv1beta1 "github.com/projectsveltos/libsveltos/api/v1beta1"
+)
+
+const (
+	// ClusterHealthCheckOptionsAnnotation can be set on a ClusterHealthCheck to tune how liveness
+	// checks are evaluated. Its value is a YAML (or JSON) document matching clusterHealthCheckOptions.
+	// ClusterHealthCheck API is defined in libsveltos, so settings only relevant to healthcheck-manager
+	// are kept here.
+	ClusterHealthCheckOptionsAnnotation = "healthcheck.projectsveltos.io/options"
+)
+
+// clusterHealthCheckOptions contains the settings a ClusterHealthCheck can express via the
+// ClusterHealthCheckOptionsAnnotation.
+type clusterHealthCheckOptions struct {
+	// LivenessChecks contains per liveness check settings. Key is the liveness check name.
+	LivenessChecks map[string]livenessCheckOptions `json:"livenessChecks,omitempty"`
+
+	// AggregateChecks are evaluated over all clusters matching the ClusterHealthCheck. Results are stored
+	// in the ClusterHealthCheck state ConfigMap (chc-state-<name> in ReportNamespace).
+	AggregateChecks []aggregateCheckOptions `json:"aggregateChecks,omitempty"`
+
+	// CompositeChecks combine liveness checks with boolean logic. They are evaluated, per cluster,
+	// after all liveness checks.
+	CompositeChecks []compositeCheckOptions `json:"compositeChecks,omitempty"`
+
+	// Notifications contains per notification settings. Key is the notification name.
+	Notifications map[string]notificationOptions `json:"notifications,omitempty"`
+
+	// Escalations lists escalation tiers, in increasing After order. When a liveness check has been
+	// failing for longer than a tier After, the tier notifications are delivered once. Notifications
+	// listed in a tier are delivered only on escalation. Escalation starts over when the liveness check
+	// recovers.
+	Escalations []escalationTierOptions `json:"escalations,omitempty"`
+
+	// EvaluationInterval, when set, causes all liveness checks to be re-evaluated in each matching
+	// cluster at this interval (randomly varied by up to 10% per cluster), even if nothing changed.
+	// When any liveness check sets a FailureThreshold, SuccessThreshold, FlapDetection or NotifyAfter,
+	// any notification sets a ReminderInterval or Escalations are set, defaults to one minute.
+	EvaluationInterval *metav1.Duration `json:"evaluationInterval,omitempty"`
+
+	// NotifyOnUnknown indicates whether a liveness check moving to or from Unknown (data needed to
+	// evaluate it is missing, stale or cannot be evaluated) causes notifications to be delivered.
+	// Defaults to true.
+	NotifyOnUnknown *bool `json:"notifyOnUnknown,omitempty"`
+}
+
+// notifyOnUnknown returns whether liveness checks moving to/from Unknown are notified
+func (o *clusterHealthCheckOptions) notifyOnUnknown() bool {
+	return o.NotifyOnUnknown == nil || *o.NotifyOnUnknown
+}
+
+// notificationOptions contains settings for a single notification.
+type notificationOptions struct {
+	// CompositesOnly, when set, limits the notification to composite checks. Individual liveness
+	// checks are neither reported nor cause the notification to be delivered.
+	CompositesOnly bool `json:"compositesOnly,omitempty"`
+
+	// ReminderInterval, when set, causes the notification to be sent again, as a reminder, at this
+	// interval while any liveness check it reports on keeps failing.
+	ReminderInterval *metav1.Duration `json:"reminderInterval,omitempty"`
+}
+
+// escalationTierOptions defines an escalation tier
+type escalationTierOptions struct {
+	// After is how long a liveness check must have been failing before the tier is reached
+	After metav1.Duration `json:"after"`
+
+	// Notifications lists the ClusterHealthCheck notifications (by name) delivered when the tier is reached
+	Notifications []string `json:"notifications"`
+}
+
+// flapDetectionOptions configures flap detection. A liveness check is flapping when its status changes
+// too often within a sliding window. While flapping, status changes are not notified: a single
+// notification is sent when flapping starts and one when it stops.
+type flapDetectionOptions struct {
+	// Window is the sliding window status changes are counted over. Defaults to one hour.
+	Window *metav1.Duration `json:"window,omitempty"`
+
+	// StartThreshold is the number of status changes within Window which makes the liveness check
+	// flapping. Defaults to 5.
+	StartThreshold *int `json:"startThreshold,omitempty"`
+
+	// StopThreshold is the number of status changes within Window at or below which a flapping liveness
+	// check stops flapping. Defaults to half StartThreshold.
+	StopThreshold *int `json:"stopThreshold,omitempty"`
+}
+
+// compositeCheckOptions defines a composite check. A composite check fails when its expression
+// evaluates to true.
+type compositeCheckOptions struct {
+	// Name of the composite check. Must be unique within the ClusterHealthCheck.
+	Name string `json:"name"`
+
+	compositeExpression `json:",inline"`
+}
+
+// compositeExpression is a boolean expression over liveness checks. Exactly one field must be set.
+type compositeExpression struct {
+	// LivenessCheck is true when the liveness check with this name is failing
+	LivenessCheck string `json:"livenessCheck,omitempty"`
+
+	// And is true when all expressions are true
+	And []compositeExpression `json:"and,omitempty"`
+
+	// Or is true when at least one expression is true
+	Or []compositeExpression `json:"or,omitempty"`
+
+	// Not is true when expression is false
+	Not *compositeExpression `json:"not,omitempty"`
+
+	// AtLeast, together with Of, is true when at least AtLeast of the expressions in Of are true
+	AtLeast *int `json:"atLeast,omitempty"`
+
+	// Of contains the expressions AtLeast refers to
+	Of []compositeExpression `json:"of,omitempty"`
+}
+
+// aggregateCheckOptions defines a fleet level check. A cluster is healthy when all considered liveness
+// checks are passing. The aggregate check fails when the number of healthy clusters falls below the
+// threshold.
+type aggregateCheckOptions struct {
+	// Name of the aggregate check. Must be unique within the ClusterHealthCheck.
+	Name string `json:"name"`
+
+	// LivenessChecks lists the liveness checks considered to decide whether a cluster is healthy.
+	// When not set, all liveness checks are considered.
+	LivenessChecks []string `json:"livenessChecks,omitempty"`
+
+	// MinHealthyClusters is the minimum number of healthy clusters
+	MinHealthyClusters *int `json:"minHealthyClusters,omitempty"`
+
+	// MinHealthyPercentage is the minimum percentage (0-100) of healthy clusters
+	MinHealthyPercentage *int `json:"minHealthyPercentage,omitempty"`
+
+	// Notifications lists the ClusterHealthCheck notifications (by name) delivered when the aggregate
+	// check changes state. When not set, all ClusterHealthCheck notifications are delivered.
+	Notifications []string `json:"notifications,omitempty"`
+}
+
+// livenessCheckOptions contains settings for a single liveness check.
+type livenessCheckOptions struct {
+	// MaxReportAge is, for SveltosAgent liveness checks, the maximum time since reports were last
+	// received from the managed cluster before the check fails.
+	// For HealthCheck liveness checks, it is the maximum time since sveltos-agent last refreshed a
+	// HealthCheckReport before the report is considered stale. When not set, reports never go stale.
+	MaxReportAge *metav1.Duration `json:"maxReportAge,omitempty"`
+
+	// SveltosAgentNamespace is, for SveltosAgent liveness checks, the namespace of the sveltos-agent
+	// Deployment in the managed cluster. Defaults to projectsveltos.
+	SveltosAgentNamespace string `json:"sveltosAgentNamespace,omitempty"`
+
+	// SveltosAgentDeployment is, for SveltosAgent liveness checks, the name of the sveltos-agent
+	// Deployment in the managed cluster. Defaults to sveltos-agent-manager.
+	SveltosAgentDeployment string `json:"sveltosAgentDeployment,omitempty"`
+
+	// FailOnStaleReport is, for HealthCheck liveness checks, whether a stale HealthCheckReport makes
+	// the check fail. By default the check is reported as Unknown.
+	FailOnStaleReport bool `json:"failOnStaleReport,omitempty"`
+
+	// FailureThreshold is the number of consecutive failing evaluations before the liveness check is
+	// reported as failing. Defaults to 1.
+	FailureThreshold *int `json:"failureThreshold,omitempty"`
+
+	// SuccessThreshold is the number of consecutive passing evaluations before a failing liveness check
+	// is reported as passing again. Defaults to 1.
+	SuccessThreshold *int `json:"successThreshold,omitempty"`
+
+	// NotifyAfter, when set, delays notifying a failing liveness check till it has been failing
+	// for this long. A liveness check recovering before then is never notified.
+	NotifyAfter *metav1.Duration `json:"notifyAfter,omitempty"`
+
+	// FlapDetection, when set, enables flap detection for the liveness check
+	FlapDetection *flapDetectionOptions `json:"flapDetection,omitempty"`
+
+	// MaxNotRunningMachines is, for ClusterAPI liveness checks, the number of Machines which can be
+	// in a phase other than Running before the check fails. Defaults to 0.
+	MaxNotRunningMachines *int `json:"maxNotRunningMachines,omitempty"`
+
+	// MaxUnhealthyMachines is, for ClusterAPI liveness checks, the number of Machines a MachineHealthCheck
+	// can flag as unhealthy before the check fails. Defaults to 0.
+	MaxUnhealthyMachines *int `json:"maxUnhealthyMachines,omitempty"`
+
+	// MaxMissingReplicas is, for ClusterAPI liveness checks, how many ready replicas the control plane and
+	// each MachineDeployment can be below the desired number before the check fails. Defaults to 0.
+	MaxMissingReplicas *int `json:"maxMissingReplicas,omitempty"`
+
+	// ClusterProfiles is, for Addons liveness checks, the list of ClusterProfile names whose add-ons
+	// are verified. When neither ClusterProfiles nor Profiles is set, all add-ons are verified.
+	ClusterProfiles []string `json:"clusterProfiles,omitempty"`
+
+	// Profiles is, for Addons liveness checks, the list of Profile names (in the cluster namespace)
+	// whose add-ons are verified.
+	Profiles []string `json:"profiles,omitempty"`
+
+	// FeatureIDs is, for Addons liveness checks, the list of features (Helm, Resources, Kustomize)
+	// verified. When not set, all features are verified.
+	FeatureIDs []string `json:"featureIDs,omitempty"`
+
+	// Expression is, for HealthCheck liveness checks, a CEL expression evaluated over the cluster's
+	// HealthCheckReports which must evaluate to true for the check to pass (for instance "degraded < 3").
+	// When not set, the check passes only if all resources are Healthy.
+	Expression string `json:"expression,omitempty"`
+
+	// MessageExpression is, for HealthCheck liveness checks, a CEL expression evaluating to a string.
+	// Used as message when Expression evaluates to false.
+	MessageExpression string `json:"messageExpression,omitempty"`
+}
+
+// getClusterHealthCheckOptions parses the ClusterHealthCheckOptionsAnnotation.
+// Returns empty options if the annotation is not set.
+func getClusterHealthCheckOptions(chc *libsveltosv1beta1.ClusterHealthCheck) (*clusterHealthCheckOptions, error) {
+	options := &clusterHealthCheckOptions{}
+
+	annotations := chc.GetAnnotations()
+	if annotations == nil {
+		return options, nil
+	}
+
+	value, ok := annotations[ClusterHealthCheckOptionsAnnotation]
+	if !ok || value == "" {
+		return options, nil
+	}
+
+	if err := yaml.UnmarshalStrict([]byte(value), options); err != nil {
+		return nil, fmt.Errorf("failed to parse annotation %s: %w", ClusterHealthCheckOptionsAnnotation, err)
+	}
+
+	return options, nil
+}
+
+// getLivenessCheckOptions returns the options for a given liveness check. If none is defined,
+// empty options are returned.
+func getLivenessCheckOptions(chc *libsveltosv1beta1.ClusterHealthCheck, livenessCheck *libsveltosv1beta1.LivenessCheck,
+) (*livenessCheckOptions, error) {
+
+	options, err := getClusterHealthCheckOptions(chc)
+	if err != nil {
+		return nil, err
+	}
+
+	lcOptions := options.LivenessChecks[livenessCheck.Name]
+	return &lcOptions, nil
+}
@@ -0,0 +1,78 @@
+/*
+Copyright 2023. projectsveltos.io. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers_test
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/projectsveltos/healthcheck-manager/controllers"
+	libsveltosv1beta1 "github.com/projectsveltos/libsveltos/api/v1beta1"
+)
+
+var _ = Describe("ClusterHealthCheck options", func() {
+	It("getLivenessCheckOptions returns options for the liveness check", func() {
+		livenessCheck := libsveltosv1beta1.LivenessCheck{
+			Name: randomString(),
+			Type: controllers.LivenessTypeSveltosAgent,
+		}
+
+		chc := &libsveltosv1beta1.ClusterHealthCheck{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: randomString(),
+			},
+			Spec: libsveltosv1beta1.ClusterHealthCheckSpec{
+				LivenessChecks: []libsveltosv1beta1.LivenessCheck{livenessCheck},
+			},
+		}
+
+		options, err := controllers.GetLivenessCheckOptions(chc, &livenessCheck)
+		Expect(err).To(BeNil())
+		Expect(options.MaxReportAge).To(BeNil())
+
+		chc.Annotations = map[string]string{
+			controllers.ClusterHealthCheckOptionsAnnotation: `
+livenessChecks:
+  ` + livenessCheck.Name + `:
+    maxReportAge: 2m
+`,
+		}
+
+		options, err = controllers.GetLivenessCheckOptions(chc, &livenessCheck)
+		Expect(err).To(BeNil())
+		Expect(options.MaxReportAge).ToNot(BeNil())
+		Expect(options.MaxReportAge.Duration).To(Equal(2 * time.Minute))
+	})
+
+	It("getClusterHealthCheckOptions returns an error when annotation is malformed", func() {
+		chc := &libsveltosv1beta1.ClusterHealthCheck{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: randomString(),
+				Annotations: map[string]string{
+					controllers.ClusterHealthCheckOptionsAnnotation: "unknownField: true",
+				},
+			},
+		}
+
+		_, err := controllers.GetClusterHealthCheckOptions(chc)
+		Expect(err).ToNot(BeNil())
+	})
+})
@@ -0,0 +1,221 @@
+/*
+Copyright 2023. projectsveltos.io. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	libsveltosv1beta1 "github.com/projectsveltos/libsveltos/api/v1beta1"
+	"github.com/projectsveltos/libsveltos/lib/clusterproxy"
+)
+
+const (
+	// CollectionStatusLabel is set on the ConfigMaps (in ReportNamespace) storing, per managed cluster,
+	// the status of report collection. Its value is the report kind.
+	CollectionStatusLabel = "healthcheck.projectsveltos.io/collection-status"
+
+	collectionStatusConfigMapSuffix = "-collection-status"
+
+	// collectionStatusFlushInterval is the minimum interval between updates of the collection
+	// status ConfigMap
+	collectionStatusFlushInterval = 30 * time.Second
+)
+
+// clusterCollectionStatus is the status of report collection from a managed cluster
+type clusterCollectionStatus struct {
+	// ClusterNamespace is the namespace of the cluster
+	ClusterNamespace string `json:"clusterNamespace"`
+
+	// ClusterName is the name of the cluster
+	ClusterName string `json:"clusterName"`
+
+	// ClusterType is the type of the cluster
+	ClusterType string `json:"clusterType"`
+
+	// LastSuccessfulCollection is the last time reports were successfully collected
+	LastSuccessfulCollection *metav1.Time `json:"lastSuccessfulCollection,omitempty"`
+
+	// LastError is the error returned by the last failed collection
+	LastError string `json:"lastError,omitempty"`
+
+	// LastErrorTime is the last time collection failed
+	LastErrorTime *metav1.Time `json:"lastErrorTime,omitempty"`
+
+	// ConsecutiveFailures is the number of collections failed since the last successful one
+	ConsecutiveFailures int `json:"consecutiveFailures"`
+
+	// AgentCompatible indicates whether the sveltos-agent version running in the cluster is
+	// compatible. Not set till compatibility is verified.
+	AgentCompatible *bool `json:"agentCompatible,omitempty"`
+}
+
+var (
+	// healthCheckReportCollectionStatus and reloaderReportCollectionStatus track reports both collected
+	// from managed clusters and posted to the report ingestion endpoint
+	healthCheckReportCollectionStatus = newCollectionStatusTracker("HealthCheckReports")
+	reloaderReportCollectionStatus    = newCollectionStatusTracker("ReloaderReports")
+)
+
+// collectionStatusTracker tracks, per managed cluster, the status of report collection. Status is
+// periodically stored in a ConfigMap and exposed as metrics.
+type collectionStatusTracker struct {
+	reportKind string
+
+	// mux protects statuses and lastFlush
+	mux sync.Mutex
+	// key: ConfigMap key identifying the managed cluster
+	statuses  map[string]*clusterCollectionStatus
+	lastFlush time.Time
+}
+
+func newCollectionStatusTracker(reportKind string) *collectionStatusTracker {
+	return &collectionStatusTracker{
+		reportKind: reportKind,
+		statuses:   make(map[string]*clusterCollectionStatus),
+	}
+}
+
+// getCollectionStatusConfigMapName returns the name of the ConfigMap storing collection status for
+// reportKind
+func getCollectionStatusConfigMapName(reportKind string) string {
+	return strings.ToLower(reportKind) + collectionStatusConfigMapSuffix
+}
+
+// record updates the collection status of a cluster with the result of a collection
+func (t *collectionStatusTracker) record(cluster *corev1.ObjectReference, collectionErr error) {
+	clusterType := clusterproxy.GetClusterType(cluster)
+	key := getStateKey(cluster.Namespace, cluster.Name, clusterType)
+	now := metav1.Now()
+
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	status, ok := t.statuses[key]
+	if !ok {
+		status = &clusterCollectionStatus{
+			ClusterNamespace: cluster.Namespace,
+			ClusterName:      cluster.Name,
+			ClusterType:      string(clusterType),
+		}
+		t.statuses[key] = status
+	}
+
+	if collectionErr == nil {
+		status.LastSuccessfulCollection = &now
+		status.ConsecutiveFailures = 0
+		status.AgentCompatible = ptr.To(true)
+	} else {
+		status.LastError = collectionErr.Error()
+		status.LastErrorTime = &now
+		status.ConsecutiveFailures++
+		if collectionErr.Error() == compatibilityErrorMsg {
+			status.AgentCompatible = ptr.To(false)
+		}
+	}
+
+	setCollectionStatusMetrics(t.reportKind, status)
+}
+
+// prune removes status for clusters not in clusterList anymore
+func (t *collectionStatusTracker) prune(clusterList []corev1.ObjectReference) {
+	current := make(map[string]bool, len(clusterList))
+	for i := range clusterList {
+		current[getStateKey(clusterList[i].Namespace, clusterList[i].Name,
+			clusterproxy.GetClusterType(&clusterList[i]))] = true
+	}
+
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	for key, status := range t.statuses {
+		if !current[key] {
+			delete(t.statuses, key)
+			deleteCollectionStatusMetrics(t.reportKind, status)
+		}
+	}
+}
+
+// remove removes status for a deleted cluster
+func (t *collectionStatusTracker) remove(clusterNamespace, clusterName string,
+	clusterType libsveltosv1beta1.ClusterType) {
+
+	key := getStateKey(clusterNamespace, clusterName, clusterType)
+
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	if status, ok := t.statuses[key]; ok {
+		delete(t.statuses, key)
+		deleteCollectionStatusMetrics(t.reportKind, status)
+	}
+}
+
+// flush stores collection status in the ConfigMap, unless it was stored less than
+// collectionStatusFlushInterval ago
+func (t *collectionStatusTracker) flush(ctx context.Context, c client.Client) error {
+	t.mux.Lock()
+	if time.Since(t.lastFlush) < collectionStatusFlushInterval {
+		t.mux.Unlock()
+		return nil
+	}
+	data := make(map[string]string, len(t.statuses))
+	for key, status := range t.statuses {
+		value, err := json.Marshal(status)
+		if err != nil {
+			t.mux.Unlock()
+			return err
+		}
+		data[key] = string(value)
+	}
+	t.lastFlush = time.Now()
+	t.mux.Unlock()
+
+	name := getCollectionStatusConfigMapName(t.reportKind)
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		configMap := &corev1.ConfigMap{}
+		err := c.Get(ctx, types.NamespacedName{Namespace: ReportNamespace, Name: name}, configMap)
+		if err != nil {
+			if !apierrors.IsNotFound(err) {
+				return err
+			}
+			configMap = &corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: ReportNamespace,
+					Name:      name,
+					Labels:    map[string]string{CollectionStatusLabel: t.reportKind},
+				},
+				Data: data,
+			}
+			return c.Create(ctx, configMap)
+		}
+
+		configMap.Data = data
+		return c.Update(ctx, configMap)
+	})
+}
@@ -0,0 +1,106 @@
+/*
+Copyright 2023. projectsveltos.io. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/projectsveltos/healthcheck-manager/controllers"
+	libsveltosv1beta1 "github.com/projectsveltos/libsveltos/api/v1beta1"
+)
+
+var _ = Describe("Collection status", func() {
+	var clusterList []corev1.ObjectReference
+
+	BeforeEach(func() {
+		clusterList = make([]corev1.ObjectReference, 0)
+		for range 2 {
+			clusterList = append(clusterList, corev1.ObjectReference{
+				Namespace:  randomString(),
+				Name:       randomString(),
+				Kind:       libsveltosv1beta1.SveltosClusterKind,
+				APIVersion: libsveltosv1beta1.GroupVersion.String(),
+			})
+		}
+	})
+
+	It("flush stores per cluster collection status in a ConfigMap", func() {
+		const reportKind = "HealthCheckReports"
+
+		c := fake.NewClientBuilder().WithScheme(scheme).Build()
+
+		tracker := controllers.NewCollectionStatusTracker(reportKind)
+		controllers.RecordCollectionStatus(tracker, &clusterList[0], nil)
+		controllers.RecordCollectionStatus(tracker, &clusterList[1], errors.New("connection refused"))
+		controllers.RecordCollectionStatus(tracker, &clusterList[1], errors.New("compatibility checks failed"))
+
+		Expect(controllers.FlushCollectionStatus(tracker, context.TODO(), c)).To(Succeed())
+
+		configMap := &corev1.ConfigMap{}
+		Expect(c.Get(context.TODO(), types.NamespacedName{Namespace: controllers.ReportNamespace,
+			Name: controllers.GetCollectionStatusConfigMapName(reportKind)}, configMap)).To(Succeed())
+		Expect(configMap.Labels).To(HaveKeyWithValue(controllers.CollectionStatusLabel, reportKind))
+		Expect(configMap.Data).To(HaveLen(2))
+
+		statuses := make(map[string]map[string]interface{})
+		for _, value := range configMap.Data {
+			status := make(map[string]interface{})
+			Expect(json.Unmarshal([]byte(value), &status)).To(Succeed())
+			statuses[status["clusterName"].(string)] = status
+		}
+
+		healthy := statuses[clusterList[0].Name]
+		Expect(healthy).ToNot(BeNil())
+		Expect(healthy["lastSuccessfulCollection"]).ToNot(BeNil())
+		Expect(healthy["consecutiveFailures"]).To(BeEquivalentTo(0))
+		Expect(healthy["agentCompatible"]).To(BeTrue())
+
+		failing := statuses[clusterList[1].Name]
+		Expect(failing).ToNot(BeNil())
+		Expect(failing).ToNot(HaveKey("lastSuccessfulCollection"))
+		Expect(failing["consecutiveFailures"]).To(BeEquivalentTo(2))
+		Expect(failing["lastError"]).To(Equal("compatibility checks failed"))
+		Expect(failing["agentCompatible"]).To(BeFalse())
+	})
+
+	It("prune removes status of clusters not managed anymore", func() {
+		const reportKind = "ReloaderReports"
+
+		c := fake.NewClientBuilder().WithScheme(scheme).Build()
+
+		tracker := controllers.NewCollectionStatusTracker(reportKind)
+		controllers.RecordCollectionStatus(tracker, &clusterList[0], nil)
+		controllers.RecordCollectionStatus(tracker, &clusterList[1], nil)
+		controllers.PruneCollectionStatus(tracker, clusterList[1:])
+
+		Expect(controllers.FlushCollectionStatus(tracker, context.TODO(), c)).To(Succeed())
+
+		configMap := &corev1.ConfigMap{}
+		Expect(c.Get(context.TODO(), types.NamespacedName{Namespace: controllers.ReportNamespace,
+			Name: controllers.GetCollectionStatusConfigMapName(reportKind)}, configMap)).To(Succeed())
+		Expect(configMap.Data).To(HaveLen(1))
+	})
+})
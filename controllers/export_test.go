@@ -16,6 +16,18 @@ limitations under the License.
 
 package controllers
 
+import (
+	"context"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/watch"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	libsveltosv1beta1 "github.com/projectsveltos/libsveltos/api/v1beta1"
+)
+
 var (
 	RequeueClusterHealthCheckForCluster = (*ClusterHealthCheckReconciler).requeueClusterHealthCheckForCluster
 	RequeueClusterHealthCheckForMachine = (*ClusterHealthCheckReconciler).requeueClusterHealthCheckForMachine
@@ -26,8 +38,13 @@ var (
 	GetClusterMapForEntry   = (*ClusterHealthCheckReconciler).getClusterMapForEntry
 
 	ProcessClusterHealthCheck = (*ClusterHealthCheckReconciler).processClusterHealthCheck
+	ClusterHealthCheckHash    = clusterHealthCheckHash
 	IsClusterEntryRemoved     = (*ClusterHealthCheckReconciler).isClusterEntryRemoved
 	UpdateClusterConditions   = (*ClusterHealthCheckReconciler).updateClusterConditions
+
+	IsPeriodicEvaluationDue   = (*ClusterHealthCheckReconciler).isPeriodicEvaluationDue
+	ScheduleNextEvaluation    = (*ClusterHealthCheckReconciler).scheduleNextEvaluation
+	GetEvaluationRequeueAfter = (*ClusterHealthCheckReconciler).getEvaluationRequeueAfter
 )
 
 var (
@@ -55,6 +72,26 @@ var (
 	EvaluateLivenessCheckAddOns  = evaluateLivenessCheckAddOns
 	EvaluateLivenessCheck        = evaluateLivenessCheck
 
+	EvaluateLivenessCheckHealthCheck = evaluateLivenessCheckHealthCheck
+	IsNotifiableChange               = isNotifiableChange
+	ApplyThresholds                  = applyThresholds
+	GetLivenessCheckStates           = getLivenessCheckStates
+	UpdateLivenessCheckStates        = updateLivenessCheckStates
+	RemoveClusterStates              = removeClusterStates
+	GetClusterStateConfigMapName     = getClusterStateConfigMapName
+	DetectFlapping                   = detectFlapping
+	GetFlappingReason                = getFlappingReason
+	GetNotificationMessage           = getNotificationMessage
+	IsFailureNotificationDue         = isFailureNotificationDue
+	GetStaleReportRequeueAfter       = getStaleReportRequeueAfter
+	IsReminderDue                    = isReminderDue
+	IsEscalationNotification         = isEscalationNotification
+	GetEscalations                   = getEscalations
+	GetAcknowledgements              = getAcknowledgements
+	ApplyAcknowledgements            = applyAcknowledgements
+	ProcessAcknowledgements          = processAcknowledgements
+	GetAcknowledgedConditions        = getAcknowledgedConditions
+
 	DoSendNotification         = doSendNotification
 	BuildNotificationStatusMap = buildNotificationStatusMap
 
@@ -72,6 +109,40 @@ var (
 	RemoveHealthCheckReports                       = removeHealthCheckReports
 	RemoveHealthCheckReportsFromCluster            = removeHealthCheckReportsFromCluster
 	CollectAndProcessHealthCheckReportsFromCluster = collectAndProcessHealthCheckReportsFromCluster
+	RecordHealthCheckReportCollection              = recordHealthCheckReportCollection
+	IsHealthCheckReportUpToDate                    = isHealthCheckReportUpToDate
+	CollectHealthCheckReports                      = collectHealthCheckReports
+)
+
+func SetCollectionStartTime(t time.Time) {
+	collectionMux.Lock()
+	defer collectionMux.Unlock()
+	collectionStartTime = t
+}
+
+// SetCollectionClock sets the function returning the current time when recording and verifying
+// collection times
+func SetCollectionClock(now func() time.Time) {
+	collectionClock = now
+}
+
+var (
+	IsSveltosAgentRunning     = isSveltosAgentRunning
+	GetSveltosAgentDeployment = getSveltosAgentDeployment
+	AreReportsRecent          = areReportsRecent
+
+	GetClusterHealthCheckOptions = getClusterHealthCheckOptions
+	GetLivenessCheckOptions      = getLivenessCheckOptions
+
+	EvaluateLivenessCheckClusterAPI = evaluateLivenessCheckClusterAPI
+	GetClusterAPIState              = getClusterAPIState
+	EvaluateCELExpression           = evaluateCELExpression
+
+	EvaluateAggregateChecks = evaluateAggregateChecks
+	GetAggregateState       = getAggregateState
+
+	EvaluateCompositeChecks   = evaluateCompositeChecks
+	GetNotificationConditions = getNotificationConditions
 )
 
 var (
@@ -96,3 +167,105 @@ func GetSlackChannelID(info *slackInfo) string {
 func GetSlackToken(info *slackInfo) string {
 	return info.token
 }
+
+type (
+	LivenessCheckState      = livenessCheckState
+	NotificationState       = notificationState
+	ClusterEscalationStates = clusterEscalationStates
+	Acknowledgement         = acknowledgement
+)
+
+var (
+	Sweep                 = (*clusterCollector).sweep
+	GetCollectionBackoff  = getCollectionBackoff
+	GetCollectionBackoffs = func(cc *clusterCollector) int {
+		cc.mux.Lock()
+		defer cc.mux.Unlock()
+		return len(cc.backoffs)
+	}
+)
+
+func NewClusterCollector(settings CollectionSettings, interval time.Duration,
+	collect func(ctx context.Context, cluster *corev1.ObjectReference) error) *clusterCollector {
+
+	return newClusterCollector(settings, interval, "Reports", collect)
+}
+
+var (
+	SyncWatches       = (*reportWatcher).sync
+	UnwatchedClusters = (*reportWatcher).unwatchedClusters
+	GetWatches        = func(w *reportWatcher) int {
+		w.mux.Lock()
+		defer w.mux.Unlock()
+		return len(w.watches)
+	}
+)
+
+func NewHealthCheckReportWatcher(c client.Client) *reportWatcher {
+	return newReportWatcher(c, "", "HealthCheckReports", CollectionSettings{},
+		func() client.ObjectList { return &libsveltosv1beta1.HealthCheckReportList{} },
+		func(_ context.Context, _ *corev1.ObjectReference) error { return nil },
+		func(_ context.Context, _ client.Client, _ *corev1.ObjectReference, _ watch.EventType,
+			_ client.Object, _ logr.Logger) {
+		})
+}
+
+var (
+	NewCollectionStatusTracker       = newCollectionStatusTracker
+	RecordCollectionStatus           = (*collectionStatusTracker).record
+	PruneCollectionStatus            = (*collectionStatusTracker).prune
+	FlushCollectionStatus            = (*collectionStatusTracker).flush
+	GetCollectionStatusConfigMapName = getCollectionStatusConfigMapName
+	GetCollectionStatus              = func(reportKind, clusterNamespace, clusterName string,
+		clusterType libsveltosv1beta1.ClusterType) *clusterCollectionStatus {
+
+		t := healthCheckReportCollectionStatus
+		if reportKind == reloaderReportCollectionStatus.reportKind {
+			t = reloaderReportCollectionStatus
+		}
+		t.mux.Lock()
+		defer t.mux.Unlock()
+		return t.statuses[getStateKey(clusterNamespace, clusterName, clusterType)]
+	}
+)
+
+var (
+	IsClusterUnreachable  = isClusterUnreachable
+	ErrClusterUnreachable = errClusterUnreachable
+)
+
+// SetCircuitBreakerClock sets the function circuit breakers use to get the current time
+func SetCircuitBreakerClock(now func() time.Time) {
+	breakers.now = now
+}
+
+func NewCircuitBreakerClient(c client.WithWatch, clusterNamespace, clusterName string,
+	clusterType libsveltosv1beta1.ClusterType) client.WithWatch {
+
+	return &circuitBreakerClient{WithWatch: c, clusterNamespace: clusterNamespace, clusterName: clusterName,
+		clusterType: clusterType}
+}
+
+var (
+	NewRemoteClientCache            = newRemoteClientCache
+	GetCachedRemoteClient           = (*remoteClientCache).get
+	InvalidateRemoteClientOnSecret  = (*remoteClientCache).invalidateOnSecretChange
+	InvalidateRemoteClientOnCluster = (*remoteClientCache).invalidateOnClusterChange
+	GetRemoteClientCache            = func() *remoteClientCache { return remoteClients }
+	SetRemoteClientCacheClock       = func(rc *remoteClientCache, now func() time.Time) { rc.now = now }
+	RequeueSveltosClusterForSecret  = (*SveltosClusterReconciler).requeueSveltosClusterForSecret
+	GetRemoteClientCacheSize        = func(rc *remoteClientCache) int {
+		rc.mux.Lock()
+		defer rc.mux.Unlock()
+		return len(rc.entries)
+	}
+)
+
+var (
+	GetReportIngestionSecretName = getReportIngestionSecretName
+	GetReportIngestionHandler    = (*reportIngestionServer).handler
+)
+
+var (
+	RemoveOrphanedHealthCheckReports = removeOrphanedHealthCheckReports
+)
@@ -24,6 +24,7 @@ import (
 	"k8s.io/apimachinery/pkg/runtime"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 
 	libsveltosv1beta1 "github.com/projectsveltos/libsveltos/api/v1beta1"
@@ -37,6 +38,8 @@ type HealthCheckReconciler struct {
 	HealthCheckReportMode ReportMode
 	ShardKey              string // when set, only clusters matching the ShardKey will be reconciled
 	Version               string
+	CollectionSettings    CollectionSettings
+	GCSettings            HealthCheckReportGCSettings
 }
 
 // +kubebuilder:rbac:groups=lib.projectsveltos.io,resources=healthchecks,verbs=get;list;watch;create;update;patch;delete
@@ -77,9 +80,27 @@ func (r *HealthCheckReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 }
 
 // SetupWithManager sets up the controller with the Manager.
-func (r *HealthCheckReconciler) SetupWithManager(mgr ctrl.Manager) error {
-	if r.HealthCheckReportMode == CollectFromManagementCluster {
-		go collectHealthCheckReports(mgr.GetClient(), r.ShardKey, r.Version, mgr.GetLogger())
+func (r *HealthCheckReconciler) SetupWithManager(mgr ctrl.Manager, collectionInterval int) error {
+	if r.HealthCheckReportMode == CollectFromManagementCluster || r.HealthCheckReportMode == WatchFromManagementCluster {
+		// Added as a Runnable: collection only runs on the leader and stops when the manager stops
+		err := mgr.Add(manager.RunnableFunc(func(ctx context.Context) error {
+			collectHealthCheckReports(ctx, mgr.GetClient(), collectionInterval, r.ShardKey, r.Version,
+				r.CollectionSettings, r.HealthCheckReportMode == WatchFromManagementCluster, mgr.GetLogger())
+			return nil
+		}))
+		if err != nil {
+			return err
+		}
+	}
+
+	if r.GCSettings.Interval > 0 {
+		err := mgr.Add(manager.RunnableFunc(func(ctx context.Context) error {
+			collectOrphanedHealthCheckReports(ctx, mgr.GetClient(), r.GCSettings, mgr.GetLogger())
+			return nil
+		}))
+		if err != nil {
+			return err
+		}
 	}
 
 	return ctrl.NewControllerManagedBy(mgr).
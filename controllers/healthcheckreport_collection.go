@@ -20,13 +20,16 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"reflect"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/go-logr/logr"
 	corev1 "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 
 	libsveltosv1beta1 "github.com/projectsveltos/libsveltos/api/v1beta1"
@@ -38,6 +41,23 @@ import (
 const (
 	malformedLabelError = "healthCheckReport is malformed. Labels is empty"
 	missingLabelError   = "healthCheckReport is malformed. Label missing"
+
+	// HealthCheckReportLastRefreshAnnotation is set on HealthCheckReports in the management cluster.
+	// It contains (RFC3339 format) the last time sveltos-agent refreshed the HealthCheckReport
+	// in the managed cluster.
+	HealthCheckReportLastRefreshAnnotation = "healthcheck.projectsveltos.io/last-refresh"
+)
+
+var (
+	// collectionMux protects collectionStartTime and lastCollectionTimes
+	collectionMux sync.RWMutex
+	// collectionStartTime is when this process started collecting HealthCheckReports (or receiving them
+	// through the report ingestion endpoint). Zero if HealthCheckReports are not collected by this process.
+	collectionStartTime time.Time
+	// key: managed cluster; value: last time HealthCheckReports were successfully collected
+	lastCollectionTimes = make(map[string]time.Time)
+	// collectionClock returns the current time when recording and verifying collection times
+	collectionClock = time.Now
 )
 
 // removeHealthCheckReports deletes all HealthCheckReport corresponding to HealthCheck instance
@@ -98,32 +118,74 @@ func removeHealthCheckReportsFromCluster(ctx context.Context, c client.Client, c
 }
 
 // Periodically collects HealthCheckReports from each managed cluster.
-func collectHealthCheckReports(c client.Client, shardKey, version string, logger logr.Logger) {
-	interval := 10 * time.Second
-	if shardKey != "" {
-		// This controller will only fetch ClassifierReport instances
-		// so it can be more aggressive
-		interval = 5 * time.Second
+// collectionInterval is in seconds. When zero, defaults to 10 seconds (5 seconds if shardKey is set).
+// If watchReports is set, HealthCheckReports are watched in each managed cluster. Only clusters which
+// cannot be watched are then polled.
+func collectHealthCheckReports(ctx context.Context, c client.Client, collectionInterval int, shardKey, version string,
+	settings CollectionSettings, watchReports bool, logger logr.Logger) {
+
+	interval := time.Duration(collectionInterval) * time.Second
+	if interval <= 0 {
+		interval = 10 * time.Second
+		if shardKey != "" {
+			// This controller will only fetch ClassifierReport instances
+			// so it can be more aggressive
+			interval = 5 * time.Second
+		}
+	}
+	logger.V(logs.LogInfo).Info(fmt.Sprintf("HealthCheckReport collection time is set to %s", interval))
+
+	startHealthCheckReportCollection()
+
+	status := healthCheckReportCollectionStatus
+	collect := func(ctx context.Context, cluster *corev1.ObjectReference) error {
+		err := collectAndProcessHealthCheckReportsFromCluster(ctx, c, cluster, version, logger)
+		status.record(cluster, err)
+		return err
+	}
+	collector := newClusterCollector(settings, interval, "HealthCheckReports", collect)
+
+	var watcher *reportWatcher
+	if watchReports {
+		watcher = newReportWatcher(c, version, "HealthCheckReports", settings,
+			func() client.ObjectList { return &libsveltosv1beta1.HealthCheckReportList{} }, collect,
+			func(ctx context.Context, remoteClient client.Client, cluster *corev1.ObjectReference,
+				eventType watch.EventType, obj client.Object, logger logr.Logger) {
+
+				processHealthCheckReportEvent(ctx, c, remoteClient, cluster, eventType, obj, logger)
+			})
 	}
 
-	ctx := context.TODO()
 	for {
 		logger.V(logs.LogDebug).Info("collecting HealthCheckReports")
 		clusterList, err := clusterproxy.GetListOfClustersForShardKey(ctx, c, "", shardKey, logger)
 		if err != nil {
 			logger.V(logs.LogInfo).Info(fmt.Sprintf("failed to get clusters: %v", err))
+		} else {
+			status.prune(clusterList)
 		}
 
-		for i := range clusterList {
-			cluster := &clusterList[i]
-			err = collectAndProcessHealthCheckReportsFromCluster(ctx, c, cluster, version, logger)
-			if err != nil {
-				logger.V(logs.LogInfo).Info(fmt.Sprintf("failed to collect HealthCheckReports from cluster: %s %s/%s %v",
-					cluster.Kind, cluster.Namespace, cluster.Name, err))
-			}
+		if watcher != nil {
+			watcher.sync(ctx, clusterList, logger)
+			// Clusters being watched do not need to be polled
+			clusterList = watcher.unwatchedClusters(clusterList)
+		}
+
+		collector.sweep(ctx, clusterList, logger)
+
+		if err := status.flush(ctx, c); err != nil {
+			logger.V(logs.LogInfo).Info(fmt.Sprintf("failed to store HealthCheckReport collection status: %v", err))
 		}
 
-		time.Sleep(interval)
+		select {
+		case <-ctx.Done():
+			if watcher != nil {
+				watcher.wait()
+			}
+			logger.V(logs.LogInfo).Info("stop collecting HealthCheckReports")
+			return
+		case <-time.After(interval):
+		}
 	}
 }
 
@@ -148,8 +210,8 @@ func collectAndProcessHealthCheckReportsFromCluster(ctx context.Context, c clien
 	}
 
 	var remoteClient client.Client
-	remoteClient, err = clusterproxy.GetKubernetesClient(ctx, c, cluster.Namespace, cluster.Name,
-		"", "", clusterproxy.GetClusterType(clusterRef), logger)
+	remoteClient, err = getRemoteClient(ctx, c, cluster.Namespace, cluster.Name,
+		clusterproxy.GetClusterType(clusterRef), logger)
 	if err != nil {
 		return err
 	}
@@ -166,34 +228,91 @@ func collectAndProcessHealthCheckReportsFromCluster(ctx context.Context, c clien
 		return err
 	}
 
+	recordHealthCheckReportCollection(cluster.Namespace, cluster.Name, clusterproxy.GetClusterType(clusterRef))
+
+	// Number of writes (to management and managed cluster) avoided because HealthCheckReports
+	// were already processed
+	avoidedWrites := 0
+	defer func() {
+		if avoidedWrites > 0 {
+			logger.V(logs.LogDebug).Info(fmt.Sprintf("avoided %d HealthCheckReport writes", avoidedWrites))
+			recordHealthCheckReportAvoidedWrites(avoidedWrites)
+		}
+	}()
+
 	for i := range healthCheckReportList.Items {
 		hcr := &healthCheckReportList.Items[i]
-		l := logger.WithValues("healthCheckReport", hcr.Name)
-		// First update/delete healthCheckReports in managemnent cluster
-		if !hcr.DeletionTimestamp.IsZero() {
-			logger.V(logs.LogDebug).Info("deleting from management cluster")
-			err = deleteHealthCheckReport(ctx, c, cluster, hcr, l)
-			if err != nil {
-				logger.V(logs.LogInfo).Info(fmt.Sprintf("failed to delete HealthCheckReport in management cluster. Err: %v", err))
-			}
-		} else {
-			logger.V(logs.LogDebug).Info("updating in management cluster")
-			err = updateHealthCheckReport(ctx, c, cluster, hcr, l)
-			if err != nil {
-				logger.V(logs.LogInfo).Info(fmt.Sprintf("failed to update HealthCheckReport in management cluster. Err: %v", err))
-			}
+		avoidedWrites += processCollectedHealthCheckReport(ctx, c, remoteClient, cluster, hcr,
+			logger.WithValues("healthCheckReport", hcr.Name))
+	}
+
+	return nil
+}
+
+// processCollectedHealthCheckReport copies a HealthCheckReport collected from a managed cluster to the
+// management cluster and marks it as processed in the managed cluster.
+// Returns the number of writes avoided because the HealthCheckReport was already processed.
+func processCollectedHealthCheckReport(ctx context.Context, c, remoteClient client.Client,
+	cluster *corev1.ObjectReference, hcr *libsveltosv1beta1.HealthCheckReport, logger logr.Logger) int {
+
+	refreshed := isHealthCheckReportRefreshed(hcr)
+	if hcr.DeletionTimestamp.IsZero() && !refreshed && isHealthCheckReportUpToDate(ctx, c, cluster, hcr) {
+		// Already processed and not changed since
+		logger.V(logs.LogVerbose).Info("already processed")
+		return 2
+	}
+	// First update/delete healthCheckReports in managemnent cluster
+	if !hcr.DeletionTimestamp.IsZero() {
+		logger.V(logs.LogDebug).Info("deleting from management cluster")
+		err := deleteHealthCheckReport(ctx, c, cluster, hcr, logger)
+		if err != nil {
+			logger.V(logs.LogInfo).Info(fmt.Sprintf("failed to delete HealthCheckReport in management cluster. Err: %v", err))
 		}
-		logger.V(logs.LogDebug).Info("updating in managed cluster")
-		// Update HealthCheckReport Status in managed cluster
-		phase := libsveltosv1beta1.ReportProcessed
-		hcr.Status.Phase = &phase
-		err = remoteClient.Status().Update(ctx, hcr)
+	} else {
+		logger.V(logs.LogDebug).Info("updating in management cluster")
+		err := updateHealthCheckReport(ctx, c, cluster, hcr, logger)
 		if err != nil {
-			logger.V(logs.LogInfo).Info(fmt.Sprintf("failed to update HealthCheckReport in managed cluster. Err: %v", err))
+			logger.V(logs.LogInfo).Info(fmt.Sprintf("failed to update HealthCheckReport in management cluster. Err: %v", err))
+		}
+	}
+	if !refreshed {
+		// Phase is already ReportProcessed
+		return 1
+	}
+	logger.V(logs.LogDebug).Info("updating in managed cluster")
+	// Update HealthCheckReport Status in managed cluster
+	phase := libsveltosv1beta1.ReportProcessed
+	hcr.Status.Phase = &phase
+	err := remoteClient.Status().Update(ctx, hcr)
+	if err != nil {
+		logger.V(logs.LogInfo).Info(fmt.Sprintf("failed to update HealthCheckReport in managed cluster. Err: %v", err))
+	}
+
+	return 0
+}
+
+// processHealthCheckReportEvent processes a HealthCheckReport event received by watching a managed cluster
+func processHealthCheckReportEvent(ctx context.Context, c, remoteClient client.Client, cluster *corev1.ObjectReference,
+	eventType watch.EventType, obj client.Object, logger logr.Logger) {
+
+	hcr, ok := obj.(*libsveltosv1beta1.HealthCheckReport)
+	if !ok {
+		return
+	}
+
+	recordHealthCheckReportCollection(cluster.Namespace, cluster.Name, clusterproxy.GetClusterType(cluster))
+
+	if eventType == watch.Deleted {
+		logger.V(logs.LogDebug).Info("deleting from management cluster")
+		if err := deleteHealthCheckReport(ctx, c, cluster, hcr, logger); err != nil {
+			logger.V(logs.LogInfo).Info(fmt.Sprintf("failed to delete HealthCheckReport in management cluster. Err: %v", err))
 		}
+		return
 	}
 
-	return nil
+	if avoidedWrites := processCollectedHealthCheckReport(ctx, c, remoteClient, cluster, hcr, logger); avoidedWrites > 0 {
+		recordHealthCheckReportAvoidedWrites(avoidedWrites)
+	}
 }
 
 func deleteHealthCheckReport(ctx context.Context, c client.Client, cluster *corev1.ObjectReference,
@@ -275,6 +394,7 @@ func updateHealthCheckReport(ctx context.Context, c client.Client, cluster *core
 			currentHealthCheckReport.Spec.ClusterNamespace = cluster.Namespace
 			currentHealthCheckReport.Spec.ClusterName = cluster.Name
 			currentHealthCheckReport.Spec.ClusterType = clusterType
+			setHealthCheckReportLastRefresh(currentHealthCheckReport, time.Now())
 			return c.Create(ctx, currentHealthCheckReport)
 		}
 		return err
@@ -287,5 +407,113 @@ func updateHealthCheckReport(ctx context.Context, c client.Client, cluster *core
 	currentHealthCheckReport.Spec.ClusterType = clusterType
 	currentHealthCheckReport.Labels = libsveltosv1beta1.GetHealthCheckReportLabels(
 		healthCheckName, cluster.Name, &clusterType)
+	if isHealthCheckReportRefreshed(healthCheckReport) {
+		// sveltos-agent updated the HealthCheckReport since it was last processed
+		setHealthCheckReportLastRefresh(currentHealthCheckReport, time.Now())
+	}
 	return c.Update(ctx, currentHealthCheckReport)
 }
+
+// isHealthCheckReportUpToDate returns true if the HealthCheckReport in the management cluster matches
+// the one in the managed cluster
+func isHealthCheckReportUpToDate(ctx context.Context, c client.Client, cluster *corev1.ObjectReference,
+	healthCheckReport *libsveltosv1beta1.HealthCheckReport) bool {
+
+	healthCheckName, ok := healthCheckReport.Labels[libsveltosv1beta1.HealthCheckNameLabel]
+	if !ok {
+		return false
+	}
+
+	clusterType := clusterproxy.GetClusterType(cluster)
+	healthCheckReportName := libsveltosv1beta1.GetHealthCheckReportName(healthCheckName, cluster.Name, &clusterType)
+
+	currentHealthCheckReport := &libsveltosv1beta1.HealthCheckReport{}
+	err := c.Get(ctx,
+		types.NamespacedName{Namespace: cluster.Namespace, Name: healthCheckReportName},
+		currentHealthCheckReport)
+	if err != nil {
+		return false
+	}
+
+	expectedSpec := healthCheckReport.Spec
+	expectedSpec.ClusterNamespace = cluster.Namespace
+	expectedSpec.ClusterName = cluster.Name
+	expectedSpec.ClusterType = clusterType
+
+	return reflect.DeepEqual(currentHealthCheckReport.Spec, expectedSpec) &&
+		reflect.DeepEqual(currentHealthCheckReport.Labels,
+			libsveltosv1beta1.GetHealthCheckReportLabels(healthCheckName, cluster.Name, &clusterType))
+}
+
+// isHealthCheckReportRefreshed returns true if the HealthCheckReport in the managed cluster was
+// updated by sveltos-agent since it was last processed
+func isHealthCheckReportRefreshed(healthCheckReport *libsveltosv1beta1.HealthCheckReport) bool {
+	return healthCheckReport.Status.Phase == nil ||
+		*healthCheckReport.Status.Phase != libsveltosv1beta1.ReportProcessed
+}
+
+// setHealthCheckReportLastRefresh sets the HealthCheckReportLastRefreshAnnotation
+func setHealthCheckReportLastRefresh(healthCheckReport *libsveltosv1beta1.HealthCheckReport, refreshTime time.Time) {
+	annotations := healthCheckReport.GetAnnotations()
+	if annotations == nil {
+		annotations = make(map[string]string)
+	}
+	annotations[HealthCheckReportLastRefreshAnnotation] = refreshTime.UTC().Format(time.RFC3339)
+	healthCheckReport.SetAnnotations(annotations)
+}
+
+// getHealthCheckReportLastRefresh returns the last time the HealthCheckReport was refreshed.
+// If HealthCheckReportLastRefreshAnnotation is not set (or is malformed), for instance because
+// sveltos-agent writes HealthCheckReports directly to the management cluster, the most recent
+// write recorded in the managed fields (or the creation time) is used instead.
+func getHealthCheckReportLastRefresh(healthCheckReport *libsveltosv1beta1.HealthCheckReport) time.Time {
+	if value, ok := healthCheckReport.GetAnnotations()[HealthCheckReportLastRefreshAnnotation]; ok {
+		if refreshTime, err := time.Parse(time.RFC3339, value); err == nil {
+			return refreshTime
+		}
+	}
+
+	lastRefresh := healthCheckReport.CreationTimestamp.Time
+	for i := range healthCheckReport.ManagedFields {
+		entry := &healthCheckReport.ManagedFields[i]
+		if entry.Time != nil && entry.Time.After(lastRefresh) {
+			lastRefresh = entry.Time.Time
+		}
+	}
+
+	return lastRefresh
+}
+
+// startHealthCheckReportCollection records when this process started collecting HealthCheckReports,
+// unless already recorded
+func startHealthCheckReportCollection() {
+	collectionMux.Lock()
+	defer collectionMux.Unlock()
+
+	if collectionStartTime.IsZero() {
+		collectionStartTime = collectionClock()
+	}
+}
+
+// recordHealthCheckReportCollection stores the time HealthCheckReports were successfully collected
+// from a managed cluster
+func recordHealthCheckReportCollection(clusterNamespace, clusterName string,
+	clusterType libsveltosv1beta1.ClusterType) {
+
+	collectionMux.Lock()
+	defer collectionMux.Unlock()
+
+	lastCollectionTimes[getClusterKey(clusterNamespace, clusterName, clusterType)] = collectionClock()
+}
+
+// getLastHealthCheckReportCollection returns the last time HealthCheckReports were successfully collected
+// from a managed cluster (zero if never) and the time this process started collecting. Latter is zero
+// if this process is not collecting HealthCheckReports.
+func getLastHealthCheckReportCollection(clusterNamespace, clusterName string,
+	clusterType libsveltosv1beta1.ClusterType) (lastCollection, startTime time.Time) {
+
+	collectionMux.RLock()
+	defer collectionMux.RUnlock()
+
+	return lastCollectionTimes[getClusterKey(clusterNamespace, clusterName, clusterType)], collectionStartTime
+}
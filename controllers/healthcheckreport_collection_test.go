@@ -18,6 +18,7 @@ package controllers_test
 
 import (
 	"context"
+	"time"
 
 	"github.com/go-logr/logr"
 	. "github.com/onsi/ginkgo/v2"
@@ -137,6 +138,58 @@ var _ = Describe("HealthCheck Deployer", func() {
 
 		validateHealthCheckReports(healthCheckName, cluster, &clusterType)
 	})
+
+	It("isHealthCheckReportUpToDate returns true only when management cluster copy matches", func() {
+		cluster := &corev1.ObjectReference{
+			Namespace:  randomString(),
+			Name:       randomString(),
+			Kind:       libsveltosv1beta1.SveltosClusterKind,
+			APIVersion: libsveltosv1beta1.GroupVersion.String(),
+		}
+		clusterType := libsveltosv1beta1.ClusterTypeSveltos
+
+		// HealthCheckReport in the managed cluster
+		healthCheckReport := getHealthCheckReport(healthCheck.Name, "", "")
+
+		c := fake.NewClientBuilder().WithScheme(scheme).Build()
+		Expect(controllers.IsHealthCheckReportUpToDate(context.TODO(), c, cluster, healthCheckReport)).To(BeFalse())
+
+		// Copy in the management cluster
+		currentHealthCheckReport := &libsveltosv1beta1.HealthCheckReport{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: cluster.Namespace,
+				Name:      libsveltosv1beta1.GetHealthCheckReportName(healthCheck.Name, cluster.Name, &clusterType),
+				Labels:    libsveltosv1beta1.GetHealthCheckReportLabels(healthCheck.Name, cluster.Name, &clusterType),
+			},
+			Spec: healthCheckReport.Spec,
+		}
+		currentHealthCheckReport.Spec.ClusterNamespace = cluster.Namespace
+		currentHealthCheckReport.Spec.ClusterName = cluster.Name
+		currentHealthCheckReport.Spec.ClusterType = clusterType
+		Expect(c.Create(context.TODO(), currentHealthCheckReport)).To(Succeed())
+		Expect(controllers.IsHealthCheckReportUpToDate(context.TODO(), c, cluster, healthCheckReport)).To(BeTrue())
+
+		healthCheckReport.Spec.ResourceStatuses = []libsveltosv1beta1.ResourceStatus{
+			{HealthStatus: libsveltosv1beta1.HealthStatusDegraded},
+		}
+		Expect(controllers.IsHealthCheckReportUpToDate(context.TODO(), c, cluster, healthCheckReport)).To(BeFalse())
+	})
+
+	It("collectHealthCheckReports returns once context is canceled", func() {
+		c := fake.NewClientBuilder().WithScheme(scheme).Build()
+
+		ctx, cancel := context.WithCancel(context.TODO())
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			controllers.CollectHealthCheckReports(ctx, c, 1, "", version, controllers.CollectionSettings{},
+				true, logger)
+		}()
+
+		Consistently(done, 2*time.Second).ShouldNot(BeClosed())
+		cancel()
+		Eventually(done, 5*time.Second).Should(BeClosed())
+	})
 })
 
 func validateHealthCheckReports(healthCheckName string, cluster *clusterv1.Cluster, clusterType *libsveltosv1beta1.ClusterType) {
@@ -0,0 +1,197 @@
+/*
+Copyright 2023. projectsveltos.io. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-logr/logr"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	libsveltosv1beta1 "github.com/projectsveltos/libsveltos/api/v1beta1"
+	"github.com/projectsveltos/libsveltos/lib/clusterproxy"
+	logs "github.com/projectsveltos/libsveltos/lib/logsettings"
+)
+
+const (
+	// DefaultHealthCheckReportGCInterval is the default interval between garbage collections of
+	// orphaned HealthCheckReports
+	DefaultHealthCheckReportGCInterval = 10 * time.Minute
+)
+
+// Reasons a HealthCheckReport is orphaned
+const (
+	orphanedHealthCheckMissing        = "healthcheck"
+	orphanedClusterMissing            = "cluster"
+	orphanedClusterHealthCheckMissing = "clusterhealthcheck"
+)
+
+// HealthCheckReportGCSettings contains settings of the garbage collection of orphaned HealthCheckReports
+type HealthCheckReportGCSettings struct {
+	// Interval is the interval between garbage collections. Zero disables garbage collection.
+	Interval time.Duration
+
+	// DryRun, when set, only logs and counts orphaned HealthCheckReports. Nothing is deleted.
+	DryRun bool
+}
+
+// collectOrphanedHealthCheckReports periodically deletes HealthCheckReports whose HealthCheck, cluster or
+// ClusterHealthCheck owner does not exist anymore. Those are normally removed when HealthCheck or cluster
+// is deleted, but are left behind if that happens while this controller is down.
+func collectOrphanedHealthCheckReports(ctx context.Context, c client.Client, settings HealthCheckReportGCSettings,
+	logger logr.Logger) {
+
+	logger = logger.WithName("healthcheckreport-gc")
+	if settings.DryRun {
+		logger = logger.WithValues("dryRun", true)
+	}
+
+	for {
+		logger.V(logs.LogDebug).Info("collecting orphaned HealthCheckReports")
+		if err := removeOrphanedHealthCheckReports(ctx, c, settings.DryRun, logger); err != nil {
+			logger.V(logs.LogInfo).Info(fmt.Sprintf("failed to collect orphaned HealthCheckReports: %v", err))
+		}
+
+		select {
+		case <-ctx.Done():
+			logger.V(logs.LogInfo).Info("stop collecting orphaned HealthCheckReports")
+			return
+		case <-time.After(settings.Interval):
+		}
+	}
+}
+
+// removeOrphanedHealthCheckReports deletes (unless dryRun is set) all orphaned HealthCheckReports
+func removeOrphanedHealthCheckReports(ctx context.Context, c client.Client, dryRun bool, logger logr.Logger) error {
+	healthCheckReportList := &libsveltosv1beta1.HealthCheckReportList{}
+	err := c.List(ctx, healthCheckReportList)
+	if err != nil {
+		return err
+	}
+
+	for i := range healthCheckReportList.Items {
+		hcr := &healthCheckReportList.Items[i]
+		if !hcr.DeletionTimestamp.IsZero() {
+			continue
+		}
+
+		l := logger.WithValues("healthCheckReport", fmt.Sprintf("%s/%s", hcr.Namespace, hcr.Name))
+		reason, err := getHealthCheckReportOrphanedReason(ctx, c, hcr)
+		if err != nil {
+			l.V(logs.LogDebug).Info(fmt.Sprintf("failed to verify whether HealthCheckReport is orphaned: %v", err))
+			continue
+		}
+		if reason == "" {
+			continue
+		}
+
+		recordOrphanedHealthCheckReport(reason)
+		if dryRun {
+			l.V(logs.LogInfo).Info(fmt.Sprintf("orphaned HealthCheckReport (%s not found)", reason))
+			continue
+		}
+
+		l.V(logs.LogInfo).Info(fmt.Sprintf("deleting orphaned HealthCheckReport (%s not found)", reason))
+		err = c.Delete(ctx, hcr)
+		if err != nil && !apierrors.IsNotFound(err) {
+			l.V(logs.LogInfo).Info(fmt.Sprintf("failed to delete orphaned HealthCheckReport: %v", err))
+			continue
+		}
+		recordDeletedOrphanedHealthCheckReport(reason)
+	}
+
+	setHealthCheckReportGCLastRun(time.Now())
+	return nil
+}
+
+// getHealthCheckReportOrphanedReason returns why the HealthCheckReport is orphaned: its HealthCheck,
+// cluster or ClusterHealthCheck owner does not exist. Returns an empty string if it is not orphaned.
+func getHealthCheckReportOrphanedReason(ctx context.Context, c client.Client,
+	hcr *libsveltosv1beta1.HealthCheckReport) (string, error) {
+
+	healthCheckName := hcr.Spec.HealthCheckName
+	if name, ok := hcr.Labels[libsveltosv1beta1.HealthCheckNameLabel]; ok {
+		healthCheckName = name
+	}
+	if healthCheckName != "" {
+		healthCheck := &libsveltosv1beta1.HealthCheck{}
+		err := c.Get(ctx, types.NamespacedName{Name: healthCheckName}, healthCheck)
+		if err != nil {
+			if apierrors.IsNotFound(err) {
+				return orphanedHealthCheckMissing, nil
+			}
+			return "", err
+		}
+	}
+
+	if hcr.Spec.ClusterName != "" {
+		_, err := clusterproxy.GetCluster(ctx, c, hcr.Spec.ClusterNamespace, hcr.Spec.ClusterName,
+			hcr.Spec.ClusterType)
+		if err != nil {
+			if apierrors.IsNotFound(err) {
+				return orphanedClusterMissing, nil
+			}
+			if meta.IsNoMatchError(err) {
+				// Cluster type not installed (e.g. ClusterAPI). Cannot tell whether cluster exists.
+				return "", nil
+			}
+			return "", err
+		}
+	}
+
+	owned, ownerExists, err := hasClusterHealthCheckOwner(ctx, c, hcr)
+	if err != nil {
+		return "", err
+	}
+	if owned && !ownerExists {
+		return orphanedClusterHealthCheckMissing, nil
+	}
+
+	return "", nil
+}
+
+// hasClusterHealthCheckOwner returns whether HealthCheckReport is owned by any ClusterHealthCheck and, if
+// so, whether any of those still exists
+func hasClusterHealthCheckOwner(ctx context.Context, c client.Client,
+	hcr *libsveltosv1beta1.HealthCheckReport) (owned, ownerExists bool, err error) {
+
+	for _, ref := range hcr.OwnerReferences {
+		if ref.Kind != libsveltosv1beta1.ClusterHealthCheckKind {
+			continue
+		}
+		owned = true
+
+		chc := &libsveltosv1beta1.ClusterHealthCheck{}
+		err = c.Get(ctx, types.NamespacedName{Name: ref.Name}, chc)
+		if err != nil {
+			if apierrors.IsNotFound(err) {
+				continue
+			}
+			return owned, false, err
+		}
+		if ref.UID == "" || ref.UID == chc.UID {
+			return owned, true, nil
+		}
+	}
+
+	return owned, false, nil
+}
@@ -0,0 +1,144 @@
+/*
+Copyright 2023. projectsveltos.io. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers_test
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2/textlogger"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/projectsveltos/healthcheck-manager/controllers"
+	libsveltosv1beta1 "github.com/projectsveltos/libsveltos/api/v1beta1"
+)
+
+var _ = Describe("HealthCheckReport garbage collection", func() {
+	var sveltosCluster *libsveltosv1beta1.SveltosCluster
+	var healthCheck *libsveltosv1beta1.HealthCheck
+	var chc *libsveltosv1beta1.ClusterHealthCheck
+
+	BeforeEach(func() {
+		sveltosCluster = &libsveltosv1beta1.SveltosCluster{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: randomString(),
+				Name:      randomString(),
+			},
+		}
+
+		healthCheck = &libsveltosv1beta1.HealthCheck{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: randomString(),
+			},
+		}
+
+		chc = &libsveltosv1beta1.ClusterHealthCheck{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: randomString(),
+				UID:  types.UID(randomString()),
+			},
+		}
+	})
+
+	getHealthCheckReport := func(healthCheckName, clusterName string,
+		owners ...metav1.OwnerReference) *libsveltosv1beta1.HealthCheckReport {
+
+		return &libsveltosv1beta1.HealthCheckReport{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: sveltosCluster.Namespace,
+				Name:      randomString(),
+				Labels: map[string]string{
+					libsveltosv1beta1.HealthCheckNameLabel: healthCheckName,
+				},
+				OwnerReferences: owners,
+			},
+			Spec: libsveltosv1beta1.HealthCheckReportSpec{
+				HealthCheckName:  healthCheckName,
+				ClusterNamespace: sveltosCluster.Namespace,
+				ClusterName:      clusterName,
+				ClusterType:      libsveltosv1beta1.ClusterTypeSveltos,
+			},
+		}
+	}
+
+	chcOwner := func(uid types.UID) metav1.OwnerReference {
+		return metav1.OwnerReference{
+			APIVersion: libsveltosv1beta1.GroupVersion.String(),
+			Kind:       libsveltosv1beta1.ClusterHealthCheckKind,
+			Name:       chc.Name,
+			UID:        uid,
+		}
+	}
+
+	buildReports := func() (valid, orphaned []*libsveltosv1beta1.HealthCheckReport) {
+		valid = []*libsveltosv1beta1.HealthCheckReport{
+			getHealthCheckReport(healthCheck.Name, sveltosCluster.Name),
+			getHealthCheckReport(healthCheck.Name, sveltosCluster.Name, chcOwner(chc.UID)),
+		}
+		orphaned = []*libsveltosv1beta1.HealthCheckReport{
+			// HealthCheck does not exist
+			getHealthCheckReport(randomString(), sveltosCluster.Name),
+			// Cluster does not exist
+			getHealthCheckReport(healthCheck.Name, randomString()),
+			// ClusterHealthCheck owner was recreated
+			getHealthCheckReport(healthCheck.Name, sveltosCluster.Name, chcOwner(types.UID(randomString()))),
+		}
+		return valid, orphaned
+	}
+
+	buildClient := func(reports ...*libsveltosv1beta1.HealthCheckReport) client.Client {
+		objects := []client.Object{sveltosCluster, healthCheck, chc}
+		for i := range reports {
+			objects = append(objects, reports[i])
+		}
+		return fake.NewClientBuilder().WithScheme(scheme).WithObjects(objects...).Build()
+	}
+
+	It("removeOrphanedHealthCheckReports deletes orphaned HealthCheckReports only", func() {
+		valid, orphaned := buildReports()
+		c := buildClient(append(valid, orphaned...)...)
+
+		logger := textlogger.NewLogger(textlogger.NewConfig())
+		Expect(controllers.RemoveOrphanedHealthCheckReports(context.TODO(), c, false, logger)).To(Succeed())
+
+		for i := range valid {
+			Expect(c.Get(context.TODO(), types.NamespacedName{Namespace: valid[i].Namespace, Name: valid[i].Name},
+				&libsveltosv1beta1.HealthCheckReport{})).To(Succeed())
+		}
+
+		healthCheckReports := &libsveltosv1beta1.HealthCheckReportList{}
+		Expect(c.List(context.TODO(), healthCheckReports)).To(Succeed())
+		Expect(len(healthCheckReports.Items)).To(Equal(len(valid)))
+	})
+
+	It("removeOrphanedHealthCheckReports does not delete anything in dry-run mode", func() {
+		valid, orphaned := buildReports()
+		c := buildClient(append(valid, orphaned...)...)
+
+		logger := textlogger.NewLogger(textlogger.NewConfig())
+		Expect(controllers.RemoveOrphanedHealthCheckReports(context.TODO(), c, true, logger)).To(Succeed())
+
+		healthCheckReports := &libsveltosv1beta1.HealthCheckReportList{}
+		Expect(c.List(context.TODO(), healthCheckReports)).To(Succeed())
+		Expect(len(healthCheckReports.Items)).To(Equal(len(valid) + len(orphaned)))
+	})
+})
@@ -19,6 +19,9 @@ package controllers
 import (
 	"context"
 	"fmt"
+	"slices"
+	"strings"
+	"time"
 
 	"github.com/go-logr/logr"
 	corev1 "k8s.io/api/core/v1"
@@ -31,25 +34,45 @@ import (
 )
 
 // evaluateLivenessCheck evaluates specific liveness check for a cluster.
+// If state is not nil, it is updated and liveness check failure/success thresholds, flap detection
+// and notification delay are applied.
 // Return values:
-// - bool indicating whether liveness check is passing
+// - liveness check status: ConditionTrue when passing, ConditionFalse when failing and ConditionUnknown
+// when data needed to evaluate it is missing, stale or cannot be evaluated
 // - bool indicating if liveness check changed state since last evaluation
 // - message is a human consumable information
 // - an error if any occurs
 func evaluateLivenessCheck(ctx context.Context, c client.Client, clusterNamespace, clusterName string,
 	clusterType libsveltosv1beta1.ClusterType, chc *libsveltosv1beta1.ClusterHealthCheck,
-	livenessCheck *libsveltosv1beta1.LivenessCheck, logger logr.Logger) (passing, statusChanged bool, message string, err error) {
+	livenessCheck *libsveltosv1beta1.LivenessCheck, state *livenessCheckState, logger logr.Logger,
+) (status corev1.ConditionStatus, statusChanged bool, message string, err error) {
 
 	logger = logger.WithValues("livenesscheck", fmt.Sprintf("%s:%s", livenessCheck.Type, livenessCheck.Name))
 	logger.V(logs.LogDebug).Info("evaluate liveness check type")
 
-	switch livenessCheck.Type {
-	case libsveltosv1beta1.LivenessTypeAddons:
-		passing, err = evaluateLivenessCheckAddOns(ctx, c, clusterNamespace, clusterName, clusterType,
+	var passing bool
+	switch {
+	case livenessCheck.Type == libsveltosv1beta1.LivenessTypeAddons:
+		passing, message, err = evaluateLivenessCheckAddOns(ctx, c, clusterNamespace, clusterName, clusterType,
 			chc, livenessCheck, logger)
-	case libsveltosv1beta1.LivenessTypeHealthCheck:
-		passing, message, err = evaluateLivenessCheckHealthCheck(ctx, c, clusterNamespace, clusterName, clusterType,
-			livenessCheck, logger)
+		status = getConditionStatus(passing)
+	case livenessCheck.Type == libsveltosv1beta1.LivenessTypeHealthCheck:
+		status, message, err = evaluateLivenessCheckHealthCheck(ctx, c, clusterNamespace, clusterName, clusterType,
+			chc, livenessCheck, logger)
+	case livenessCheck.Type == LivenessTypeSveltosAgent &&
+		isClusterUnreachable(clusterNamespace, clusterName, clusterType):
+		// Calls to the cluster fail fast. Liveness check fails without being evaluated.
+		// Other liveness check types only read the management cluster, so they are still evaluated.
+		logger.V(logs.LogDebug).Info("cluster unreachable")
+		status, message = corev1.ConditionFalse, clusterUnreachableMessage
+	case livenessCheck.Type == LivenessTypeSveltosAgent:
+		passing, message, err = evaluateLivenessCheckSveltosAgent(ctx, c, clusterNamespace, clusterName, clusterType,
+			chc, livenessCheck, logger)
+		status = getConditionStatus(passing)
+	case livenessCheck.Type == LivenessTypeClusterAPI:
+		passing, message, err = evaluateLivenessCheckClusterAPI(ctx, c, clusterNamespace, clusterName, clusterType,
+			chc, livenessCheck, logger)
+		status = getConditionStatus(passing)
 	default:
 		logger.V(logs.LogInfo).Info("no verification registered for liveness check")
 		panic(1)
@@ -60,25 +83,55 @@ func evaluateLivenessCheck(ctx context.Context, c client.Client, clusterNamespac
 		return
 	}
 
+	if state != nil {
+		var options *livenessCheckOptions
+		options, err = getLivenessCheckOptions(chc, livenessCheck)
+		if err != nil {
+			return
+		}
+		previous := getPreviousLivenessCheckStatus(chc, clusterNamespace, clusterName, clusterType, livenessCheck)
+		status, message = applyThresholds(options, previous, state, status, message)
+
+		if options.FlapDetection != nil {
+			if detectFlapping(options.FlapDetection, previous, state, status) {
+				// A single notification is sent when flapping starts or stops
+				logger.V(logs.LogDebug).Info(fmt.Sprintf("liveness check flapping: %t", state.Flapping))
+				statusChanged = true
+				return
+			}
+			if state.Flapping {
+				// Status changes are not notified while flapping
+				return
+			}
+		}
+
+		if options.NotifyAfter != nil {
+			if notify, ok := isFailureNotificationDue(options.NotifyAfter.Duration, previous, state, status); ok {
+				statusChanged = notify
+				return
+			}
+		}
+	}
+
 	statusChanged = hasLivenessCheckStatusChange(chc, clusterNamespace, clusterName, clusterType,
-		livenessCheck, passing, message)
+		livenessCheck, status, message)
 
 	return
 }
 
 // evaluateLivenessCheckHealthCheck evaluates status reported in corresponding HealthCheckReport.
 // Return values:
-// - bool indicating if any add-on deployment changed state since last evaluation
+// - liveness check status. ConditionUnknown if no HealthCheck is referenced, no HealthCheckReport
+// exists yet or reports cannot be evaluated
 // - human consumable message
 // - an error if any occurs
 func evaluateLivenessCheckHealthCheck(ctx context.Context, c client.Client, clusterNamespace, clusterName string,
-	clusterType libsveltosv1beta1.ClusterType, livenessCheck *libsveltosv1beta1.LivenessCheck,
-	logger logr.Logger) (allHealthy bool, message string, err error) {
+	clusterType libsveltosv1beta1.ClusterType, chc *libsveltosv1beta1.ClusterHealthCheck,
+	livenessCheck *libsveltosv1beta1.LivenessCheck, logger logr.Logger,
+) (status corev1.ConditionStatus, message string, err error) {
 
-	message = ""
-	allHealthy = true
 	if livenessCheck.LivenessSourceRef == nil {
-		return false, "", nil
+		return corev1.ConditionUnknown, "liveness check references no HealthCheck  \n", nil
 	}
 
 	var healthCheckReportList *libsveltosv1beta1.HealthCheckReportList
@@ -86,14 +139,38 @@ func evaluateLivenessCheckHealthCheck(ctx context.Context, c client.Client, clus
 		clusterName, livenessCheck.LivenessSourceRef.Name, clusterType)
 	if err != nil {
 		logger.V(logs.LogInfo).Info(fmt.Sprintf("failed to fetch healthCheckReports: %v", err))
-		return false, "", err
+		return corev1.ConditionFalse, "", err
 	}
 
 	if len(healthCheckReportList.Items) == 0 {
 		logger.V(logs.LogInfo).Info("did not find healthCheckReport")
-		return false, "", err
+		return corev1.ConditionUnknown, fmt.Sprintf("no HealthCheckReport received yet for HealthCheck %s  \n",
+			livenessCheck.LivenessSourceRef.Name), nil
+	}
+
+	options, err := getLivenessCheckOptions(chc, livenessCheck)
+	if err != nil {
+		return corev1.ConditionFalse, "", err
+	}
+
+	if options.MaxReportAge != nil {
+		staleMessage := getStaleHealthCheckReports(healthCheckReportList.Items, options.MaxReportAge.Duration)
+		if staleMessage != "" {
+			logger.V(logs.LogDebug).Info("healthCheckReports are stale")
+			if options.FailOnStaleReport {
+				return corev1.ConditionFalse, staleMessage, nil
+			}
+			return corev1.ConditionUnknown, staleMessage, nil
+		}
+	}
+
+	if options.Expression != "" {
+		status, message = evaluateCELExpression(healthCheckReportList.Items, options.Expression,
+			options.MessageExpression)
+		return status, message, nil
 	}
 
+	allHealthy := true
 	for i := range healthCheckReportList.Items {
 		hcr := &healthCheckReportList.Items[i]
 		if hcr.DeletionTimestamp.IsZero() {
@@ -105,66 +182,166 @@ func evaluateLivenessCheckHealthCheck(ctx context.Context, c client.Client, clus
 		}
 	}
 
-	return allHealthy, message, nil
+	return getConditionStatus(allHealthy), message, nil
+}
+
+// isFailureNotificationDue delays notifying a failing liveness check till it has been failing for notifyAfter.
+// If the outcome is decided by the delay, returns whether the liveness check change must be notified and true.
+// Otherwise returns false, false and the usual change detection applies.
+func isFailureNotificationDue(notifyAfter time.Duration, previous *libsveltosv1beta1.Condition,
+	state *livenessCheckState, status corev1.ConditionStatus) (notify, decided bool) {
+
+	if status != corev1.ConditionFalse {
+		if state.NotificationPending {
+			// Liveness check stopped failing before the failure was notified. Nothing to notify.
+			state.NotificationPending = false
+			return false, true
+		}
+		return false, false
+	}
+
+	failingSince := time.Now()
+	if previous != nil && previous.Status == corev1.ConditionFalse && !previous.LastTransitionTime.IsZero() {
+		failingSince = previous.LastTransitionTime.Time
+	}
+
+	if time.Since(failingSince) < notifyAfter {
+		state.NotificationPending = true
+		return false, true
+	}
+
+	if state.NotificationPending {
+		// Liveness check has now been failing for notifyAfter
+		state.NotificationPending = false
+		return true, true
+	}
+
+	return false, false
+}
+
+// getStaleHealthCheckReports returns a message listing all HealthCheckReports which were not refreshed
+// within maxReportAge. Empty if none is stale.
+func getStaleHealthCheckReports(reports []libsveltosv1beta1.HealthCheckReport, maxReportAge time.Duration) string {
+	var message string
+	for i := range reports {
+		hcr := &reports[i]
+		if !hcr.DeletionTimestamp.IsZero() {
+			continue
+		}
+		// Message reports the refresh time (not the age) so it does not change at every evaluation
+		lastRefresh := getHealthCheckReportLastRefresh(hcr)
+		if time.Since(lastRefresh) > maxReportAge {
+			message += fmt.Sprintf("HealthCheckReport %s is stale: last refreshed at %s  \n",
+				hcr.Name, lastRefresh.UTC().Format(time.RFC3339))
+		}
+	}
+
+	return message
 }
 
 // evaluateLivenessCheckAddOns evaluates whether all add-ons are deployed or not.
+// Liveness check options can limit verification to ClusterSummaries created by specific
+// ClusterProfiles/Profiles and to specific features.
 // Return values:
-// - bool indicating if any add-on deployment changed state since last evaluation
+// - bool indicating whether all add-ons are deployed
+// - human consumable message listing each failing ClusterSummary and feature
 // - an error if any occurs
 func evaluateLivenessCheckAddOns(ctx context.Context, c client.Client, clusterNamespace, clusterName string,
 	clusterType libsveltosv1beta1.ClusterType, chc *libsveltosv1beta1.ClusterHealthCheck,
-	livenessCheck *libsveltosv1beta1.LivenessCheck, logger logr.Logger) (bool, error) {
+	livenessCheck *libsveltosv1beta1.LivenessCheck, logger logr.Logger) (deployed bool, message string, err error) {
+
+	options, err := getLivenessCheckOptions(chc, livenessCheck)
+	if err != nil {
+		return false, "", err
+	}
 
 	clusterSummaries, err := fetchClusterSummaries(ctx, c, clusterNamespace, clusterName, clusterType)
 	if err != nil {
 		logger.V(logs.LogInfo).Info(fmt.Sprintf("failed to fetch clustersummmaries: %v", err))
-		return false, err
+		return false, "", err
 	}
 
-	deployed := true
+	deployed = true
 	for i := range clusterSummaries.Items {
 		cs := &clusterSummaries.Items[i]
-		if cs.DeletionTimestamp.IsZero() && !areAddonsDeployed(&clusterSummaries.Items[i]) {
+		if !cs.DeletionTimestamp.IsZero() || !isClusterSummaryMatchingProfiles(cs, options) {
+			continue
+		}
+		for _, msg := range getNotProvisionedFeatures(cs, options.FeatureIDs) {
 			deployed = false
+			message += msg
 		}
 	}
 
-	return deployed, nil
+	return deployed, message, nil
 }
 
 // hasLivenessCheckStatusChange returns true if the status for this liveness check has changed since last evaluation
 func hasLivenessCheckStatusChange(chc *libsveltosv1beta1.ClusterHealthCheck, clusterNamespace, clusterName string,
 	clusterType libsveltosv1beta1.ClusterType, livenessCheck *libsveltosv1beta1.LivenessCheck,
-	passing bool, message string) bool {
+	status corev1.ConditionStatus, message string) bool {
+
+	previousStatus := getPreviousLivenessCheckStatus(chc, clusterNamespace, clusterName, clusterType, livenessCheck)
+	if previousStatus == nil {
+		// No previous status found
+		return true
+	}
+
+	return hasStatusChanged(previousStatus, status, message)
+}
+
+// getPreviousLivenessCheckStatus returns the liveness check status for a cluster as of last evaluation.
+// Nil if liveness check was never evaluated for the cluster.
+func getPreviousLivenessCheckStatus(chc *libsveltosv1beta1.ClusterHealthCheck, clusterNamespace, clusterName string,
+	clusterType libsveltosv1beta1.ClusterType, livenessCheck *libsveltosv1beta1.LivenessCheck,
+) *libsveltosv1beta1.Condition {
 
 	for i := range chc.Status.ClusterConditions {
 		cc := &chc.Status.ClusterConditions[i]
 		if isClusterConditionForCluster(cc, clusterNamespace, clusterName, clusterType) {
-			previousStatus := getLivenessCheckStatus(cc, livenessCheck)
-			if previousStatus == nil {
-				// No previous status found
-				return true
-			}
-
-			return hasStatusChanged(previousStatus, passing, message)
+			return getLivenessCheckStatus(cc, livenessCheck)
 		}
 	}
 
-	// No previous status found
-	return true
+	return nil
+}
+
+// preserveLastTransitionTime keeps previous LastTransitionTime if condition status did not change
+// since previous evaluation
+func preserveLastTransitionTime(previous, condition *libsveltosv1beta1.Condition) {
+	if previous != nil && previous.Status == condition.Status && !previous.LastTransitionTime.IsZero() {
+		condition.LastTransitionTime = previous.LastTransitionTime
+	}
+}
+
+// formatConditionDuration returns for how long (rounded to minutes, or seconds if less than one
+// minute) condition has been in its current status. Empty if unknown.
+// Duration is only reported in notifications, never in the condition message stored in status: it
+// changes at every evaluation, so the condition would always look changed (triggering notifications
+// and clearing acknowledgements). In status, condition LastTransitionTime reports when the failure started.
+func formatConditionDuration(condition *libsveltosv1beta1.Condition) string {
+	if condition.LastTransitionTime.IsZero() {
+		return ""
+	}
+
+	d := time.Since(condition.LastTransitionTime.Time)
+	if d < time.Minute {
+		return d.Round(time.Second).String()
+	}
+
+	return strings.TrimSuffix(d.Round(time.Minute).String(), "0s")
 }
 
-func hasStatusChanged(previousStatus *libsveltosv1beta1.Condition, passing bool, message string) bool {
+func hasStatusChanged(previousStatus *libsveltosv1beta1.Condition, status corev1.ConditionStatus, message string) bool {
 	// if currently liveness check is passing
-	if passing {
+	if status == corev1.ConditionTrue {
 		// No change only if previous status was also conditionTrue
 		return previousStatus.Status != corev1.ConditionTrue
 	}
 
-	// No change only if previous status was conditionFalse
+	// No change only if previous status and message were the same
 	return previousStatus.Message != message ||
-		previousStatus.Status != corev1.ConditionFalse
+		previousStatus.Status != status
 }
 
 // getLivenessCheckStatus returns the liveness check status if ever set before. Nil otherwise
@@ -216,14 +393,55 @@ func fetchClusterSummaries(ctx context.Context, c client.Client, clusterNamespac
 // areAddonsDeployed returns whether all add-ons referenced by a ClusterSummary instance are deployed
 // or not.
 func areAddonsDeployed(clusterSummary *configv1beta1.ClusterSummary) bool {
+	return len(getNotProvisionedFeatures(clusterSummary, nil)) == 0
+}
+
+// getNotProvisionedFeatures returns a human consumable message for each feature of a ClusterSummary
+// which is not provisioned. If featureIDs is not empty, only those features are considered.
+func getNotProvisionedFeatures(clusterSummary *configv1beta1.ClusterSummary, featureIDs []string) []string {
+	messages := make([]string, 0)
 	for i := range clusterSummary.Status.FeatureSummaries {
-		fs := clusterSummary.Status.FeatureSummaries[i]
-		if fs.Status != configv1beta1.FeatureStatus(libsveltosv1beta1.SveltosStatusProvisioned) {
-			return false
+		fs := &clusterSummary.Status.FeatureSummaries[i]
+		if len(featureIDs) != 0 && !slices.Contains(featureIDs, string(fs.FeatureID)) {
+			continue
 		}
+		if fs.Status == configv1beta1.FeatureStatus(libsveltosv1beta1.SveltosStatusProvisioned) {
+			continue
+		}
+
+		msg := fmt.Sprintf("ClusterSummary %s/%s feature %s status is %s", clusterSummary.Namespace,
+			clusterSummary.Name, fs.FeatureID, fs.Status)
+		if fs.FailureMessage != nil && *fs.FailureMessage != "" {
+			msg += fmt.Sprintf(": %s", *fs.FailureMessage)
+		}
+		messages = append(messages, msg+"  \n")
+	}
+
+	return messages
+}
+
+// isClusterSummaryMatchingProfiles returns true if the ClusterSummary was created by one of the
+// ClusterProfiles/Profiles listed in the liveness check options. Always true if none is listed.
+func isClusterSummaryMatchingProfiles(clusterSummary *configv1beta1.ClusterSummary,
+	options *livenessCheckOptions) bool {
+
+	if len(options.ClusterProfiles) == 0 && len(options.Profiles) == 0 {
+		return true
+	}
+
+	owner, err := configv1beta1.GetProfileOwnerReference(clusterSummary)
+	if err != nil {
+		return false
+	}
+
+	switch owner.Kind {
+	case configv1beta1.ClusterProfileKind:
+		return slices.Contains(options.ClusterProfiles, owner.Name)
+	case configv1beta1.ProfileKind:
+		return slices.Contains(options.Profiles, owner.Name)
 	}
 
-	return true
+	return false
 }
 
 // isStatusHealthy returns whether state is Healthy.
@@ -267,6 +485,34 @@ func getConditionType(livenessCheck *libsveltosv1beta1.LivenessCheck) string {
 	return fmt.Sprintf("%s:%s", string(livenessCheck.Type), livenessCheck.Name)
 }
 
+// getConditionSeverity returns the severity for a liveness check condition
+func getConditionSeverity(status corev1.ConditionStatus) libsveltosv1beta1.ConditionSeverity {
+	switch status {
+	case corev1.ConditionFalse:
+		return libsveltosv1beta1.ConditionSeverityWarning
+	case corev1.ConditionUnknown:
+		return libsveltosv1beta1.ConditionSeverityInfo
+	default:
+		return libsveltosv1beta1.ConditionSeverityNone
+	}
+}
+
+// isNotifiableChange returns false if a liveness check status change involves Unknown status and
+// ClusterHealthCheck is configured to not notify on Unknown
+func isNotifiableChange(options *clusterHealthCheckOptions, previous *libsveltosv1beta1.Condition,
+	status corev1.ConditionStatus) bool {
+
+	if options.notifyOnUnknown() {
+		return true
+	}
+
+	if status == corev1.ConditionUnknown {
+		return false
+	}
+
+	return previous == nil || previous.Status != corev1.ConditionUnknown
+}
+
 func getConditionStatus(passing bool) corev1.ConditionStatus {
 	if passing {
 		return corev1.ConditionTrue
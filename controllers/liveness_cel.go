@@ -0,0 +1,160 @@
+/*
+Copyright 2023. projectsveltos.io. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/ext"
+	corev1 "k8s.io/api/core/v1"
+
+	libsveltosv1beta1 "github.com/projectsveltos/libsveltos/api/v1beta1"
+)
+
+const (
+	// celCostLimit bounds the cost of evaluating a CEL expression over HealthCheckReports
+	celCostLimit = 1000000
+)
+
+// Variables available to CEL expressions evaluated over HealthCheckReports:
+// - resources: list of all resource statuses. Each one has fields kind, apiVersion, namespace,
+// name, healthStatus and message;
+// - healthy, progressing, degraded, suspended: number of resources in each HealthStatus.
+// String extension functions (join, format, ...) are available as well.
+const (
+	celVarResources   = "resources"
+	celVarHealthy     = "healthy"
+	celVarProgressing = "progressing"
+	celVarDegraded    = "degraded"
+	celVarSuspended   = "suspended"
+)
+
+func newHealthCheckReportCELEnv() (*cel.Env, error) {
+	return cel.NewEnv(
+		cel.Variable(celVarResources, cel.ListType(cel.MapType(cel.StringType, cel.StringType))),
+		cel.Variable(celVarHealthy, cel.IntType),
+		cel.Variable(celVarProgressing, cel.IntType),
+		cel.Variable(celVarDegraded, cel.IntType),
+		cel.Variable(celVarSuspended, cel.IntType),
+		ext.Strings(),
+	)
+}
+
+// compileCELExpression compiles expression and verifies it evaluates to expected type
+func compileCELExpression(env *cel.Env, expression string, expected *cel.Type) (cel.Program, error) {
+	ast, issues := env.Compile(expression)
+	if issues != nil && issues.Err() != nil {
+		return nil, issues.Err()
+	}
+
+	if !ast.OutputType().IsExactType(expected) {
+		return nil, fmt.Errorf("expression must evaluate to %s, not %s", expected, ast.OutputType())
+	}
+
+	return env.Program(ast, cel.CostLimit(celCostLimit))
+}
+
+// getCELInput returns the variables CEL expressions are evaluated against
+func getCELInput(healthCheckReports []libsveltosv1beta1.HealthCheckReport) map[string]any {
+	resources := make([]map[string]string, 0)
+	counts := map[libsveltosv1beta1.HealthStatus]int64{}
+
+	for i := range healthCheckReports {
+		hcr := &healthCheckReports[i]
+		for j := range hcr.Spec.ResourceStatuses {
+			rs := &hcr.Spec.ResourceStatuses[j]
+			resources = append(resources, map[string]string{
+				"kind":         rs.ObjectRef.Kind,
+				"apiVersion":   rs.ObjectRef.APIVersion,
+				"namespace":    rs.ObjectRef.Namespace,
+				"name":         rs.ObjectRef.Name,
+				"healthStatus": string(rs.HealthStatus),
+				"message":      rs.Message,
+			})
+			counts[rs.HealthStatus]++
+		}
+	}
+
+	return map[string]any{
+		celVarResources:   resources,
+		celVarHealthy:     counts[libsveltosv1beta1.HealthStatusHealthy],
+		celVarProgressing: counts[libsveltosv1beta1.HealthStatusProgressing],
+		celVarDegraded:    counts[libsveltosv1beta1.HealthStatusDegraded],
+		celVarSuspended:   counts[libsveltosv1beta1.HealthStatusSuspended],
+	}
+}
+
+// evaluateCELExpression evaluates expression over HealthCheckReports. When expression evaluates
+// to false and messageExpression is set, messageExpression is used to build the message.
+// An invalid expression is reported as a failing liveness check whose message contains the error,
+// so that it surfaces in the ClusterHealthCheck status. An expression which cannot be evaluated
+// over current reports is reported as unknown.
+// Return values:
+// - ConditionTrue if expression evaluated to true, ConditionFalse if it evaluated to false or is
+// invalid, ConditionUnknown if it could not be evaluated
+// - human consumable message
+func evaluateCELExpression(healthCheckReports []libsveltosv1beta1.HealthCheckReport,
+	expression, messageExpression string) (status corev1.ConditionStatus, message string) {
+
+	env, err := newHealthCheckReportCELEnv()
+	if err != nil {
+		return corev1.ConditionUnknown, fmt.Sprintf("failed to create CEL environment: %v  \n", err)
+	}
+
+	program, err := compileCELExpression(env, expression, cel.BoolType)
+	if err != nil {
+		return corev1.ConditionFalse, fmt.Sprintf("invalid expression %q: %v  \n", expression, err)
+	}
+
+	input := getCELInput(healthCheckReports)
+
+	result, _, err := program.Eval(input)
+	if err != nil {
+		return corev1.ConditionUnknown, fmt.Sprintf("failed to evaluate expression %q: %v  \n", expression, err)
+	}
+
+	if result == types.True {
+		return corev1.ConditionTrue, ""
+	}
+
+	if messageExpression == "" {
+		return corev1.ConditionFalse, fmt.Sprintf("expression %q evaluated to false  \n", expression)
+	}
+
+	msgProgram, err := compileCELExpression(env, messageExpression, cel.StringType)
+	if err != nil {
+		return corev1.ConditionFalse, fmt.Sprintf("invalid messageExpression %q: %v  \n", messageExpression, err)
+	}
+
+	msgResult, _, err := msgProgram.Eval(input)
+	if err != nil {
+		return corev1.ConditionFalse, fmt.Sprintf("failed to evaluate messageExpression %q: %v  \n", messageExpression, err)
+	}
+
+	msg, ok := msgResult.Value().(string)
+	if !ok {
+		return corev1.ConditionFalse, fmt.Sprintf("messageExpression %q did not evaluate to a string  \n", messageExpression)
+	}
+
+	if !strings.HasSuffix(msg, "\n") {
+		msg += "  \n"
+	}
+	return corev1.ConditionFalse, msg
+}
@@ -0,0 +1,88 @@
+/*
+Copyright 2023. projectsveltos.io. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers_test
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/projectsveltos/healthcheck-manager/controllers"
+	libsveltosv1beta1 "github.com/projectsveltos/libsveltos/api/v1beta1"
+)
+
+var _ = Describe("Liveness CEL", func() {
+	var healthCheckReports []libsveltosv1beta1.HealthCheckReport
+
+	BeforeEach(func() {
+		healthCheckReports = []libsveltosv1beta1.HealthCheckReport{
+			{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: randomString(),
+					Name:      randomString(),
+				},
+				Spec: libsveltosv1beta1.HealthCheckReportSpec{
+					ResourceStatuses: []libsveltosv1beta1.ResourceStatus{
+						{
+							ObjectRef:    corev1.ObjectReference{Kind: "Pod", Namespace: "kube-system", Name: randomString()},
+							HealthStatus: libsveltosv1beta1.HealthStatusHealthy,
+						},
+						{
+							ObjectRef:    corev1.ObjectReference{Kind: "Pod", Namespace: "default", Name: "nginx"},
+							HealthStatus: libsveltosv1beta1.HealthStatusDegraded,
+							Message:      "CrashLoopBackOff",
+						},
+					},
+				},
+			},
+		}
+	})
+
+	It("evaluateCELExpression evaluates counts by HealthStatus", func() {
+		status, message := controllers.EvaluateCELExpression(healthCheckReports, "degraded < 3", "")
+		Expect(status).To(Equal(corev1.ConditionTrue))
+		Expect(message).To(BeEmpty())
+
+		status, message = controllers.EvaluateCELExpression(healthCheckReports, "degraded == 0", "")
+		Expect(status).To(Equal(corev1.ConditionFalse))
+		Expect(message).To(ContainSubstring("evaluated to false"))
+	})
+
+	It("evaluateCELExpression evaluates resources and builds message", func() {
+		status, _ := controllers.EvaluateCELExpression(healthCheckReports,
+			`!resources.exists(r, r.namespace == "kube-system" && r.healthStatus == "Degraded")`, "")
+		Expect(status).To(Equal(corev1.ConditionTrue))
+
+		status, message := controllers.EvaluateCELExpression(healthCheckReports,
+			`!resources.exists(r, r.healthStatus == "Degraded")`,
+			`resources.filter(r, r.healthStatus == "Degraded").map(r, r.namespace + "/" + r.name + ": " + r.message).join(", ")`)
+		Expect(status).To(Equal(corev1.ConditionFalse))
+		Expect(message).To(ContainSubstring("default/nginx: CrashLoopBackOff"))
+	})
+
+	It("evaluateCELExpression reports invalid expressions in message", func() {
+		status, message := controllers.EvaluateCELExpression(healthCheckReports, "degraded <", "")
+		Expect(status).To(Equal(corev1.ConditionFalse))
+		Expect(message).To(ContainSubstring("invalid expression"))
+
+		status, message = controllers.EvaluateCELExpression(healthCheckReports, "degraded", "")
+		Expect(status).To(Equal(corev1.ConditionFalse))
+		Expect(message).To(ContainSubstring("must evaluate to bool"))
+	})
+})
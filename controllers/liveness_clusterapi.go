@@ -0,0 +1,365 @@
+/*
+Copyright 2023. projectsveltos.io. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/gdexlab/go-render/render"
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	libsveltosv1beta1 "github.com/projectsveltos/libsveltos/api/v1beta1"
+	logs "github.com/projectsveltos/libsveltos/lib/logsettings"
+)
+
+const (
+	// LivenessTypeClusterAPI refers to the state of ClusterAPI resources backing a ClusterAPI
+	// powered cluster: Machines, MachineHealthChecks, control plane and MachineDeployments.
+	LivenessTypeClusterAPI = libsveltosv1beta1.LivenessType("ClusterAPI")
+)
+
+// evaluateLivenessCheckClusterAPI verifies ClusterAPI resources of a cluster are healthy:
+// - Machines are Running;
+// - no Machine is flagged as unhealthy by a MachineHealthCheck;
+// - control plane and MachineDeployments have the desired number of ready replicas.
+// Thresholds can be relaxed via liveness check options.
+// Return values:
+// - bool indicating whether ClusterAPI resources are healthy
+// - human consumable message naming the resources which are not
+// - an error if any occurs
+func evaluateLivenessCheckClusterAPI(ctx context.Context, c client.Client, clusterNamespace, clusterName string,
+	clusterType libsveltosv1beta1.ClusterType, chc *libsveltosv1beta1.ClusterHealthCheck,
+	livenessCheck *libsveltosv1beta1.LivenessCheck, logger logr.Logger) (passing bool, message string, err error) {
+
+	if clusterType != libsveltosv1beta1.ClusterTypeCapi {
+		logger.V(logs.LogDebug).Info("not a ClusterAPI powered cluster. Nothing to verify")
+		return true, "", nil
+	}
+
+	options, err := getLivenessCheckOptions(chc, livenessCheck)
+	if err != nil {
+		return false, "", err
+	}
+
+	machines, err := fetchMachines(ctx, c, clusterNamespace, clusterName)
+	if err != nil {
+		logger.V(logs.LogInfo).Info(fmt.Sprintf("failed to fetch machines: %v", err))
+		return false, "", err
+	}
+
+	passing = true
+
+	notRunning := getNotRunningMachines(machines)
+	if len(notRunning) > getIntOrDefault(options.MaxNotRunningMachines, 0) {
+		passing = false
+		message += fmt.Sprintf("Machines not running: %s  \n", strings.Join(notRunning, ", "))
+	}
+
+	unhealthy := getUnhealthyMachines(machines)
+	if len(unhealthy) > getIntOrDefault(options.MaxUnhealthyMachines, 0) {
+		passing = false
+		message += fmt.Sprintf("Machines flagged by MachineHealthCheck: %s  \n", strings.Join(unhealthy, ", "))
+	}
+
+	maxMissingReplicas := getIntOrDefault(options.MaxMissingReplicas, 0)
+
+	msg, err := evaluateControlPlaneReplicas(ctx, c, clusterNamespace, clusterName, maxMissingReplicas, logger)
+	if err != nil {
+		logger.V(logs.LogInfo).Info(fmt.Sprintf("failed to verify control plane: %v", err))
+		return false, "", err
+	}
+	if msg != "" {
+		passing = false
+		message += msg
+	}
+
+	msg, err = evaluateMachineDeploymentReplicas(ctx, c, clusterNamespace, clusterName, maxMissingReplicas)
+	if err != nil {
+		logger.V(logs.LogInfo).Info(fmt.Sprintf("failed to verify machineDeployments: %v", err))
+		return false, "", err
+	}
+	if msg != "" {
+		passing = false
+		message += msg
+	}
+
+	return passing, message, nil
+}
+
+// fetchMachines returns all Machines of a ClusterAPI powered cluster
+func fetchMachines(ctx context.Context, c client.Client, clusterNamespace, clusterName string,
+) (*clusterv1.MachineList, error) {
+
+	listOptions := []client.ListOption{
+		client.InNamespace(clusterNamespace),
+		client.MatchingLabels{clusterv1.ClusterNameLabel: clusterName},
+	}
+
+	machineList := &clusterv1.MachineList{}
+	err := c.List(ctx, machineList, listOptions...)
+	return machineList, err
+}
+
+// getNotRunningMachines returns name and phase of all Machines which are not Running
+func getNotRunningMachines(machines *clusterv1.MachineList) []string {
+	notRunning := make([]string, 0)
+	for i := range machines.Items {
+		m := &machines.Items[i]
+		if !m.DeletionTimestamp.IsZero() {
+			continue
+		}
+		if m.Status.GetTypedPhase() != clusterv1.MachinePhaseRunning {
+			notRunning = append(notRunning, fmt.Sprintf("%s (%s)", m.Name, m.Status.GetTypedPhase()))
+		}
+	}
+
+	return notRunning
+}
+
+// getUnhealthyMachines returns name of all Machines a MachineHealthCheck flagged as unhealthy
+// or marked for remediation
+func getUnhealthyMachines(machines *clusterv1.MachineList) []string {
+	unhealthy := make([]string, 0)
+	for i := range machines.Items {
+		m := &machines.Items[i]
+		if !m.DeletionTimestamp.IsZero() {
+			continue
+		}
+		for j := range m.Status.Conditions {
+			condition := &m.Status.Conditions[j]
+			if (condition.Type == clusterv1.MachineHealthCheckSucceededCondition ||
+				condition.Type == clusterv1.MachineOwnerRemediatedCondition) &&
+				condition.Status == corev1.ConditionFalse {
+
+				unhealthy = append(unhealthy, m.Name)
+				break
+			}
+		}
+	}
+
+	return unhealthy
+}
+
+// evaluateControlPlaneReplicas returns a message if the control plane referenced by the Cluster has
+// more than maxMissingReplicas ready replicas less than desired. Empty otherwise (also when control plane
+// cannot be read because of missing RBAC).
+func evaluateControlPlaneReplicas(ctx context.Context, c client.Client, clusterNamespace, clusterName string,
+	maxMissingReplicas int, logger logr.Logger) (string, error) {
+
+	cluster := &clusterv1.Cluster{}
+	err := c.Get(ctx, types.NamespacedName{Namespace: clusterNamespace, Name: clusterName}, cluster)
+	if err != nil {
+		return "", err
+	}
+
+	controlPlane, err := getControlPlane(ctx, c, cluster)
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return fmt.Sprintf("control plane %s %s/%s not found  \n", cluster.Spec.ControlPlaneRef.Kind,
+				cluster.Spec.ControlPlaneRef.Namespace, cluster.Spec.ControlPlaneRef.Name), nil
+		}
+		if apierrors.IsForbidden(err) {
+			logger.V(logs.LogInfo).Info(fmt.Sprintf("not allowed to read control plane %s. Skipping replicas check: %v",
+				cluster.Spec.ControlPlaneRef.Kind, err))
+			return "", nil
+		}
+		return "", err
+	}
+	if controlPlane == nil {
+		return "", nil
+	}
+
+	desired, ready, found, err := getControlPlaneReplicas(controlPlane)
+	if err != nil {
+		return "", err
+	}
+	if !found {
+		// Not all control plane providers expose replicas
+		return "", nil
+	}
+
+	if desired-ready > int64(maxMissingReplicas) {
+		return fmt.Sprintf("control plane %s %s/%s has %d/%d ready replicas  \n", controlPlane.GetKind(),
+			controlPlane.GetNamespace(), controlPlane.GetName(), ready, desired), nil
+	}
+
+	return "", nil
+}
+
+// getControlPlane returns the control plane referenced by the Cluster. Control plane is read as unstructured
+// as it can be of any provider. Returns nil if the Cluster does not reference any control plane.
+// RBAC only allows reading control planes of well known providers (see the kubebuilder markers in
+// clusterhealthcheck_controller.go). Other providers require the ClusterRole to be extended: till then
+// reading the control plane is forbidden and callers skip the control plane checks.
+func getControlPlane(ctx context.Context, c client.Client, cluster *clusterv1.Cluster,
+) (*unstructured.Unstructured, error) {
+
+	if cluster.Spec.ControlPlaneRef == nil {
+		return nil, nil
+	}
+
+	controlPlane := &unstructured.Unstructured{}
+	controlPlane.SetAPIVersion(cluster.Spec.ControlPlaneRef.APIVersion)
+	controlPlane.SetKind(cluster.Spec.ControlPlaneRef.Kind)
+	err := c.Get(ctx, types.NamespacedName{Namespace: cluster.Spec.ControlPlaneRef.Namespace,
+		Name: cluster.Spec.ControlPlaneRef.Name}, controlPlane)
+	if err != nil {
+		return nil, err
+	}
+
+	return controlPlane, nil
+}
+
+// getControlPlaneReplicas returns control plane desired (spec.replicas) and ready (status.readyReplicas)
+// replicas. found is false if control plane does not expose replicas.
+func getControlPlaneReplicas(controlPlane *unstructured.Unstructured) (desired, ready int64, found bool, err error) {
+	desired, found, err = unstructured.NestedInt64(controlPlane.Object, "spec", "replicas")
+	if err != nil || !found {
+		return 0, 0, false, err
+	}
+
+	ready, _, err = unstructured.NestedInt64(controlPlane.Object, "status", "readyReplicas")
+	if err != nil {
+		return 0, 0, false, err
+	}
+
+	return desired, ready, true, nil
+}
+
+// evaluateMachineDeploymentReplicas returns a message naming all MachineDeployments of the cluster with
+// more than maxMissingReplicas ready replicas less than desired. Empty otherwise.
+func evaluateMachineDeploymentReplicas(ctx context.Context, c client.Client, clusterNamespace, clusterName string,
+	maxMissingReplicas int) (string, error) {
+
+	listOptions := []client.ListOption{
+		client.InNamespace(clusterNamespace),
+		client.MatchingLabels{clusterv1.ClusterNameLabel: clusterName},
+	}
+
+	machineDeploymentList := &clusterv1.MachineDeploymentList{}
+	err := c.List(ctx, machineDeploymentList, listOptions...)
+	if err != nil {
+		return "", err
+	}
+
+	var message string
+	for i := range machineDeploymentList.Items {
+		md := &machineDeploymentList.Items[i]
+		if !md.DeletionTimestamp.IsZero() || md.Spec.Replicas == nil {
+			continue
+		}
+		if *md.Spec.Replicas-md.Status.ReadyReplicas > int32(maxMissingReplicas) {
+			message += fmt.Sprintf("MachineDeployment %s/%s has %d/%d ready replicas  \n",
+				md.Namespace, md.Name, md.Status.ReadyReplicas, *md.Spec.Replicas)
+		}
+	}
+
+	return message, nil
+}
+
+// getClusterAPIState returns a representation of the ClusterAPI resources a ClusterAPI liveness check
+// evaluates. Used to detect when the liveness check needs to be re-evaluated.
+func getClusterAPIState(ctx context.Context, c client.Client, clusterNamespace, clusterName string,
+) (string, error) {
+
+	machines, err := fetchMachines(ctx, c, clusterNamespace, clusterName)
+	if err != nil {
+		return "", err
+	}
+
+	state := render.AsCode(getNotRunningMachines(machines))
+	state += render.AsCode(getUnhealthyMachines(machines))
+
+	listOptions := []client.ListOption{
+		client.InNamespace(clusterNamespace),
+		client.MatchingLabels{clusterv1.ClusterNameLabel: clusterName},
+	}
+
+	machineDeploymentList := &clusterv1.MachineDeploymentList{}
+	err = c.List(ctx, machineDeploymentList, listOptions...)
+	if err != nil {
+		return "", err
+	}
+
+	for i := range machineDeploymentList.Items {
+		md := &machineDeploymentList.Items[i]
+		state += render.AsCode(md.Spec.Replicas)
+		state += render.AsCode(md.Status.ReadyReplicas)
+	}
+
+	controlPlaneState, err := getControlPlaneState(ctx, c, clusterNamespace, clusterName)
+	if err != nil {
+		return "", err
+	}
+	state += controlPlaneState
+
+	return state, nil
+}
+
+// getControlPlaneState returns a representation of the control plane replicas
+func getControlPlaneState(ctx context.Context, c client.Client, clusterNamespace, clusterName string,
+) (string, error) {
+
+	cluster := &clusterv1.Cluster{}
+	err := c.Get(ctx, types.NamespacedName{Namespace: clusterNamespace, Name: clusterName}, cluster)
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return "", nil
+		}
+		return "", err
+	}
+
+	controlPlane, err := getControlPlane(ctx, c, cluster)
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return "controlplane not found", nil
+		}
+		if apierrors.IsForbidden(err) {
+			// Replicas check is skipped as well
+			ctrl.LoggerFrom(ctx).V(logs.LogDebug).Info(fmt.Sprintf("not allowed to read control plane %s: %v",
+				cluster.Spec.ControlPlaneRef.Kind, err))
+			return "", nil
+		}
+		return "", err
+	}
+	if controlPlane == nil {
+		return "", nil
+	}
+
+	desired, ready, found, err := getControlPlaneReplicas(controlPlane)
+	if err != nil || !found {
+		return "", err
+	}
+
+	return render.AsCode(desired) + render.AsCode(ready), nil
+}
+
+func getIntOrDefault(value *int, defaultValue int) int {
+	if value == nil {
+		return defaultValue
+	}
+	return *value
+}
@@ -0,0 +1,247 @@
+/*
+Copyright 2023. projectsveltos.io. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers_test
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/go-logr/logr"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2/textlogger"
+	"k8s.io/utils/ptr"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+
+	"github.com/projectsveltos/healthcheck-manager/controllers"
+	libsveltosv1beta1 "github.com/projectsveltos/libsveltos/api/v1beta1"
+)
+
+var _ = Describe("Liveness ClusterAPI", func() {
+	var cluster *clusterv1.Cluster
+	var livenessCheck *libsveltosv1beta1.LivenessCheck
+	var logger logr.Logger
+
+	BeforeEach(func() {
+		logger = textlogger.NewLogger(textlogger.NewConfig(textlogger.Verbosity(1)))
+
+		cluster = &clusterv1.Cluster{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: randomString(),
+				Name:      randomString(),
+			},
+		}
+
+		livenessCheck = &libsveltosv1beta1.LivenessCheck{
+			Name: randomString(),
+			Type: controllers.LivenessTypeClusterAPI,
+		}
+	})
+
+	It("evaluateLivenessCheckClusterAPI passes for non ClusterAPI powered clusters", func() {
+		chc := &libsveltosv1beta1.ClusterHealthCheck{
+			ObjectMeta: metav1.ObjectMeta{Name: randomString()},
+		}
+
+		c := fake.NewClientBuilder().WithScheme(scheme).Build()
+
+		passing, message, err := controllers.EvaluateLivenessCheckClusterAPI(context.TODO(), c,
+			cluster.Namespace, cluster.Name, libsveltosv1beta1.ClusterTypeSveltos, chc, livenessCheck, logger)
+		Expect(err).To(BeNil())
+		Expect(passing).To(BeTrue())
+		Expect(message).To(BeEmpty())
+	})
+
+	It("evaluateLivenessCheckClusterAPI fails when machines are not running or unhealthy", func() {
+		chc := &libsveltosv1beta1.ClusterHealthCheck{
+			ObjectMeta: metav1.ObjectMeta{Name: randomString()},
+		}
+
+		running := getMachine(cluster, clusterv1.MachinePhaseRunning)
+		provisioning := getMachine(cluster, clusterv1.MachinePhaseProvisioning)
+		unhealthy := getMachine(cluster, clusterv1.MachinePhaseRunning)
+		unhealthy.Status.Conditions = clusterv1.Conditions{
+			{
+				Type:   clusterv1.MachineHealthCheckSucceededCondition,
+				Status: corev1.ConditionFalse,
+			},
+		}
+
+		initObjects := []client.Object{cluster, running, provisioning, unhealthy}
+		c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(initObjects...).Build()
+
+		passing, message, err := controllers.EvaluateLivenessCheckClusterAPI(context.TODO(), c,
+			cluster.Namespace, cluster.Name, libsveltosv1beta1.ClusterTypeCapi, chc, livenessCheck, logger)
+		Expect(err).To(BeNil())
+		Expect(passing).To(BeFalse())
+		Expect(message).To(ContainSubstring(provisioning.Name))
+		Expect(message).To(ContainSubstring(unhealthy.Name))
+		Expect(message).ToNot(ContainSubstring(running.Name))
+
+		// Relax thresholds
+		chc.Annotations = map[string]string{
+			controllers.ClusterHealthCheckOptionsAnnotation: "livenessChecks:\n  " + livenessCheck.Name +
+				":\n    maxNotRunningMachines: 1\n    maxUnhealthyMachines: 1\n",
+		}
+
+		passing, message, err = controllers.EvaluateLivenessCheckClusterAPI(context.TODO(), c,
+			cluster.Namespace, cluster.Name, libsveltosv1beta1.ClusterTypeCapi, chc, livenessCheck, logger)
+		Expect(err).To(BeNil())
+		Expect(passing).To(BeTrue())
+		Expect(message).To(BeEmpty())
+	})
+
+	It("evaluateLivenessCheckClusterAPI fails when MachineDeployment is missing ready replicas", func() {
+		chc := &libsveltosv1beta1.ClusterHealthCheck{
+			ObjectMeta: metav1.ObjectMeta{Name: randomString()},
+		}
+
+		md := &clusterv1.MachineDeployment{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: cluster.Namespace,
+				Name:      randomString(),
+				Labels:    map[string]string{clusterv1.ClusterNameLabel: cluster.Name},
+			},
+			Spec: clusterv1.MachineDeploymentSpec{
+				ClusterName: cluster.Name,
+				Replicas:    ptr.To(int32(3)),
+			},
+			Status: clusterv1.MachineDeploymentStatus{
+				ReadyReplicas: 2,
+			},
+		}
+
+		initObjects := []client.Object{cluster, md}
+		c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(initObjects...).Build()
+
+		passing, message, err := controllers.EvaluateLivenessCheckClusterAPI(context.TODO(), c,
+			cluster.Namespace, cluster.Name, libsveltosv1beta1.ClusterTypeCapi, chc, livenessCheck, logger)
+		Expect(err).To(BeNil())
+		Expect(passing).To(BeFalse())
+		Expect(message).To(ContainSubstring("2/3 ready replicas"))
+
+		chc.Annotations = map[string]string{
+			controllers.ClusterHealthCheckOptionsAnnotation: "livenessChecks:\n  " + livenessCheck.Name +
+				":\n    maxMissingReplicas: 1\n",
+		}
+
+		passing, _, err = controllers.EvaluateLivenessCheckClusterAPI(context.TODO(), c,
+			cluster.Namespace, cluster.Name, libsveltosv1beta1.ClusterTypeCapi, chc, livenessCheck, logger)
+		Expect(err).To(BeNil())
+		Expect(passing).To(BeTrue())
+	})
+
+	It("getClusterAPIState changes when control plane ready replicas change", func() {
+		controlPlane := &unstructured.Unstructured{}
+		controlPlane.SetAPIVersion("controlplane.cluster.x-k8s.io/v1beta1")
+		controlPlane.SetKind("KubeadmControlPlane")
+		controlPlane.SetNamespace(cluster.Namespace)
+		controlPlane.SetName(randomString())
+		Expect(unstructured.SetNestedField(controlPlane.Object, int64(3), "spec", "replicas")).To(Succeed())
+		Expect(unstructured.SetNestedField(controlPlane.Object, int64(3), "status", "readyReplicas")).To(Succeed())
+
+		cluster.Spec.ControlPlaneRef = &corev1.ObjectReference{
+			APIVersion: controlPlane.GetAPIVersion(),
+			Kind:       controlPlane.GetKind(),
+			Namespace:  controlPlane.GetNamespace(),
+			Name:       controlPlane.GetName(),
+		}
+
+		initObjects := []client.Object{cluster, controlPlane}
+		c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(initObjects...).Build()
+
+		state, err := controllers.GetClusterAPIState(context.TODO(), c, cluster.Namespace, cluster.Name)
+		Expect(err).To(BeNil())
+
+		Expect(c.Get(context.TODO(), types.NamespacedName{Namespace: controlPlane.GetNamespace(),
+			Name: controlPlane.GetName()}, controlPlane)).To(Succeed())
+		Expect(unstructured.SetNestedField(controlPlane.Object, int64(1), "status", "readyReplicas")).To(Succeed())
+		Expect(c.Update(context.TODO(), controlPlane)).To(Succeed())
+
+		newState, err := controllers.GetClusterAPIState(context.TODO(), c, cluster.Namespace, cluster.Name)
+		Expect(err).To(BeNil())
+		Expect(newState).ToNot(Equal(state))
+
+		chc := &libsveltosv1beta1.ClusterHealthCheck{
+			ObjectMeta: metav1.ObjectMeta{Name: randomString()},
+		}
+		passing, message, err := controllers.EvaluateLivenessCheckClusterAPI(context.TODO(), c,
+			cluster.Namespace, cluster.Name, libsveltosv1beta1.ClusterTypeCapi, chc, livenessCheck, logger)
+		Expect(err).To(BeNil())
+		Expect(passing).To(BeFalse())
+		Expect(message).To(ContainSubstring("1/3 ready replicas"))
+	})
+
+	It("control plane replicas are not verified when reading control plane is forbidden", func() {
+		cluster.Spec.ControlPlaneRef = &corev1.ObjectReference{
+			APIVersion: "controlplane.cluster.x-k8s.io/v1beta1",
+			Kind:       "UnknownControlPlane",
+			Namespace:  cluster.Namespace,
+			Name:       randomString(),
+		}
+
+		c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(cluster).WithInterceptorFuncs(
+			interceptor.Funcs{
+				Get: func(ctx context.Context, c client.WithWatch, key client.ObjectKey, obj client.Object,
+					opts ...client.GetOption) error {
+
+					if _, ok := obj.(*unstructured.Unstructured); ok {
+						return apierrors.NewForbidden(schema.GroupResource{Group: "controlplane.cluster.x-k8s.io",
+							Resource: "unknowncontrolplanes"}, key.Name, fmt.Errorf("no RBAC"))
+					}
+					return c.Get(ctx, key, obj, opts...)
+				},
+			}).Build()
+
+		chc := &libsveltosv1beta1.ClusterHealthCheck{
+			ObjectMeta: metav1.ObjectMeta{Name: randomString()},
+		}
+		passing, message, err := controllers.EvaluateLivenessCheckClusterAPI(context.TODO(), c,
+			cluster.Namespace, cluster.Name, libsveltosv1beta1.ClusterTypeCapi, chc, livenessCheck, logger)
+		Expect(err).To(BeNil())
+		Expect(passing).To(BeTrue())
+		Expect(message).To(BeEmpty())
+
+		_, err = controllers.GetClusterAPIState(context.TODO(), c, cluster.Namespace, cluster.Name)
+		Expect(err).To(BeNil())
+	})
+})
+
+func getMachine(cluster *clusterv1.Cluster, phase clusterv1.MachinePhase) *clusterv1.Machine {
+	machine := &clusterv1.Machine{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: cluster.Namespace,
+			Name:      randomString(),
+			Labels:    map[string]string{clusterv1.ClusterNameLabel: cluster.Name},
+		},
+		Spec: clusterv1.MachineSpec{
+			ClusterName: cluster.Name,
+		},
+	}
+	machine.Status.SetTypedPhase(phase)
+	return machine
+}
@@ -0,0 +1,275 @@
+/*
+Copyright 2023. projectsveltos.io. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	libsveltosv1beta1 "github.com/projectsveltos/libsveltos/api/v1beta1"
+)
+
+const (
+	// compositeCheckType is used as type for conditions reported for composite checks
+	compositeCheckType = libsveltosv1beta1.LivenessType("Composite")
+)
+
+// getCompositeLivenessCheck returns the LivenessCheck used to track a composite check status
+func getCompositeLivenessCheck(compositeCheck *compositeCheckOptions) *libsveltosv1beta1.LivenessCheck {
+	return &libsveltosv1beta1.LivenessCheck{
+		Name: compositeCheck.Name,
+		Type: compositeCheckType,
+	}
+}
+
+// isCompositeCondition returns true if condition reports a composite check status
+func isCompositeCondition(condition *libsveltosv1beta1.Condition) bool {
+	return strings.HasPrefix(string(condition.Type), string(compositeCheckType)+":")
+}
+
+// evaluateCompositeChecks evaluates all composite checks over the liveness check conditions.
+// Expressions follow three-valued logic: a liveness check which is Unknown makes the composite
+// check Unknown unless the result does not depend on it.
+// An invalid composite check is reported as failing with the error as message.
+func evaluateCompositeChecks(compositeChecks []compositeCheckOptions, conditions []libsveltosv1beta1.Condition,
+) []libsveltosv1beta1.Condition {
+
+	// For each liveness check, whether it is failing (ConditionTrue), passing (ConditionFalse)
+	// or Unknown
+	failing := make(map[string]corev1.ConditionStatus, len(conditions))
+	for i := range conditions {
+		switch conditions[i].Status {
+		case corev1.ConditionTrue:
+			failing[conditions[i].Name] = corev1.ConditionFalse
+		case corev1.ConditionFalse:
+			failing[conditions[i].Name] = corev1.ConditionTrue
+		default:
+			failing[conditions[i].Name] = corev1.ConditionUnknown
+		}
+	}
+
+	result := make([]libsveltosv1beta1.Condition, len(compositeChecks))
+	for i := range compositeChecks {
+		compositeCheck := &compositeChecks[i]
+
+		result[i] = libsveltosv1beta1.Condition{
+			Type:               libsveltosv1beta1.ConditionType(getConditionType(getCompositeLivenessCheck(compositeCheck))),
+			Name:               compositeCheck.Name,
+			Status:             corev1.ConditionTrue,
+			LastTransitionTime: metav1.Time{Time: time.Now()},
+		}
+
+		value, err := evaluateCompositeExpression(&compositeCheck.compositeExpression, failing)
+		switch {
+		case err != nil:
+			result[i].Status = corev1.ConditionFalse
+			result[i].Message = fmt.Sprintf("invalid composite check: %v  \n", err)
+		case value == corev1.ConditionTrue:
+			result[i].Status = corev1.ConditionFalse
+			result[i].Message = getCompositeMessage(&compositeCheck.compositeExpression, conditions)
+		case value == corev1.ConditionUnknown:
+			result[i].Status = corev1.ConditionUnknown
+			result[i].Message = getCompositeMessage(&compositeCheck.compositeExpression, conditions)
+		}
+		result[i].Severity = getConditionSeverity(result[i].Status)
+	}
+
+	return result
+}
+
+// evaluateCompositeExpression returns the value of expression (ConditionTrue, ConditionFalse or
+// ConditionUnknown). failing contains, for each liveness check, whether it is failing.
+func evaluateCompositeExpression(expression *compositeExpression, failing map[string]corev1.ConditionStatus,
+) (corev1.ConditionStatus, error) {
+
+	switch {
+	case expression.LivenessCheck != "":
+		value, ok := failing[expression.LivenessCheck]
+		if !ok {
+			return corev1.ConditionFalse, fmt.Errorf("liveness check %s not found", expression.LivenessCheck)
+		}
+		return value, nil
+	case len(expression.And) > 0:
+		trueCount, unknownCount, err := countCompositeExpressions(expression.And, failing)
+		if err != nil {
+			return corev1.ConditionFalse, err
+		}
+		if trueCount == len(expression.And) {
+			return corev1.ConditionTrue, nil
+		}
+		if trueCount+unknownCount == len(expression.And) {
+			return corev1.ConditionUnknown, nil
+		}
+		return corev1.ConditionFalse, nil
+	case len(expression.Or) > 0:
+		return evaluateAtLeast(1, expression.Or, failing)
+	case expression.Not != nil:
+		value, err := evaluateCompositeExpression(expression.Not, failing)
+		if err != nil {
+			return corev1.ConditionFalse, err
+		}
+		switch value {
+		case corev1.ConditionTrue:
+			return corev1.ConditionFalse, nil
+		case corev1.ConditionFalse:
+			return corev1.ConditionTrue, nil
+		default:
+			return corev1.ConditionUnknown, nil
+		}
+	case expression.AtLeast != nil:
+		if len(expression.Of) == 0 {
+			return corev1.ConditionFalse, fmt.Errorf("atLeast requires of")
+		}
+		return evaluateAtLeast(*expression.AtLeast, expression.Of, failing)
+	}
+
+	return corev1.ConditionFalse, fmt.Errorf("empty expression")
+}
+
+// evaluateAtLeast returns whether at least k of the expressions are true
+func evaluateAtLeast(k int, expressions []compositeExpression, failing map[string]corev1.ConditionStatus,
+) (corev1.ConditionStatus, error) {
+
+	trueCount, unknownCount, err := countCompositeExpressions(expressions, failing)
+	if err != nil {
+		return corev1.ConditionFalse, err
+	}
+	if trueCount >= k {
+		return corev1.ConditionTrue, nil
+	}
+	if trueCount+unknownCount >= k {
+		return corev1.ConditionUnknown, nil
+	}
+	return corev1.ConditionFalse, nil
+}
+
+// countCompositeExpressions returns how many expressions are true and how many are unknown
+func countCompositeExpressions(expressions []compositeExpression, failing map[string]corev1.ConditionStatus,
+) (trueCount, unknownCount int, err error) {
+
+	for i := range expressions {
+		value, err := evaluateCompositeExpression(&expressions[i], failing)
+		if err != nil {
+			return 0, 0, err
+		}
+		switch value {
+		case corev1.ConditionTrue:
+			trueCount++
+		case corev1.ConditionUnknown:
+			unknownCount++
+		}
+	}
+
+	return trueCount, unknownCount, nil
+}
+
+// getCompositeMessage returns the messages of all failing and unknown liveness checks referenced by
+// expression. Unknown liveness checks are not reported as failing, so notifications do not list them
+// among the failing ones.
+func getCompositeMessage(expression *compositeExpression, conditions []libsveltosv1beta1.Condition) string {
+	names := make(map[string]bool)
+	collectCompositeLivenessChecks(expression, names)
+
+	var message string
+	for i := range conditions {
+		c := &conditions[i]
+		if !names[c.Name] {
+			continue
+		}
+		switch c.Status {
+		case corev1.ConditionFalse:
+			message += fmt.Sprintf("Liveness check %q failing  \n", c.Type)
+			message += c.Message
+		case corev1.ConditionUnknown:
+			message += fmt.Sprintf("Liveness check %q unknown  \n", c.Type)
+			message += c.Message
+		}
+	}
+
+	return message
+}
+
+// collectCompositeLivenessChecks adds to names all liveness checks referenced by expression
+func collectCompositeLivenessChecks(expression *compositeExpression, names map[string]bool) {
+	if expression.LivenessCheck != "" {
+		names[expression.LivenessCheck] = true
+	}
+	for i := range expression.And {
+		collectCompositeLivenessChecks(&expression.And[i], names)
+	}
+	for i := range expression.Or {
+		collectCompositeLivenessChecks(&expression.Or[i], names)
+	}
+	if expression.Not != nil {
+		collectCompositeLivenessChecks(expression.Not, names)
+	}
+	for i := range expression.Of {
+		collectCompositeLivenessChecks(&expression.Of[i], names)
+	}
+}
+
+// getNotificationConditions returns the conditions a notification reports on
+func getNotificationConditions(options *clusterHealthCheckOptions, n *libsveltosv1beta1.Notification,
+	conditions []libsveltosv1beta1.Condition) []libsveltosv1beta1.Condition {
+
+	compositesOnly := options.Notifications[n.Name].CompositesOnly
+	notifyOnUnknown := options.notifyOnUnknown()
+	if !compositesOnly && notifyOnUnknown {
+		return conditions
+	}
+
+	result := make([]libsveltosv1beta1.Condition, 0)
+	for i := range conditions {
+		if compositesOnly && !isCompositeCondition(&conditions[i]) {
+			continue
+		}
+		if !notifyOnUnknown && conditions[i].Status == corev1.ConditionUnknown {
+			continue
+		}
+		result = append(result, conditions[i])
+	}
+
+	return result
+}
+
+// haveCompositeChecksChanged returns true if any of the composite check conditions changed since
+// last evaluation (ignoring changes involving Unknown status if those are not notified)
+func haveCompositeChecksChanged(chc *libsveltosv1beta1.ClusterHealthCheck, options *clusterHealthCheckOptions,
+	clusterNamespace, clusterName string, clusterType libsveltosv1beta1.ClusterType,
+	conditions []libsveltosv1beta1.Condition) bool {
+
+	for i := range conditions {
+		c := &conditions[i]
+		if !isCompositeCondition(c) {
+			continue
+		}
+		livenessCheck := &libsveltosv1beta1.LivenessCheck{Name: c.Name, Type: compositeCheckType}
+		if hasLivenessCheckStatusChange(chc, clusterNamespace, clusterName, clusterType, livenessCheck,
+			c.Status, c.Message) &&
+			isNotifiableChange(options, getPreviousLivenessCheckStatus(chc, clusterNamespace, clusterName,
+				clusterType, livenessCheck), c.Status) {
+
+			return true
+		}
+	}
+
+	return false
+}
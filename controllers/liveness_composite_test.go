@@ -0,0 +1,160 @@
+/*
+Copyright 2023. projectsveltos.io. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers_test
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/projectsveltos/healthcheck-manager/controllers"
+	libsveltosv1beta1 "github.com/projectsveltos/libsveltos/api/v1beta1"
+)
+
+var _ = Describe("Liveness composite checks", func() {
+	var conditions []libsveltosv1beta1.Condition
+
+	BeforeEach(func() {
+		conditions = []libsveltosv1beta1.Condition{
+			{
+				Type: "Addons:addons", Name: "addons", Status: corev1.ConditionFalse,
+				Message: "helm chart failed",
+			},
+			{Type: "HealthCheck:nodes", Name: "nodes", Status: corev1.ConditionTrue},
+			{Type: "HealthCheck:ingress", Name: "ingress", Status: corev1.ConditionFalse},
+		}
+	})
+
+	getClusterHealthCheckWithOptions := func(value string) *libsveltosv1beta1.ClusterHealthCheck {
+		return &libsveltosv1beta1.ClusterHealthCheck{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: randomString(),
+				Annotations: map[string]string{
+					controllers.ClusterHealthCheckOptionsAnnotation: value,
+				},
+			},
+		}
+	}
+
+	It("evaluateCompositeChecks evaluates AND, OR, NOT and k of n", func() {
+		chc := getClusterHealthCheckWithOptions(`compositeChecks:
+- name: outage
+  and:
+  - livenessCheck: addons
+  - livenessCheck: nodes
+- name: any
+  or:
+  - livenessCheck: addons
+  - livenessCheck: nodes
+- name: not-nodes
+  not:
+    livenessCheck: nodes
+- name: two-of-three
+  atLeast: 2
+  of:
+  - livenessCheck: addons
+  - livenessCheck: nodes
+  - livenessCheck: ingress
+- name: invalid
+  livenessCheck: missing
+`)
+
+		options, err := controllers.GetClusterHealthCheckOptions(chc)
+		Expect(err).To(BeNil())
+
+		result := controllers.EvaluateCompositeChecks(options.CompositeChecks, conditions)
+		Expect(len(result)).To(Equal(5))
+
+		Expect(result[0].Name).To(Equal("outage"))
+		Expect(result[0].Type).To(Equal(libsveltosv1beta1.ConditionType("Composite:outage")))
+		Expect(result[0].Status).To(Equal(corev1.ConditionTrue))
+
+		Expect(result[1].Status).To(Equal(corev1.ConditionFalse))
+		Expect(result[1].Message).To(ContainSubstring("helm chart failed"))
+
+		Expect(result[2].Status).To(Equal(corev1.ConditionFalse))
+
+		Expect(result[3].Status).To(Equal(corev1.ConditionFalse))
+
+		Expect(result[4].Status).To(Equal(corev1.ConditionFalse))
+		Expect(result[4].Message).To(ContainSubstring("invalid composite check"))
+	})
+
+	It("evaluateCompositeChecks reports Unknown when result depends on Unknown liveness checks", func() {
+		conditions[1].Status = corev1.ConditionUnknown
+
+		chc := getClusterHealthCheckWithOptions(`compositeChecks:
+- name: outage
+  and:
+  - livenessCheck: addons
+  - livenessCheck: nodes
+- name: any
+  or:
+  - livenessCheck: addons
+  - livenessCheck: nodes
+- name: not-nodes
+  not:
+    livenessCheck: nodes
+`)
+
+		options, err := controllers.GetClusterHealthCheckOptions(chc)
+		Expect(err).To(BeNil())
+
+		result := controllers.EvaluateCompositeChecks(options.CompositeChecks, conditions)
+		Expect(len(result)).To(Equal(3))
+		Expect(result[0].Status).To(Equal(corev1.ConditionUnknown))
+		// addons is failing, so or is failing regardless of nodes
+		Expect(result[1].Status).To(Equal(corev1.ConditionFalse))
+		Expect(result[2].Status).To(Equal(corev1.ConditionUnknown))
+
+		// Unknown liveness checks are not reported as failing
+		Expect(result[1].Message).To(ContainSubstring(`Liveness check "Addons:addons" failing`))
+		Expect(result[1].Message).To(ContainSubstring(`Liveness check "HealthCheck:nodes" unknown`))
+		Expect(result[1].Message).ToNot(ContainSubstring(`Liveness check "HealthCheck:nodes" failing`))
+		Expect(result[2].Message).ToNot(ContainSubstring("failing"))
+	})
+
+	It("getNotificationConditions filters conditions for notifications limited to composites", func() {
+		chc := getClusterHealthCheckWithOptions(`compositeChecks:
+- name: outage
+  and:
+  - livenessCheck: addons
+  - livenessCheck: ingress
+notifications:
+  slack:
+    compositesOnly: true
+`)
+
+		options, err := controllers.GetClusterHealthCheckOptions(chc)
+		Expect(err).To(BeNil())
+
+		allConditions := append(conditions,
+			controllers.EvaluateCompositeChecks(options.CompositeChecks, conditions)...)
+
+		result := controllers.GetNotificationConditions(options,
+			&libsveltosv1beta1.Notification{Name: "slack"}, allConditions)
+		Expect(len(result)).To(Equal(1))
+		Expect(result[0].Name).To(Equal("outage"))
+		Expect(result[0].Status).To(Equal(corev1.ConditionFalse))
+
+		result = controllers.GetNotificationConditions(options,
+			&libsveltosv1beta1.Notification{Name: "event"}, allConditions)
+		Expect(len(result)).To(Equal(len(allConditions)))
+	})
+})
@@ -0,0 +1,111 @@
+/*
+Copyright 2023. projectsveltos.io. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	libsveltosv1beta1 "github.com/projectsveltos/libsveltos/api/v1beta1"
+)
+
+const (
+	// FlappingReason is the Condition reason of a liveness check which is flapping
+	FlappingReason = "Flapping"
+
+	// FlappingStoppedReason is the Condition reason of a liveness check which stopped flapping
+	// since last evaluation
+	FlappingStoppedReason = "FlappingStopped"
+
+	defaultFlapWindow         = time.Hour
+	defaultFlapStartThreshold = 5
+)
+
+// detectFlapping records, in the liveness check state, a status change (if any) and returns whether
+// the liveness check started or stopped flapping.
+// A liveness check starts flapping when the number of status changes within the window reaches
+// StartThreshold. It stops flapping when that number drops to StopThreshold or below.
+func detectFlapping(options *flapDetectionOptions, previous *libsveltosv1beta1.Condition,
+	state *livenessCheckState, status corev1.ConditionStatus) bool {
+
+	now := time.Now()
+	if previous != nil && previous.Status != status {
+		state.StatusChanges = append(state.StatusChanges, metav1.Time{Time: now})
+	}
+
+	window := defaultFlapWindow
+	if options.Window != nil {
+		window = options.Window.Duration
+	}
+
+	// Drop status changes outside the sliding window
+	i := 0
+	for i < len(state.StatusChanges) && now.Sub(state.StatusChanges[i].Time) > window {
+		i++
+	}
+	state.StatusChanges = state.StatusChanges[i:]
+
+	startThreshold := getIntOrDefault(options.StartThreshold, defaultFlapStartThreshold)
+	stopThreshold := getIntOrDefault(options.StopThreshold, startThreshold/2)
+
+	if !state.Flapping && len(state.StatusChanges) >= startThreshold {
+		state.Flapping = true
+		return true
+	}
+
+	if state.Flapping && len(state.StatusChanges) <= stopThreshold {
+		state.Flapping = false
+		return true
+	}
+
+	return false
+}
+
+// getFlappingReason returns the Condition reason reporting the liveness check flapping state
+func getFlappingReason(previous *libsveltosv1beta1.Condition, state *livenessCheckState) string {
+	if state.Flapping {
+		return FlappingReason
+	}
+
+	if previous != nil && previous.Reason == FlappingReason {
+		return FlappingStoppedReason
+	}
+
+	return ""
+}
+
+// getFlappingNotificationSummaries returns, for each flapping liveness check, the NotificationSummary
+// reporting that notification n was sent (with status) while the liveness check is flapping
+func getFlappingNotificationSummaries(n *libsveltosv1beta1.Notification, conditions []libsveltosv1beta1.Condition,
+	status libsveltosv1beta1.NotificationStatus) []libsveltosv1beta1.NotificationSummary {
+
+	summaries := make([]libsveltosv1beta1.NotificationSummary, 0)
+	for i := range conditions {
+		if conditions[i].Reason != FlappingReason {
+			continue
+		}
+		summaries = append(summaries, libsveltosv1beta1.NotificationSummary{
+			Name:   fmt.Sprintf("%s:flapping:%s", n.Name, conditions[i].Name),
+			Status: status,
+		})
+	}
+
+	return summaries
+}
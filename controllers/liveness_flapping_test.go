@@ -0,0 +1,127 @@
+/*
+Copyright 2023. projectsveltos.io. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers_test
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2/textlogger"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/projectsveltos/healthcheck-manager/controllers"
+	libsveltosv1beta1 "github.com/projectsveltos/libsveltos/api/v1beta1"
+)
+
+var _ = Describe("Liveness check flapping", func() {
+	var chc *libsveltosv1beta1.ClusterHealthCheck
+	var livenessCheck *libsveltosv1beta1.LivenessCheck
+
+	BeforeEach(func() {
+		livenessCheck = &libsveltosv1beta1.LivenessCheck{
+			Name: randomString(),
+			Type: libsveltosv1beta1.LivenessTypeAddons,
+		}
+
+		chc = &libsveltosv1beta1.ClusterHealthCheck{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: randomString(),
+				Annotations: map[string]string{
+					controllers.ClusterHealthCheckOptionsAnnotation: fmt.Sprintf(
+						"livenessChecks:\n  %s:\n    flapDetection:\n      window: 1h\n      startThreshold: 3\n      stopThreshold: 1",
+						livenessCheck.Name),
+				},
+			},
+			Spec: libsveltosv1beta1.ClusterHealthCheckSpec{
+				LivenessChecks: []libsveltosv1beta1.LivenessCheck{*livenessCheck},
+			},
+		}
+	})
+
+	It("detectFlapping starts and stops flapping based on status changes within window", func() {
+		options, err := controllers.GetLivenessCheckOptions(chc, livenessCheck)
+		Expect(err).To(BeNil())
+
+		c := fake.NewClientBuilder().WithScheme(scheme).Build()
+		states, err := controllers.GetLivenessCheckStates(context.TODO(), c, chc.Name, randomString(), randomString(),
+			libsveltosv1beta1.ClusterTypeSveltos)
+		Expect(err).To(BeNil())
+		state := states[livenessCheck.Name]
+
+		previous := &libsveltosv1beta1.Condition{Status: corev1.ConditionTrue}
+
+		// First evaluation and no status change are not counted
+		Expect(controllers.DetectFlapping(options.FlapDetection, nil, &state, corev1.ConditionFalse)).To(BeFalse())
+		Expect(controllers.DetectFlapping(options.FlapDetection, previous, &state, corev1.ConditionTrue)).To(BeFalse())
+		Expect(state.StatusChanges).To(BeEmpty())
+
+		statuses := []corev1.ConditionStatus{corev1.ConditionFalse, corev1.ConditionTrue}
+		for i := range 2 {
+			Expect(controllers.DetectFlapping(options.FlapDetection, previous, &state, statuses[i])).To(BeFalse())
+			previous = &libsveltosv1beta1.Condition{Status: statuses[i]}
+		}
+		Expect(state.Flapping).To(BeFalse())
+
+		// Third status change within window: flapping starts
+		Expect(controllers.DetectFlapping(options.FlapDetection, previous, &state, corev1.ConditionFalse)).To(BeTrue())
+		Expect(state.Flapping).To(BeTrue())
+		Expect(controllers.GetFlappingReason(previous, &state)).To(Equal(controllers.FlappingReason))
+		previous = &libsveltosv1beta1.Condition{Status: corev1.ConditionFalse, Reason: controllers.FlappingReason}
+
+		// Status changes age out of the window: flapping stops
+		for i := range state.StatusChanges {
+			state.StatusChanges[i] = metav1.Time{Time: time.Now().Add(-2 * time.Hour)}
+		}
+		Expect(controllers.DetectFlapping(options.FlapDetection, previous, &state, corev1.ConditionFalse)).To(BeTrue())
+		Expect(state.Flapping).To(BeFalse())
+		Expect(state.StatusChanges).To(BeEmpty())
+		Expect(controllers.GetFlappingReason(previous, &state)).To(Equal(controllers.FlappingStoppedReason))
+
+		previous = &libsveltosv1beta1.Condition{Status: corev1.ConditionFalse, Reason: controllers.FlappingStoppedReason}
+		Expect(controllers.GetFlappingReason(previous, &state)).To(BeEmpty())
+	})
+
+	It("getNotificationMessage reports flapping liveness checks", func() {
+		logger := textlogger.NewLogger(textlogger.NewConfig(textlogger.Verbosity(1)))
+
+		conditions := []libsveltosv1beta1.Condition{
+			{
+				Type:   libsveltosv1beta1.ConditionType(controllers.GetConditionType(livenessCheck)),
+				Name:   livenessCheck.Name,
+				Status: corev1.ConditionTrue,
+				Reason: controllers.FlappingReason,
+			},
+		}
+
+		message, passing := controllers.GetNotificationMessage(randomString(), randomString(),
+			libsveltosv1beta1.ClusterTypeCapi, conditions, nil, "", logger)
+		Expect(passing).To(BeFalse())
+		Expect(message).To(ContainSubstring("flapping"))
+
+		conditions[0].Reason = controllers.FlappingStoppedReason
+		message, passing = controllers.GetNotificationMessage(randomString(), randomString(),
+			libsveltosv1beta1.ClusterTypeCapi, conditions, nil, "", logger)
+		Expect(passing).To(BeTrue())
+		Expect(message).To(ContainSubstring("stopped flapping"))
+	})
+})
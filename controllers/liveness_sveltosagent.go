@@ -0,0 +1,183 @@
+/*
+Copyright 2023. projectsveltos.io. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-logr/logr"
+	appsv1 "k8s.io/api/apps/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	libsveltosv1beta1 "github.com/projectsveltos/libsveltos/api/v1beta1"
+	logs "github.com/projectsveltos/libsveltos/lib/logsettings"
+	"github.com/projectsveltos/libsveltos/lib/sveltos_upgrade"
+)
+
+const (
+	// LivenessTypeSveltosAgent refers to the state of sveltos-agent in the managed cluster:
+	// sveltos-agent is running, its version is compatible with this deployment and reports
+	// are being received from it.
+	LivenessTypeSveltosAgent = libsveltosv1beta1.LivenessType("SveltosAgent")
+
+	// defaultSveltosAgentNamespace and defaultSveltosAgentDeploymentName identify the sveltos-agent
+	// Deployment when a SveltosAgent liveness check does not set them
+	defaultSveltosAgentNamespace      = "projectsveltos"
+	defaultSveltosAgentDeploymentName = "sveltos-agent-manager"
+
+	// defaultMaxReportAge is used when a SveltosAgent liveness check does not set MaxReportAge
+	defaultMaxReportAge = 5 * time.Minute
+)
+
+var (
+	sveltosVersion string
+)
+
+// SetSveltosVersion sets the version sveltos-agent in managed clusters is expected to run
+func SetSveltosVersion(version string) {
+	sveltosVersion = version
+}
+
+func getSveltosVersion() string {
+	return sveltosVersion
+}
+
+// evaluateLivenessCheckSveltosAgent verifies sveltos-agent in the managed cluster is running, is
+// compatible with this deployment version and reports are being received from it.
+// Return values:
+// - bool indicating whether sveltos-agent is healthy
+// - human consumable message
+// - an error if any occurs
+func evaluateLivenessCheckSveltosAgent(ctx context.Context, c client.Client, clusterNamespace, clusterName string,
+	clusterType libsveltosv1beta1.ClusterType, chc *libsveltosv1beta1.ClusterHealthCheck,
+	livenessCheck *libsveltosv1beta1.LivenessCheck, logger logr.Logger) (passing bool, message string, err error) {
+
+	options, err := getLivenessCheckOptions(chc, livenessCheck)
+	if err != nil {
+		return false, "", err
+	}
+
+	remoteClient, err := getRemoteClient(ctx, c, clusterNamespace, clusterName, clusterType, logger)
+	if err != nil {
+		logger.V(logs.LogInfo).Info(fmt.Sprintf("failed to get managed cluster client: %v", err))
+		return false, "", err
+	}
+
+	passing = true
+
+	namespace, name := getSveltosAgentDeployment(options)
+	running, msg, err := isSveltosAgentRunning(ctx, remoteClient, namespace, name)
+	if err != nil {
+		logger.V(logs.LogInfo).Info(fmt.Sprintf("failed to verify sveltos-agent deployment: %v", err))
+		return false, "", err
+	}
+	if !running {
+		passing = false
+		message += msg
+	}
+
+	version := getSveltosVersion()
+	if !sveltos_upgrade.IsSveltosAgentVersionCompatible(ctx, remoteClient, version) {
+		passing = false
+		message += fmt.Sprintf("sveltos-agent version is not compatible with version %s  \n", version)
+	}
+
+	maxReportAge := defaultMaxReportAge
+	if options.MaxReportAge != nil {
+		maxReportAge = options.MaxReportAge.Duration
+	}
+
+	if reportMsg, recent := areReportsRecent(clusterNamespace, clusterName, clusterType, maxReportAge); !recent {
+		passing = false
+		message += reportMsg
+	}
+
+	return passing, message, nil
+}
+
+// getSveltosAgentDeployment returns namespace and name of the sveltos-agent Deployment
+func getSveltosAgentDeployment(options *livenessCheckOptions) (namespace, name string) {
+	namespace, name = defaultSveltosAgentNamespace, defaultSveltosAgentDeploymentName
+	if options.SveltosAgentNamespace != "" {
+		namespace = options.SveltosAgentNamespace
+	}
+	if options.SveltosAgentDeployment != "" {
+		name = options.SveltosAgentDeployment
+	}
+
+	return namespace, name
+}
+
+// isSveltosAgentRunning returns true if sveltos-agent deployment namespace/name in the managed cluster
+// has all replicas available. If not, a human consumable message is returned as well.
+func isSveltosAgentRunning(ctx context.Context, remoteClient client.Client, namespace, name string,
+) (running bool, message string, err error) {
+
+	depl := &appsv1.Deployment{}
+	err = remoteClient.Get(ctx, types.NamespacedName{Namespace: namespace, Name: name}, depl)
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return false, fmt.Sprintf("sveltos-agent deployment %s/%s not found  \n", namespace, name), nil
+		}
+		return false, "", err
+	}
+
+	desired := int32(1)
+	if depl.Spec.Replicas != nil {
+		desired = *depl.Spec.Replicas
+	}
+
+	if depl.Status.AvailableReplicas < desired {
+		return false, fmt.Sprintf("sveltos-agent has %d/%d available replicas  \n",
+			depl.Status.AvailableReplicas, desired), nil
+	}
+
+	return true, "", nil
+}
+
+// areReportsRecent returns true if reports were collected from the managed cluster within maxReportAge.
+// Only verified when this process collects reports from managed clusters. Till maxReportAge has passed
+// since collection started, a cluster which has not been collected from yet is not considered failing.
+func areReportsRecent(clusterNamespace, clusterName string, clusterType libsveltosv1beta1.ClusterType,
+	maxReportAge time.Duration) (string, bool) {
+
+	lastCollection, startTime := getLastHealthCheckReportCollection(clusterNamespace, clusterName, clusterType)
+	if startTime.IsZero() {
+		// Reports are sent by sveltos-agent. Nothing to verify here.
+		return "", true
+	}
+
+	now := collectionClock()
+	if lastCollection.IsZero() {
+		if now.Sub(startTime) < maxReportAge {
+			return "", true
+		}
+		return fmt.Sprintf("no report received from sveltos-agent in the last %s  \n", maxReportAge), false
+	}
+
+	if now.Sub(lastCollection) > maxReportAge {
+		// Message reports the last collection time (not the age) so it does not change at every evaluation
+		return fmt.Sprintf("last report received from sveltos-agent at %s  \n",
+			lastCollection.UTC().Format(time.RFC3339)), false
+	}
+
+	return "", true
+}
@@ -0,0 +1,136 @@
+/*
+Copyright 2023. projectsveltos.io. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers_test
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/projectsveltos/healthcheck-manager/controllers"
+	libsveltosv1beta1 "github.com/projectsveltos/libsveltos/api/v1beta1"
+)
+
+var _ = Describe("Liveness SveltosAgent", func() {
+	It("isSveltosAgentRunning returns false when sveltos-agent is missing or not available", func() {
+		c := fake.NewClientBuilder().WithScheme(scheme).Build()
+
+		running, message, err := controllers.IsSveltosAgentRunning(context.TODO(), c,
+			"projectsveltos", "sveltos-agent-manager")
+		Expect(err).To(BeNil())
+		Expect(running).To(BeFalse())
+		Expect(message).To(ContainSubstring("not found"))
+
+		depl := &appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: "projectsveltos",
+				Name:      "sveltos-agent-manager",
+			},
+			Spec: appsv1.DeploymentSpec{
+				Replicas: ptr.To(int32(1)),
+			},
+		}
+
+		initObjects := []client.Object{depl}
+		c = fake.NewClientBuilder().WithScheme(scheme).WithStatusSubresource(initObjects...).
+			WithObjects(initObjects...).Build()
+
+		running, message, err = controllers.IsSveltosAgentRunning(context.TODO(), c,
+			"projectsveltos", "sveltos-agent-manager")
+		Expect(err).To(BeNil())
+		Expect(running).To(BeFalse())
+		Expect(message).To(ContainSubstring("0/1 available replicas"))
+
+		depl.Status.AvailableReplicas = 1
+		Expect(c.Status().Update(context.TODO(), depl)).To(Succeed())
+
+		running, message, err = controllers.IsSveltosAgentRunning(context.TODO(), c,
+			"projectsveltos", "sveltos-agent-manager")
+		Expect(err).To(BeNil())
+		Expect(running).To(BeTrue())
+		Expect(message).To(BeEmpty())
+	})
+
+	It("getSveltosAgentDeployment defaults to projectsveltos/sveltos-agent-manager", func() {
+		livenessCheck := &libsveltosv1beta1.LivenessCheck{
+			Name: randomString(),
+			Type: controllers.LivenessTypeSveltosAgent,
+		}
+		chc := &libsveltosv1beta1.ClusterHealthCheck{
+			ObjectMeta: metav1.ObjectMeta{Name: randomString()},
+		}
+
+		options, err := controllers.GetLivenessCheckOptions(chc, livenessCheck)
+		Expect(err).To(BeNil())
+		namespace, name := controllers.GetSveltosAgentDeployment(options)
+		Expect(namespace).To(Equal("projectsveltos"))
+		Expect(name).To(Equal("sveltos-agent-manager"))
+
+		chc.Annotations = map[string]string{
+			controllers.ClusterHealthCheckOptionsAnnotation: "livenessChecks:\n  " + livenessCheck.Name +
+				":\n    sveltosAgentNamespace: agents\n    sveltosAgentDeployment: agent\n",
+		}
+		options, err = controllers.GetLivenessCheckOptions(chc, livenessCheck)
+		Expect(err).To(BeNil())
+		namespace, name = controllers.GetSveltosAgentDeployment(options)
+		Expect(namespace).To(Equal("agents"))
+		Expect(name).To(Equal("agent"))
+
+		c := fake.NewClientBuilder().WithScheme(scheme).Build()
+		running, message, err := controllers.IsSveltosAgentRunning(context.TODO(), c, namespace, name)
+		Expect(err).To(BeNil())
+		Expect(running).To(BeFalse())
+		Expect(message).To(ContainSubstring("agents/agent not found"))
+	})
+
+	It("areReportsRecent returns false when reports were not collected recently", func() {
+		clusterNamespace := randomString()
+		clusterName := randomString()
+		clusterType := libsveltosv1beta1.ClusterTypeSveltos
+
+		now := time.Now()
+		controllers.SetCollectionClock(func() time.Time { return now })
+		defer controllers.SetCollectionClock(time.Now)
+
+		controllers.SetCollectionStartTime(now)
+		controllers.RecordHealthCheckReportCollection(clusterNamespace, clusterName, clusterType)
+		lastCollection := now
+
+		message, recent := controllers.AreReportsRecent(clusterNamespace, clusterName, clusterType, time.Minute)
+		Expect(recent).To(BeTrue())
+		Expect(message).To(BeEmpty())
+
+		now = now.Add(2 * time.Minute)
+		message, recent = controllers.AreReportsRecent(clusterNamespace, clusterName, clusterType, time.Minute)
+		Expect(recent).To(BeFalse())
+		Expect(message).To(ContainSubstring("last report received from sveltos-agent"))
+		Expect(message).To(ContainSubstring(lastCollection.UTC().Format(time.RFC3339)))
+
+		// Message does not change while no new report is received
+		now = now.Add(time.Minute)
+		newMessage, _ := controllers.AreReportsRecent(clusterNamespace, clusterName, clusterType, time.Minute)
+		Expect(newMessage).To(Equal(message))
+	})
+})
@@ -18,6 +18,8 @@ package controllers_test
 
 import (
 	"context"
+	"fmt"
+	"time"
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
@@ -25,6 +27,7 @@ import (
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/klog/v2/textlogger"
 	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
 	"sigs.k8s.io/controller-runtime/pkg/client"
@@ -123,6 +126,193 @@ var _ = Describe("Liveness", func() {
 		Expect(len(clusterSummaries.Items)).To(Equal(1))
 	})
 
+	It("evaluateLivenessCheckHealthCheck returns Unknown when data is missing", func() {
+		clusterNamespace := randomString()
+		clusterName := randomString()
+		clusterType := libsveltosv1beta1.ClusterTypeSveltos
+
+		livenessCheck := libsveltosv1beta1.LivenessCheck{
+			Name: randomString(),
+			Type: libsveltosv1beta1.LivenessTypeHealthCheck,
+		}
+
+		chc := &libsveltosv1beta1.ClusterHealthCheck{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: randomString(),
+			},
+		}
+
+		c := fake.NewClientBuilder().WithScheme(scheme).Build()
+		logger := textlogger.NewLogger(textlogger.NewConfig(textlogger.Verbosity(1)))
+
+		status, message, err := controllers.EvaluateLivenessCheckHealthCheck(context.TODO(), c, clusterNamespace,
+			clusterName, clusterType, chc, &livenessCheck, logger)
+		Expect(err).To(BeNil())
+		Expect(status).To(Equal(corev1.ConditionUnknown))
+		Expect(message).To(ContainSubstring("references no HealthCheck"))
+
+		livenessCheck.LivenessSourceRef = &corev1.ObjectReference{
+			Kind:       libsveltosv1beta1.HealthCheckKind,
+			APIVersion: libsveltosv1beta1.GroupVersion.String(),
+			Name:       randomString(),
+		}
+
+		status, message, err = controllers.EvaluateLivenessCheckHealthCheck(context.TODO(), c, clusterNamespace,
+			clusterName, clusterType, chc, &livenessCheck, logger)
+		Expect(err).To(BeNil())
+		Expect(status).To(Equal(corev1.ConditionUnknown))
+		Expect(message).To(ContainSubstring("no HealthCheckReport received yet"))
+	})
+
+	It("evaluateLivenessCheckHealthCheck reports stale HealthCheckReports", func() {
+		clusterNamespace := randomString()
+		clusterName := randomString()
+		clusterType := libsveltosv1beta1.ClusterTypeSveltos
+		healthCheckName := randomString()
+
+		livenessCheck := libsveltosv1beta1.LivenessCheck{
+			Name: randomString(),
+			Type: libsveltosv1beta1.LivenessTypeHealthCheck,
+			LivenessSourceRef: &corev1.ObjectReference{
+				Kind:       libsveltosv1beta1.HealthCheckKind,
+				APIVersion: libsveltosv1beta1.GroupVersion.String(),
+				Name:       healthCheckName,
+			},
+		}
+
+		healthCheckReport := &libsveltosv1beta1.HealthCheckReport{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: clusterNamespace,
+				Name:      randomString(),
+				Labels:    libsveltosv1beta1.GetHealthCheckReportLabels(healthCheckName, clusterName, &clusterType),
+				Annotations: map[string]string{
+					controllers.HealthCheckReportLastRefreshAnnotation: time.Now().Add(-time.Hour).UTC().Format(time.RFC3339),
+				},
+			},
+		}
+
+		chc := &libsveltosv1beta1.ClusterHealthCheck{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: randomString(),
+			},
+			Spec: libsveltosv1beta1.ClusterHealthCheckSpec{
+				LivenessChecks: []libsveltosv1beta1.LivenessCheck{livenessCheck},
+			},
+		}
+
+		c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(healthCheckReport).Build()
+		logger := textlogger.NewLogger(textlogger.NewConfig(textlogger.Verbosity(1)))
+
+		// No maxReportAge: reports never go stale
+		status, _, err := controllers.EvaluateLivenessCheckHealthCheck(context.TODO(), c, clusterNamespace,
+			clusterName, clusterType, chc, &livenessCheck, logger)
+		Expect(err).To(BeNil())
+		Expect(status).To(Equal(corev1.ConditionTrue))
+		Expect(controllers.GetStaleReportRequeueAfter(chc)).To(BeZero())
+
+		chc.Annotations = map[string]string{
+			controllers.ClusterHealthCheckOptionsAnnotation: fmt.Sprintf("livenessChecks:\n  %s:\n    maxReportAge: 10m",
+				livenessCheck.Name),
+		}
+		status, message, err := controllers.EvaluateLivenessCheckHealthCheck(context.TODO(), c, clusterNamespace,
+			clusterName, clusterType, chc, &livenessCheck, logger)
+		Expect(err).To(BeNil())
+		Expect(status).To(Equal(corev1.ConditionUnknown))
+		Expect(message).To(ContainSubstring("is stale"))
+		Expect(controllers.GetStaleReportRequeueAfter(chc)).To(Equal(5 * time.Minute))
+
+		chc.Annotations = map[string]string{
+			controllers.ClusterHealthCheckOptionsAnnotation: fmt.Sprintf(
+				"livenessChecks:\n  %s:\n    maxReportAge: 10m\n    failOnStaleReport: true", livenessCheck.Name),
+		}
+		status, _, err = controllers.EvaluateLivenessCheckHealthCheck(context.TODO(), c, clusterNamespace,
+			clusterName, clusterType, chc, &livenessCheck, logger)
+		Expect(err).To(BeNil())
+		Expect(status).To(Equal(corev1.ConditionFalse))
+
+		chc.Annotations = map[string]string{
+			controllers.ClusterHealthCheckOptionsAnnotation: fmt.Sprintf("livenessChecks:\n  %s:\n    maxReportAge: 2h",
+				livenessCheck.Name),
+		}
+		status, _, err = controllers.EvaluateLivenessCheckHealthCheck(context.TODO(), c, clusterNamespace,
+			clusterName, clusterType, chc, &livenessCheck, logger)
+		Expect(err).To(BeNil())
+		Expect(status).To(Equal(corev1.ConditionTrue))
+	})
+
+	It("isFailureNotificationDue notifies failures only after notifyAfter", func() {
+		livenessCheck := &libsveltosv1beta1.LivenessCheck{
+			Name: randomString(),
+			Type: libsveltosv1beta1.LivenessTypeAddons,
+		}
+
+		c := fake.NewClientBuilder().WithScheme(scheme).Build()
+		states, err := controllers.GetLivenessCheckStates(context.TODO(), c, randomString(), randomString(),
+			randomString(), libsveltosv1beta1.ClusterTypeSveltos)
+		Expect(err).To(BeNil())
+		state := states[livenessCheck.Name]
+
+		// Just started failing
+		notify, decided := controllers.IsFailureNotificationDue(time.Hour, nil, &state, corev1.ConditionFalse)
+		Expect(decided).To(BeTrue())
+		Expect(notify).To(BeFalse())
+		Expect(state.NotificationPending).To(BeTrue())
+
+		previous := &libsveltosv1beta1.Condition{
+			Status:             corev1.ConditionFalse,
+			LastTransitionTime: metav1.Time{Time: time.Now().Add(-30 * time.Minute)},
+		}
+		notify, decided = controllers.IsFailureNotificationDue(time.Hour, previous, &state, corev1.ConditionFalse)
+		Expect(decided).To(BeTrue())
+		Expect(notify).To(BeFalse())
+
+		// Failing for longer than notifyAfter: notified once
+		previous.LastTransitionTime = metav1.Time{Time: time.Now().Add(-2 * time.Hour)}
+		notify, decided = controllers.IsFailureNotificationDue(time.Hour, previous, &state, corev1.ConditionFalse)
+		Expect(decided).To(BeTrue())
+		Expect(notify).To(BeTrue())
+		Expect(state.NotificationPending).To(BeFalse())
+
+		notify, decided = controllers.IsFailureNotificationDue(time.Hour, previous, &state, corev1.ConditionFalse)
+		Expect(decided).To(BeFalse())
+		Expect(notify).To(BeFalse())
+
+		// Recovering before notifyAfter is not notified
+		notify, _ = controllers.IsFailureNotificationDue(time.Hour, nil, &state, corev1.ConditionFalse)
+		Expect(notify).To(BeFalse())
+		notify, decided = controllers.IsFailureNotificationDue(time.Hour, previous, &state, corev1.ConditionTrue)
+		Expect(decided).To(BeTrue())
+		Expect(notify).To(BeFalse())
+		Expect(state.NotificationPending).To(BeFalse())
+	})
+
+	It("isNotifiableChange ignores changes involving Unknown only when notifyOnUnknown is false", func() {
+		chc := &libsveltosv1beta1.ClusterHealthCheck{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: randomString(),
+			},
+		}
+
+		options, err := controllers.GetClusterHealthCheckOptions(chc)
+		Expect(err).To(BeNil())
+
+		previous := &libsveltosv1beta1.Condition{Status: corev1.ConditionUnknown}
+		Expect(controllers.IsNotifiableChange(options, nil, corev1.ConditionUnknown)).To(BeTrue())
+		Expect(controllers.IsNotifiableChange(options, previous, corev1.ConditionTrue)).To(BeTrue())
+
+		chc.Annotations = map[string]string{
+			controllers.ClusterHealthCheckOptionsAnnotation: "notifyOnUnknown: false",
+		}
+		options, err = controllers.GetClusterHealthCheckOptions(chc)
+		Expect(err).To(BeNil())
+
+		Expect(controllers.IsNotifiableChange(options, nil, corev1.ConditionUnknown)).To(BeFalse())
+		Expect(controllers.IsNotifiableChange(options, previous, corev1.ConditionTrue)).To(BeFalse())
+		Expect(controllers.IsNotifiableChange(options, nil, corev1.ConditionFalse)).To(BeTrue())
+		previous.Status = corev1.ConditionTrue
+		Expect(controllers.IsNotifiableChange(options, previous, corev1.ConditionFalse)).To(BeTrue())
+	})
+
 	It("hasLivenessCheckStatusChange returns true when status was never evaluated before and status is different", func() {
 		chc := &libsveltosv1beta1.ClusterHealthCheck{
 			ObjectMeta: metav1.ObjectMeta{
@@ -142,9 +332,9 @@ var _ = Describe("Liveness", func() {
 		}
 
 		Expect(controllers.HasLivenessCheckStatusChange(chc, clusterNamespace, clusterName, clusterType,
-			&livenessCheck, true, "")).To(BeTrue())
+			&livenessCheck, corev1.ConditionTrue, "")).To(BeTrue())
 		Expect(controllers.HasLivenessCheckStatusChange(chc, clusterNamespace, clusterName, clusterType,
-			&livenessCheck, false, "")).To(BeTrue())
+			&livenessCheck, corev1.ConditionFalse, "")).To(BeTrue())
 
 		apiVersion, kind := schema.GroupVersionKind{
 			Group:   libsveltosv1beta1.GroupVersion.Group,
@@ -175,15 +365,15 @@ var _ = Describe("Liveness", func() {
 		}
 
 		Expect(controllers.HasLivenessCheckStatusChange(chc, clusterNamespace, clusterName, clusterType,
-			&livenessCheck, true, "")).To(BeTrue())
+			&livenessCheck, corev1.ConditionTrue, "")).To(BeTrue())
 		Expect(controllers.HasLivenessCheckStatusChange(chc, clusterNamespace, clusterName, clusterType,
-			&livenessCheck, false, "")).To(BeFalse())
+			&livenessCheck, corev1.ConditionFalse, "")).To(BeFalse())
 
 		chc.Status.ClusterConditions[0].Conditions[0].Status = corev1.ConditionTrue
 		Expect(controllers.HasLivenessCheckStatusChange(chc, clusterNamespace, clusterName, clusterType,
-			&livenessCheck, true, "")).To(BeFalse())
+			&livenessCheck, corev1.ConditionTrue, "")).To(BeFalse())
 		Expect(controllers.HasLivenessCheckStatusChange(chc, clusterNamespace, clusterName, clusterType,
-			&livenessCheck, false, "")).To(BeTrue())
+			&livenessCheck, corev1.ConditionFalse, "")).To(BeTrue())
 	})
 
 	It("evaluateLivenessCheckAddOns returns true when add-ons are deployed", func() {
@@ -202,10 +392,111 @@ var _ = Describe("Liveness", func() {
 		Expect(c.List(context.TODO(), chcs)).To(Succeed())
 		Expect(len(chcs.Items)).To(Equal(1))
 
-		passing, err := controllers.EvaluateLivenessCheckAddOns(context.TODO(), c, clusterNamespace, clusterName, clusterType, &chcs.Items[0],
+		passing, message, err := controllers.EvaluateLivenessCheckAddOns(context.TODO(), c, clusterNamespace, clusterName, clusterType, &chcs.Items[0],
 			&livenessCheck, textlogger.NewLogger(textlogger.NewConfig(textlogger.Verbosity(1))))
 		Expect(err).To(BeNil())
 		Expect(passing).To(BeTrue())
+		Expect(message).To(BeEmpty())
+	})
+
+	It("evaluateLivenessCheckAddOns reports failing features and filters by profile and feature", func() {
+		clusterNamespace := randomString()
+		clusterName := randomString()
+		clusterType := libsveltosv1beta1.ClusterTypeCapi
+		clusterProfileName := randomString()
+		failureMessage := "chart not found"
+
+		clusterSummary := &configv1beta1.ClusterSummary{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: clusterNamespace,
+				Name:      randomString(),
+				Labels: map[string]string{
+					configv1beta1.ClusterTypeLabel: string(clusterType),
+					configv1beta1.ClusterNameLabel: clusterName,
+				},
+				OwnerReferences: []metav1.OwnerReference{
+					{
+						APIVersion: configv1beta1.GroupVersion.String(),
+						Kind:       configv1beta1.ClusterProfileKind,
+						Name:       clusterProfileName,
+						UID:        types.UID(randomString()),
+					},
+				},
+			},
+			Status: configv1beta1.ClusterSummaryStatus{
+				FeatureSummaries: []configv1beta1.FeatureSummary{
+					{
+						FeatureID:      configv1beta1.FeatureHelm,
+						Status:         configv1beta1.FeatureStatusFailed,
+						FailureMessage: &failureMessage,
+					},
+					{
+						FeatureID: configv1beta1.FeatureResources,
+						Status:    configv1beta1.FeatureStatusProvisioned,
+					},
+				},
+			},
+		}
+
+		livenessCheck := libsveltosv1beta1.LivenessCheck{
+			Name: randomString(),
+			Type: libsveltosv1beta1.LivenessTypeAddons,
+		}
+
+		chc := &libsveltosv1beta1.ClusterHealthCheck{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: randomString(),
+			},
+			Spec: libsveltosv1beta1.ClusterHealthCheckSpec{
+				LivenessChecks: []libsveltosv1beta1.LivenessCheck{livenessCheck},
+			},
+		}
+
+		initObjects := []client.Object{clusterSummary, chc}
+		c := fake.NewClientBuilder().WithScheme(scheme).WithStatusSubresource(initObjects...).
+			WithObjects(initObjects...).Build()
+
+		logger := textlogger.NewLogger(textlogger.NewConfig(textlogger.Verbosity(1)))
+
+		passing, message, err := controllers.EvaluateLivenessCheckAddOns(context.TODO(), c, clusterNamespace, clusterName,
+			clusterType, chc, &livenessCheck, logger)
+		Expect(err).To(BeNil())
+		Expect(passing).To(BeFalse())
+		Expect(message).To(ContainSubstring(clusterSummary.Name))
+		Expect(message).To(ContainSubstring(string(configv1beta1.FeatureHelm)))
+		Expect(message).To(ContainSubstring(failureMessage))
+		Expect(message).ToNot(ContainSubstring(string(configv1beta1.FeatureResources)))
+
+		// Only Resources feature is verified
+		chc.Annotations = map[string]string{
+			controllers.ClusterHealthCheckOptionsAnnotation: "livenessChecks:\n  " + livenessCheck.Name +
+				":\n    featureIDs: [Resources]\n",
+		}
+		passing, message, err = controllers.EvaluateLivenessCheckAddOns(context.TODO(), c, clusterNamespace, clusterName,
+			clusterType, chc, &livenessCheck, logger)
+		Expect(err).To(BeNil())
+		Expect(passing).To(BeTrue())
+		Expect(message).To(BeEmpty())
+
+		// Only add-ons deployed by a different ClusterProfile are verified
+		chc.Annotations = map[string]string{
+			controllers.ClusterHealthCheckOptionsAnnotation: "livenessChecks:\n  " + livenessCheck.Name +
+				":\n    clusterProfiles: [" + randomString() + "]\n",
+		}
+		passing, _, err = controllers.EvaluateLivenessCheckAddOns(context.TODO(), c, clusterNamespace, clusterName,
+			clusterType, chc, &livenessCheck, logger)
+		Expect(err).To(BeNil())
+		Expect(passing).To(BeTrue())
+
+		// Only add-ons deployed by the ClusterProfile owning the ClusterSummary are verified
+		chc.Annotations = map[string]string{
+			controllers.ClusterHealthCheckOptionsAnnotation: "livenessChecks:\n  " + livenessCheck.Name +
+				":\n    clusterProfiles: [" + clusterProfileName + "]\n",
+		}
+		passing, _, err = controllers.EvaluateLivenessCheckAddOns(context.TODO(), c, clusterNamespace, clusterName,
+			clusterType, chc, &livenessCheck, logger)
+		Expect(err).To(BeNil())
+		Expect(passing).To(BeFalse())
 	})
 
 	It("evaluateLivenessCheck", func() {
@@ -224,10 +515,10 @@ var _ = Describe("Liveness", func() {
 		Expect(c.List(context.TODO(), chcs)).To(Succeed())
 		Expect(len(chcs.Items)).To(Equal(1))
 
-		statusChanged, passing, _, err := controllers.EvaluateLivenessCheck(context.TODO(), c, clusterNamespace, clusterName, clusterType, &chcs.Items[0],
-			&livenessCheck, textlogger.NewLogger(textlogger.NewConfig(textlogger.Verbosity(1))))
+		status, statusChanged, _, err := controllers.EvaluateLivenessCheck(context.TODO(), c, clusterNamespace, clusterName, clusterType, &chcs.Items[0],
+			&livenessCheck, nil, textlogger.NewLogger(textlogger.NewConfig(textlogger.Verbosity(1))))
 		Expect(err).To(BeNil())
-		Expect(passing).To(BeTrue())
+		Expect(status).To(Equal(corev1.ConditionTrue))
 		Expect(statusChanged).To(BeTrue())
 	})
 })
@@ -0,0 +1,328 @@
+/*
+Copyright 2023. projectsveltos.io. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	libsveltosv1beta1 "github.com/projectsveltos/libsveltos/api/v1beta1"
+)
+
+const (
+	// ClusterHealthCheckStateLabel is set on the ConfigMaps storing ClusterHealthCheck evaluation state.
+	// Its value is the ClusterHealthCheck name.
+	ClusterHealthCheckStateLabel = "healthcheck.projectsveltos.io/clusterhealthcheck"
+
+	stateConfigMapPrefix = "chc-state-"
+
+	// Keys in the per cluster state ConfigMap
+	livenessCheckStateKey = "livenessChecks"
+	notificationStateKey  = "notifications"
+	escalationStateKey    = "escalations"
+)
+
+// livenessCheckState contains information, about past evaluations of a liveness check in a cluster,
+// which must survive controller restarts.
+// ClusterHealthCheck Status is defined in libsveltos, so this is stored in a ConfigMap in ReportNamespace.
+// There is one such ConfigMap per ClusterHealthCheck and cluster, so the amount of state does not grow
+// with the number of matching clusters and evaluations of different clusters never conflict.
+type livenessCheckState struct {
+	// ConsecutiveFailures is the number of consecutive evaluations the liveness check was failing
+	ConsecutiveFailures int `json:"consecutiveFailures,omitempty"`
+
+	// ConsecutivePasses is the number of consecutive evaluations the liveness check was passing
+	ConsecutivePasses int `json:"consecutivePasses,omitempty"`
+
+	// StatusChanges contains the times the liveness check status changed within the flap detection window
+	StatusChanges []metav1.Time `json:"statusChanges,omitempty"`
+
+	// Flapping is true while the liveness check is flapping
+	Flapping bool `json:"flapping,omitempty"`
+
+	// NotificationPending is true while the liveness check is failing but was not failing
+	// long enough to be notified
+	NotificationPending bool `json:"notificationPending,omitempty"`
+}
+
+// clusterLivenessCheckStates contains the state of all liveness checks in a cluster.
+// Key is the liveness check name.
+type clusterLivenessCheckStates map[string]livenessCheckState
+
+// notificationState contains information about past deliveries of a notification for a cluster
+type notificationState struct {
+	// LastSent is the last time the notification was delivered
+	LastSent metav1.Time `json:"lastSent,omitempty"`
+}
+
+// clusterNotificationStates contains the state of all notifications for a cluster.
+// Key is the notification name.
+type clusterNotificationStates map[string]notificationState
+
+// getStateConfigMapName returns the name of the ConfigMap storing state for a ClusterHealthCheck
+// not related to any specific cluster
+func getStateConfigMapName(chcName string) string {
+	return stateConfigMapPrefix + chcName
+}
+
+// getClusterStateConfigMapName returns the name of the ConfigMap storing state for a ClusterHealthCheck
+// in a cluster. The suffix is a hash of both ClusterHealthCheck and cluster, so the name is unique even
+// when the ClusterHealthCheck name is truncated to fit.
+func getClusterStateConfigMapName(chcName, clusterNamespace, clusterName string,
+	clusterType libsveltosv1beta1.ClusterType) string {
+
+	h := sha256.Sum256([]byte(chcName + "/" + getStateKey(clusterNamespace, clusterName, clusterType)))
+	suffix := fmt.Sprintf("-%x", h[:8])
+
+	maxLength := validation.DNS1123SubdomainMaxLength - len(stateConfigMapPrefix) - len(suffix)
+	if len(chcName) > maxLength {
+		chcName = chcName[:maxLength]
+	}
+	return stateConfigMapPrefix + chcName + suffix
+}
+
+// getStateKey returns a key identifying a cluster.
+// Namespaces cannot contain dots, so the key is unique.
+func getStateKey(clusterNamespace, clusterName string, clusterType libsveltosv1beta1.ClusterType) string {
+	return fmt.Sprintf("%s.%s.%s", strings.ToLower(string(clusterType)), clusterNamespace, clusterName)
+}
+
+// getLivenessCheckStates returns the state of all liveness checks of a ClusterHealthCheck in a cluster.
+// Empty if none was stored yet.
+func getLivenessCheckStates(ctx context.Context, c client.Client, chcName, clusterNamespace, clusterName string,
+	clusterType libsveltosv1beta1.ClusterType) (clusterLivenessCheckStates, error) {
+
+	states := clusterLivenessCheckStates{}
+	err := getStateValue(ctx, c, getClusterStateConfigMapName(chcName, clusterNamespace, clusterName, clusterType),
+		livenessCheckStateKey, &states)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse liveness check state: %w", err)
+	}
+
+	return states, nil
+}
+
+// updateLivenessCheckStates stores the state of all liveness checks of a ClusterHealthCheck in a cluster.
+func updateLivenessCheckStates(ctx context.Context, c client.Client, chc *libsveltosv1beta1.ClusterHealthCheck,
+	clusterNamespace, clusterName string, clusterType libsveltosv1beta1.ClusterType,
+	states clusterLivenessCheckStates) error {
+
+	return updateStateValue(ctx, c, chc,
+		getClusterStateConfigMapName(chc.Name, clusterNamespace, clusterName, clusterType), livenessCheckStateKey, states)
+}
+
+// getNotificationStates returns the state of all notifications of a ClusterHealthCheck for a cluster.
+// Empty if none was stored yet.
+func getNotificationStates(ctx context.Context, c client.Client, chcName, clusterNamespace, clusterName string,
+	clusterType libsveltosv1beta1.ClusterType) (clusterNotificationStates, error) {
+
+	states := clusterNotificationStates{}
+	err := getStateValue(ctx, c, getClusterStateConfigMapName(chcName, clusterNamespace, clusterName, clusterType),
+		notificationStateKey, &states)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse notification state: %w", err)
+	}
+
+	return states, nil
+}
+
+// updateNotificationStates stores the state of all notifications of a ClusterHealthCheck for a cluster.
+func updateNotificationStates(ctx context.Context, c client.Client, chc *libsveltosv1beta1.ClusterHealthCheck,
+	clusterNamespace, clusterName string, clusterType libsveltosv1beta1.ClusterType,
+	states clusterNotificationStates) error {
+
+	return updateStateValue(ctx, c, chc,
+		getClusterStateConfigMapName(chc.Name, clusterNamespace, clusterName, clusterType), notificationStateKey, states)
+}
+
+// getStateValue parses the value stored for key in the state ConfigMap configMapName.
+// value is left untouched if nothing was stored yet.
+func getStateValue(ctx context.Context, c client.Client, configMapName, key string, value any) error {
+	configMap := &corev1.ConfigMap{}
+	err := c.Get(ctx, types.NamespacedName{Namespace: ReportNamespace, Name: configMapName}, configMap)
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+
+	data, ok := configMap.Data[key]
+	if !ok {
+		return nil
+	}
+
+	return json.Unmarshal([]byte(data), value)
+}
+
+// updateStateValue stores value for key in the state ConfigMap configMapName.
+// The ConfigMap is created if it does not exist yet. It is owned by the ClusterHealthCheck so it is
+// garbage collected when ClusterHealthCheck is deleted.
+func updateStateValue(ctx context.Context, c client.Client, chc *libsveltosv1beta1.ClusterHealthCheck,
+	configMapName, key string, value any) error {
+
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		configMap := &corev1.ConfigMap{}
+		err := c.Get(ctx, types.NamespacedName{Namespace: ReportNamespace, Name: configMapName}, configMap)
+		if err != nil {
+			if !apierrors.IsNotFound(err) {
+				return err
+			}
+			configMap = &corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: ReportNamespace,
+					Name:      configMapName,
+					Labels:    map[string]string{ClusterHealthCheckStateLabel: chc.Name},
+					OwnerReferences: []metav1.OwnerReference{
+						{
+							APIVersion: libsveltosv1beta1.GroupVersion.String(),
+							Kind:       libsveltosv1beta1.ClusterHealthCheckKind,
+							Name:       chc.Name,
+							UID:        chc.UID,
+						},
+					},
+				},
+				Data: map[string]string{key: string(data)},
+			}
+			return c.Create(ctx, configMap)
+		}
+
+		if configMap.Data == nil {
+			configMap.Data = map[string]string{}
+		}
+		if configMap.Data[key] == string(data) {
+			return nil
+		}
+		configMap.Data[key] = string(data)
+		return c.Update(ctx, configMap)
+	})
+}
+
+// removeClusterStates removes all state (liveness checks, notifications and escalations) stored for a
+// ClusterHealthCheck in a cluster
+func removeClusterStates(ctx context.Context, c client.Client, chcName, clusterNamespace, clusterName string,
+	clusterType libsveltosv1beta1.ClusterType) error {
+
+	configMap := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: ReportNamespace,
+			Name:      getClusterStateConfigMapName(chcName, clusterNamespace, clusterName, clusterType),
+		},
+	}
+	return client.IgnoreNotFound(c.Delete(ctx, configMap))
+}
+
+// removeStateValues removes keys from the state ConfigMap configMapName
+func removeStateValues(ctx context.Context, c client.Client, configMapName string, keys ...string) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		configMap := &corev1.ConfigMap{}
+		err := c.Get(ctx, types.NamespacedName{Namespace: ReportNamespace, Name: configMapName}, configMap)
+		if err != nil {
+			if apierrors.IsNotFound(err) {
+				return nil
+			}
+			return err
+		}
+
+		found := false
+		for _, key := range keys {
+			if _, ok := configMap.Data[key]; ok {
+				found = true
+				delete(configMap.Data, key)
+			}
+		}
+		if !found {
+			return nil
+		}
+		return c.Update(ctx, configMap)
+	})
+}
+
+// applyThresholds updates the liveness check state with the outcome of last evaluation and returns the
+// liveness check status and message once failure and success thresholds are considered:
+// - a liveness check not currently failing is reported as failing only after FailureThreshold
+// consecutive failing evaluations;
+// - a failing liveness check is reported as passing only after SuccessThreshold consecutive passing
+// evaluations.
+// Until a threshold is crossed, previous status and message are reported.
+func applyThresholds(options *livenessCheckOptions, previous *libsveltosv1beta1.Condition,
+	state *livenessCheckState, status corev1.ConditionStatus, message string) (corev1.ConditionStatus, string) {
+
+	switch status {
+	case corev1.ConditionFalse:
+		state.ConsecutiveFailures++
+		state.ConsecutivePasses = 0
+	case corev1.ConditionTrue:
+		state.ConsecutivePasses++
+		state.ConsecutiveFailures = 0
+	default:
+		state.ConsecutiveFailures = 0
+		state.ConsecutivePasses = 0
+	}
+
+	previousStatus := corev1.ConditionTrue
+	var previousMessage string
+	if previous != nil {
+		previousStatus = previous.Status
+		previousMessage = previous.Message
+	}
+
+	if status == corev1.ConditionFalse && previousStatus != corev1.ConditionFalse &&
+		state.ConsecutiveFailures < getIntOrDefault(options.FailureThreshold, 1) {
+
+		return previousStatus, previousMessage
+	}
+
+	if status == corev1.ConditionTrue && previousStatus == corev1.ConditionFalse &&
+		state.ConsecutivePasses < getIntOrDefault(options.SuccessThreshold, 1) {
+
+		return previousStatus, previousMessage
+	}
+
+	return status, message
+}
+
+// needsLivenessCheckState returns true if any liveness check requires more than one evaluation to
+// change status, has flap detection enabled or delays failure notifications
+func needsLivenessCheckState(options *clusterHealthCheckOptions) bool {
+	for _, lcOptions := range options.LivenessChecks {
+		if getIntOrDefault(lcOptions.FailureThreshold, 1) > 1 || getIntOrDefault(lcOptions.SuccessThreshold, 1) > 1 {
+			return true
+		}
+		if lcOptions.FlapDetection != nil || lcOptions.NotifyAfter != nil {
+			return true
+		}
+	}
+
+	return false
+}
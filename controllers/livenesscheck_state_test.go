@@ -0,0 +1,172 @@
+/*
+Copyright 2023. projectsveltos.io. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers_test
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/projectsveltos/healthcheck-manager/controllers"
+	libsveltosv1beta1 "github.com/projectsveltos/libsveltos/api/v1beta1"
+)
+
+var _ = Describe("Liveness check state", func() {
+	var chc *libsveltosv1beta1.ClusterHealthCheck
+	var livenessCheck *libsveltosv1beta1.LivenessCheck
+
+	BeforeEach(func() {
+		livenessCheck = &libsveltosv1beta1.LivenessCheck{
+			Name: randomString(),
+			Type: libsveltosv1beta1.LivenessTypeAddons,
+		}
+
+		chc = &libsveltosv1beta1.ClusterHealthCheck{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: randomString(),
+				Annotations: map[string]string{
+					controllers.ClusterHealthCheckOptionsAnnotation: fmt.Sprintf(
+						"livenessChecks:\n  %s:\n    failureThreshold: 3\n    successThreshold: 2", livenessCheck.Name),
+				},
+			},
+			Spec: libsveltosv1beta1.ClusterHealthCheckSpec{
+				LivenessChecks: []libsveltosv1beta1.LivenessCheck{*livenessCheck},
+			},
+		}
+	})
+
+	It("applyThresholds changes status only once threshold is crossed", func() {
+		options, err := controllers.GetLivenessCheckOptions(chc, livenessCheck)
+		Expect(err).To(BeNil())
+
+		c := fake.NewClientBuilder().WithScheme(scheme).Build()
+		states, err := controllers.GetLivenessCheckStates(context.TODO(), c, chc.Name, randomString(), randomString(),
+			libsveltosv1beta1.ClusterTypeSveltos)
+		Expect(err).To(BeNil())
+		state := states[livenessCheck.Name]
+
+		// Two failures are not enough
+		for range 2 {
+			status, message := controllers.ApplyThresholds(options, nil, &state, corev1.ConditionFalse, "failing")
+			Expect(status).To(Equal(corev1.ConditionTrue))
+			Expect(message).To(BeEmpty())
+		}
+
+		// A passing evaluation resets the count
+		status, _ := controllers.ApplyThresholds(options, nil, &state, corev1.ConditionTrue, "")
+		Expect(status).To(Equal(corev1.ConditionTrue))
+		Expect(state.ConsecutiveFailures).To(BeZero())
+
+		var message string
+		for range 3 {
+			status, message = controllers.ApplyThresholds(options, nil, &state, corev1.ConditionFalse, "failing")
+		}
+		Expect(status).To(Equal(corev1.ConditionFalse))
+		Expect(message).To(Equal("failing"))
+
+		previous := &libsveltosv1beta1.Condition{Status: corev1.ConditionFalse, Message: "failing"}
+
+		// One passing evaluation is not enough for a failing liveness check to recover
+		status, message = controllers.ApplyThresholds(options, previous, &state, corev1.ConditionTrue, "")
+		Expect(status).To(Equal(corev1.ConditionFalse))
+		Expect(message).To(Equal("failing"))
+
+		status, _ = controllers.ApplyThresholds(options, previous, &state, corev1.ConditionTrue, "")
+		Expect(status).To(Equal(corev1.ConditionTrue))
+
+		// Unknown is not subject to thresholds
+		status, _ = controllers.ApplyThresholds(options, previous, &state, corev1.ConditionUnknown, "stale")
+		Expect(status).To(Equal(corev1.ConditionUnknown))
+	})
+
+	It("liveness check state is stored per cluster", func() {
+		clusterNamespace := randomString()
+		clusterName := randomString()
+		clusterType := libsveltosv1beta1.ClusterTypeCapi
+
+		c := fake.NewClientBuilder().WithScheme(scheme).Build()
+
+		states, err := controllers.GetLivenessCheckStates(context.TODO(), c, chc.Name, clusterNamespace, clusterName,
+			clusterType)
+		Expect(err).To(BeNil())
+		Expect(states).To(BeEmpty())
+
+		state := states[livenessCheck.Name]
+		state.ConsecutiveFailures = 2
+		states[livenessCheck.Name] = state
+		Expect(controllers.UpdateLivenessCheckStates(context.TODO(), c, chc, clusterNamespace, clusterName,
+			clusterType, states)).To(Succeed())
+
+		configMapName := controllers.GetClusterStateConfigMapName(chc.Name, clusterNamespace, clusterName, clusterType)
+		Expect(configMapName).To(HavePrefix("chc-state-" + chc.Name + "-"))
+		configMap := &corev1.ConfigMap{}
+		Expect(c.Get(context.TODO(), types.NamespacedName{Namespace: controllers.ReportNamespace,
+			Name: configMapName}, configMap)).To(Succeed())
+		Expect(configMap.Labels[controllers.ClusterHealthCheckStateLabel]).To(Equal(chc.Name))
+		Expect(configMap.OwnerReferences).To(HaveLen(1))
+
+		// State of another cluster is independent and stored in a different ConfigMap
+		otherClusterName := randomString()
+		otherStates, err := controllers.GetLivenessCheckStates(context.TODO(), c, chc.Name, clusterNamespace,
+			otherClusterName, clusterType)
+		Expect(err).To(BeNil())
+		Expect(otherStates).To(BeEmpty())
+		otherStates[livenessCheck.Name] = controllers.LivenessCheckState{ConsecutiveFailures: 1}
+		Expect(controllers.UpdateLivenessCheckStates(context.TODO(), c, chc, clusterNamespace, otherClusterName,
+			clusterType, otherStates)).To(Succeed())
+		configMaps := &corev1.ConfigMapList{}
+		Expect(c.List(context.TODO(), configMaps,
+			client.MatchingLabels{controllers.ClusterHealthCheckStateLabel: chc.Name})).To(Succeed())
+		Expect(configMaps.Items).To(HaveLen(2))
+
+		states, err = controllers.GetLivenessCheckStates(context.TODO(), c, chc.Name, clusterNamespace, clusterName,
+			clusterType)
+		Expect(err).To(BeNil())
+		Expect(states[livenessCheck.Name].ConsecutiveFailures).To(Equal(2))
+
+		Expect(controllers.RemoveClusterStates(context.TODO(), c, chc.Name, clusterNamespace, clusterName,
+			clusterType)).To(Succeed())
+		states, err = controllers.GetLivenessCheckStates(context.TODO(), c, chc.Name, clusterNamespace, clusterName,
+			clusterType)
+		Expect(err).To(BeNil())
+		Expect(states).To(BeEmpty())
+		Expect(c.List(context.TODO(), configMaps,
+			client.MatchingLabels{controllers.ClusterHealthCheckStateLabel: chc.Name})).To(Succeed())
+		Expect(configMaps.Items).To(HaveLen(1))
+	})
+
+	It("getClusterStateConfigMapName returns a valid name for long ClusterHealthCheck names", func() {
+		longName := strings.Repeat("a", validation.DNS1123SubdomainMaxLength)
+		name := controllers.GetClusterStateConfigMapName(longName, randomString(), randomString(),
+			libsveltosv1beta1.ClusterTypeSveltos)
+		Expect(validation.IsDNS1123Subdomain(name)).To(BeEmpty())
+
+		otherName := controllers.GetClusterStateConfigMapName(longName+"b", randomString(), randomString(),
+			libsveltosv1beta1.ClusterTypeSveltos)
+		Expect(otherName).ToNot(Equal(name))
+	})
+})
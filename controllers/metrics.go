@@ -17,6 +17,7 @@ package controllers
 import (
 	"errors"
 	"fmt"
+	"strconv"
 	"strings"
 	"time"
 
@@ -37,12 +38,221 @@ var (
 			Buckets:   []float64{0.1, 0.5, 1, 5, 10, 20, 30},
 		},
 	)
+
+	reportCollectionBackoffGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "projectsveltos",
+			Name:      "report_collection_backoff_seconds",
+			Help:      "Current backoff before collecting reports again from a managed cluster collection keeps failing from",
+		},
+		[]string{"report_kind", "cluster_type", "cluster_namespace", "cluster_name"},
+	)
+
+	clusterCircuitBreakerOpenGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "projectsveltos",
+			Name:      "cluster_circuit_breaker_open",
+			Help:      "Whether calls to a managed cluster fail fast (1) because the cluster is unreachable or not (0)",
+		},
+		[]string{"cluster_type", "cluster_namespace", "cluster_name"},
+	)
+
+	remoteClientCacheHitsCounter = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: "projectsveltos",
+			Name:      "remote_client_cache_hits_total",
+			Help:      "Number of times a cached client to a managed cluster was reused",
+		},
+	)
+
+	remoteClientCacheMissesCounter = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: "projectsveltos",
+			Name:      "remote_client_cache_misses_total",
+			Help:      "Number of times a client to a managed cluster had to be created",
+		},
+	)
+
+	remoteClientCacheSizeGauge = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: "projectsveltos",
+			Name:      "remote_client_cache_size",
+			Help:      "Number of cached clients to managed clusters",
+		},
+	)
+
+	reportCollectionLastSuccessGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "projectsveltos",
+			Name:      "report_collection_last_success_timestamp_seconds",
+			Help:      "Last time reports were successfully collected from a managed cluster",
+		},
+		[]string{"report_kind", "cluster_type", "cluster_namespace", "cluster_name"},
+	)
+
+	reportCollectionConsecutiveFailuresGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "projectsveltos",
+			Name:      "report_collection_consecutive_failures",
+			Help:      "Number of report collections from a managed cluster failed since the last successful one",
+		},
+		[]string{"report_kind", "cluster_type", "cluster_namespace", "cluster_name"},
+	)
+
+	reportCollectionAgentCompatibleGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "projectsveltos",
+			Name:      "report_collection_agent_compatible",
+			Help:      "Whether sveltos-agent running in a managed cluster is compatible (1) or not (0)",
+		},
+		[]string{"report_kind", "cluster_type", "cluster_namespace", "cluster_name"},
+	)
+
+	reportIngestionRequestsCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "projectsveltos",
+			Name:      "report_ingestion_requests_total",
+			Help:      "Number of reports posted to the report ingestion endpoint, by response code",
+		},
+		[]string{"report_kind", "code"},
+	)
+
+	healthCheckReportOrphansFoundCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "projectsveltos",
+			Name:      "healthcheckreport_gc_orphans_found_total",
+			Help:      "Number of orphaned HealthCheckReports found, by missing object (healthcheck, cluster or clusterhealthcheck)",
+		},
+		[]string{"reason"},
+	)
+
+	healthCheckReportOrphansDeletedCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "projectsveltos",
+			Name:      "healthcheckreport_gc_orphans_deleted_total",
+			Help:      "Number of orphaned HealthCheckReports deleted, by missing object (healthcheck, cluster or clusterhealthcheck)",
+		},
+		[]string{"reason"},
+	)
+
+	healthCheckReportGCLastRunGauge = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: "projectsveltos",
+			Name:      "healthcheckreport_gc_last_run_timestamp_seconds",
+			Help:      "Last time orphaned HealthCheckReports were garbage collected",
+		},
+	)
+
+	healthCheckReportAvoidedWritesCounter = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: "projectsveltos",
+			Name:      "healthcheckreport_avoided_writes_total",
+			Help:      "Number of HealthCheckReport writes avoided because HealthCheckReports were already processed",
+		},
+	)
 )
 
 //nolint:gochecknoinits // forced pattern, can't workaround
 func init() {
 	// Register custom metrics with the global prometheus registry
 	metrics.Registry.MustRegister(programClusterHealthCheckDurationHistogram)
+	metrics.Registry.MustRegister(reportCollectionBackoffGauge)
+	metrics.Registry.MustRegister(healthCheckReportAvoidedWritesCounter)
+	metrics.Registry.MustRegister(reportCollectionLastSuccessGauge)
+	metrics.Registry.MustRegister(reportCollectionConsecutiveFailuresGauge)
+	metrics.Registry.MustRegister(reportCollectionAgentCompatibleGauge)
+	metrics.Registry.MustRegister(clusterCircuitBreakerOpenGauge)
+	metrics.Registry.MustRegister(remoteClientCacheHitsCounter)
+	metrics.Registry.MustRegister(remoteClientCacheMissesCounter)
+	metrics.Registry.MustRegister(reportIngestionRequestsCounter)
+	metrics.Registry.MustRegister(healthCheckReportOrphansFoundCounter)
+	metrics.Registry.MustRegister(healthCheckReportOrphansDeletedCounter)
+	metrics.Registry.MustRegister(healthCheckReportGCLastRunGauge)
+	metrics.Registry.MustRegister(remoteClientCacheSizeGauge)
+}
+
+func setCollectionStatusMetrics(reportKind string, status *clusterCollectionStatus) {
+	labels := []string{reportKind, status.ClusterType, status.ClusterNamespace, status.ClusterName}
+
+	if status.LastSuccessfulCollection != nil {
+		reportCollectionLastSuccessGauge.WithLabelValues(labels...).Set(
+			float64(status.LastSuccessfulCollection.Unix()))
+	}
+	reportCollectionConsecutiveFailuresGauge.WithLabelValues(labels...).Set(float64(status.ConsecutiveFailures))
+	if status.AgentCompatible != nil {
+		compatible := 0.0
+		if *status.AgentCompatible {
+			compatible = 1
+		}
+		reportCollectionAgentCompatibleGauge.WithLabelValues(labels...).Set(compatible)
+	}
+}
+
+func deleteCollectionStatusMetrics(reportKind string, status *clusterCollectionStatus) {
+	labels := []string{reportKind, status.ClusterType, status.ClusterNamespace, status.ClusterName}
+
+	reportCollectionLastSuccessGauge.DeleteLabelValues(labels...)
+	reportCollectionConsecutiveFailuresGauge.DeleteLabelValues(labels...)
+	reportCollectionAgentCompatibleGauge.DeleteLabelValues(labels...)
+}
+
+func recordHealthCheckReportAvoidedWrites(avoidedWrites int) {
+	healthCheckReportAvoidedWritesCounter.Add(float64(avoidedWrites))
+}
+
+func setCollectionBackoffMetric(reportKind, clusterNamespace, clusterName string,
+	clusterType libsveltosv1beta1.ClusterType, backoff time.Duration) {
+
+	reportCollectionBackoffGauge.WithLabelValues(reportKind, string(clusterType), clusterNamespace,
+		clusterName).Set(backoff.Seconds())
+}
+
+func deleteCollectionBackoffMetric(reportKind, clusterNamespace, clusterName string,
+	clusterType libsveltosv1beta1.ClusterType) {
+
+	reportCollectionBackoffGauge.DeleteLabelValues(reportKind, string(clusterType), clusterNamespace, clusterName)
+}
+
+func setCircuitBreakerMetric(clusterNamespace, clusterName string, clusterType libsveltosv1beta1.ClusterType,
+	open bool) {
+
+	value := 0.0
+	if open {
+		value = 1
+	}
+	clusterCircuitBreakerOpenGauge.WithLabelValues(string(clusterType), clusterNamespace, clusterName).Set(value)
+}
+
+func deleteCircuitBreakerMetric(clusterNamespace, clusterName string, clusterType libsveltosv1beta1.ClusterType) {
+	clusterCircuitBreakerOpenGauge.DeleteLabelValues(string(clusterType), clusterNamespace, clusterName)
+}
+
+func recordRemoteClientCacheHit() {
+	remoteClientCacheHitsCounter.Inc()
+}
+
+func recordRemoteClientCacheMiss() {
+	remoteClientCacheMissesCounter.Inc()
+}
+
+func setRemoteClientCacheSize(size int) {
+	remoteClientCacheSizeGauge.Set(float64(size))
+}
+
+func recordReportIngestionRequest(reportKind string, code int) {
+	reportIngestionRequestsCounter.WithLabelValues(reportKind, strconv.Itoa(code)).Inc()
+}
+
+func recordOrphanedHealthCheckReport(reason string) {
+	healthCheckReportOrphansFoundCounter.WithLabelValues(reason).Inc()
+}
+
+func recordDeletedOrphanedHealthCheckReport(reason string) {
+	healthCheckReportOrphansDeletedCounter.WithLabelValues(reason).Inc()
+}
+
+func setHealthCheckReportGCLastRun(t time.Time) {
+	healthCheckReportGCLastRunGauge.Set(float64(t.Unix()))
 }
 
 func newClusterHealthCheckHistogram(clusterNamespace, clusterName string, clusterType libsveltosv1beta1.ClusterType,
@@ -23,6 +23,7 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
 
 	goteamsnotify "github.com/atc0005/go-teams-notify/v2"
 	"github.com/atc0005/go-teams-notify/v2/adaptivecard"
@@ -32,6 +33,7 @@ import (
 	webexteams "github.com/jbogarin/go-cisco-webex-teams/sdk"
 	"github.com/slack-go/slack"
 	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 
@@ -40,6 +42,11 @@ import (
 	sveltosnotifications "github.com/projectsveltos/libsveltos/lib/notifications"
 )
 
+const (
+	// reminderHeader is added to notifications re-sent while liveness checks keep failing
+	reminderHeader = "Reminder: liveness checks are still failing"
+)
+
 type slackInfo struct {
 	token     string
 	channelID string
@@ -64,30 +71,39 @@ type telegramInfo struct {
 	chatID int64
 }
 
-// sendNotification delivers notification
+// sendNotification delivers notification. If not empty, header is added at the beginning of the message
 func sendNotification(ctx context.Context, c client.Client, clusterNamespace, clusterName string,
 	clusterType libsveltosv1beta1.ClusterType, chc *libsveltosv1beta1.ClusterHealthCheck,
-	n *libsveltosv1beta1.Notification, conditions []libsveltosv1beta1.Condition, logger logr.Logger) error {
+	n *libsveltosv1beta1.Notification, conditions []libsveltosv1beta1.Condition, header string, logger logr.Logger) error {
 
 	logger = logger.WithValues("notification", fmt.Sprintf("%s:%s", n.Type, n.Name))
 	logger.V(logs.LogDebug).Info("deliver notification")
 
+	acknowledged := getAcknowledgedConditions(chc, clusterNamespace, clusterName, clusterType, conditions)
+
 	var err error
 	switch n.Type {
 	case libsveltosv1beta1.NotificationTypeKubernetesEvent:
-		sendKubernetesNotification(clusterNamespace, clusterName, clusterType, chc, conditions, logger)
+		sendKubernetesNotification(clusterNamespace, clusterName, clusterType, chc, conditions, acknowledged,
+			header, logger)
 	case libsveltosv1beta1.NotificationTypeSlack:
-		err = sendSlackNotification(ctx, c, clusterNamespace, clusterName, clusterType, n, conditions, logger)
+		err = sendSlackNotification(ctx, c, clusterNamespace, clusterName, clusterType, n, conditions, acknowledged,
+			header, logger)
 	case libsveltosv1beta1.NotificationTypeWebex:
-		err = sendWebexNotification(ctx, c, clusterNamespace, clusterName, clusterType, n, conditions, logger)
+		err = sendWebexNotification(ctx, c, clusterNamespace, clusterName, clusterType, n, conditions, acknowledged,
+			header, logger)
 	case libsveltosv1beta1.NotificationTypeDiscord:
-		err = sendDiscordNotification(ctx, c, clusterNamespace, clusterName, clusterType, n, conditions, logger)
+		err = sendDiscordNotification(ctx, c, clusterNamespace, clusterName, clusterType, n, conditions, acknowledged,
+			header, logger)
 	case libsveltosv1beta1.NotificationTypeTeams:
-		err = sendTeamsNotification(ctx, c, clusterNamespace, clusterName, clusterType, n, conditions, logger)
+		err = sendTeamsNotification(ctx, c, clusterNamespace, clusterName, clusterType, n, conditions, acknowledged,
+			header, logger)
 	case libsveltosv1beta1.NotificationTypeTelegram:
-		err = sendTelegramNotification(ctx, c, clusterNamespace, clusterName, clusterType, n, conditions, logger)
+		err = sendTelegramNotification(ctx, c, clusterNamespace, clusterName, clusterType, n, conditions, acknowledged,
+			header, logger)
 	case libsveltosv1beta1.NotificationTypeSMTP:
-		err = sendSMTPNotification(ctx, c, clusterNamespace, clusterName, clusterType, n, conditions, logger)
+		err = sendSMTPNotification(ctx, c, clusterNamespace, clusterName, clusterType, n, conditions, acknowledged,
+			header, logger)
 	default:
 		logger.V(logs.LogInfo).Info("no handler registered for notification")
 		panic(1)
@@ -103,9 +119,10 @@ func sendNotification(ctx context.Context, c client.Client, clusterNamespace, cl
 
 func sendKubernetesNotification(clusterNamespace, clusterName string,
 	clusterType libsveltosv1beta1.ClusterType, chc *libsveltosv1beta1.ClusterHealthCheck,
-	conditions []libsveltosv1beta1.Condition, logger logr.Logger) {
+	conditions []libsveltosv1beta1.Condition, acknowledged map[string]string, header string, logger logr.Logger) {
 
-	message, passing := getNotificationMessage(clusterNamespace, clusterName, clusterType, conditions, logger)
+	message, passing := getNotificationMessage(clusterNamespace, clusterName, clusterType, conditions, acknowledged,
+		header, logger)
 
 	eventType := corev1.EventTypeNormal
 	if !passing {
@@ -120,7 +137,7 @@ func sendKubernetesNotification(clusterNamespace, clusterName string,
 
 func sendSlackNotification(ctx context.Context, c client.Client, clusterNamespace, clusterName string,
 	clusterType libsveltosv1beta1.ClusterType, n *libsveltosv1beta1.Notification, conditions []libsveltosv1beta1.Condition,
-	logger logr.Logger) error {
+	acknowledged map[string]string, header string, logger logr.Logger) error {
 
 	info, err := getSlackInfo(ctx, c, n)
 	if err != nil {
@@ -130,7 +147,8 @@ func sendSlackNotification(ctx context.Context, c client.Client, clusterNamespac
 	l := logger.WithValues("channel", info.channelID)
 	l.V(logs.LogInfo).Info("send slack message")
 
-	message, passing := getNotificationMessage(clusterNamespace, clusterName, clusterType, conditions, logger)
+	message, passing := getNotificationMessage(clusterNamespace, clusterName, clusterType, conditions, acknowledged,
+		header, logger)
 
 	msgSlack, err := composeSlackMessage(message, passing)
 	if err != nil {
@@ -157,14 +175,15 @@ func sendSlackNotification(ctx context.Context, c client.Client, clusterNamespac
 
 func sendWebexNotification(ctx context.Context, c client.Client, clusterNamespace, clusterName string,
 	clusterType libsveltosv1beta1.ClusterType, n *libsveltosv1beta1.Notification, conditions []libsveltosv1beta1.Condition,
-	logger logr.Logger) error {
+	acknowledged map[string]string, header string, logger logr.Logger) error {
 
 	info, err := getWebexInfo(ctx, c, n)
 	if err != nil {
 		return err
 	}
 
-	message, passing := getNotificationMessage(clusterNamespace, clusterName, clusterType, conditions, logger)
+	message, passing := getNotificationMessage(clusterNamespace, clusterName, clusterType, conditions, acknowledged,
+		header, logger)
 
 	formattedMessage, err := composeWebexMessage(message, passing, logger)
 	if err != nil {
@@ -208,7 +227,7 @@ func sendWebexNotification(ctx context.Context, c client.Client, clusterNamespac
 
 func sendDiscordNotification(ctx context.Context, c client.Client, clusterNamespace, clusterName string,
 	clusterType libsveltosv1beta1.ClusterType, n *libsveltosv1beta1.Notification, conditions []libsveltosv1beta1.Condition,
-	logger logr.Logger) error {
+	acknowledged map[string]string, header string, logger logr.Logger) error {
 
 	info, err := getDiscordInfo(ctx, c, n)
 	if err != nil {
@@ -218,7 +237,8 @@ func sendDiscordNotification(ctx context.Context, c client.Client, clusterNamesp
 	l := logger.WithValues("channel", info.channelID)
 	l.V(logs.LogInfo).Info("send discord message")
 
-	message, passing := getNotificationMessage(clusterNamespace, clusterName, clusterType, conditions, logger)
+	message, passing := getNotificationMessage(clusterNamespace, clusterName, clusterType, conditions, acknowledged,
+		header, logger)
 
 	// Format Message
 	discordReply, err := composeDiscordMessage(message, passing)
@@ -245,7 +265,7 @@ func sendDiscordNotification(ctx context.Context, c client.Client, clusterNamesp
 
 func sendTeamsNotification(ctx context.Context, c client.Client, clusterNamespace, clusterName string,
 	clusterType libsveltosv1beta1.ClusterType, n *libsveltosv1beta1.Notification, conditions []libsveltosv1beta1.Condition,
-	logger logr.Logger) error {
+	acknowledged map[string]string, header string, logger logr.Logger) error {
 
 	info, err := getTeamsInfo(ctx, c, n)
 	if err != nil {
@@ -255,7 +275,8 @@ func sendTeamsNotification(ctx context.Context, c client.Client, clusterNamespac
 	l := logger.WithValues("webhookUrl", info.webhookUrl)
 	l.V(logs.LogInfo).Info("send teams message")
 
-	message, passing := getNotificationMessage(clusterNamespace, clusterName, clusterType, conditions, logger)
+	message, passing := getNotificationMessage(clusterNamespace, clusterName, clusterType, conditions, acknowledged,
+		header, logger)
 
 	// Format message using adaptive cards
 	card, err := composeTeamsMessage(message, passing, logger)
@@ -296,7 +317,7 @@ func sendTeamsNotification(ctx context.Context, c client.Client, clusterNamespac
 
 func sendTelegramNotification(ctx context.Context, c client.Client, clusterNamespace, clusterName string,
 	clusterType libsveltosv1beta1.ClusterType, n *libsveltosv1beta1.Notification, conditions []libsveltosv1beta1.Condition,
-	logger logr.Logger) error {
+	acknowledged map[string]string, header string, logger logr.Logger) error {
 
 	info, err := getTelegramInfo(ctx, c, n)
 	if err != nil {
@@ -306,7 +327,8 @@ func sendTelegramNotification(ctx context.Context, c client.Client, clusterNames
 	l := logger.WithValues("chatid", info.chatID)
 	l.V(logs.LogInfo).Info("send telegram message")
 
-	message, _ := getNotificationMessage(clusterNamespace, clusterName, clusterType, conditions, logger)
+	message, _ := getNotificationMessage(clusterNamespace, clusterName, clusterType, conditions, acknowledged,
+		header, logger)
 
 	bot, err := tgbotapi.NewBotAPI(info.token)
 	if err != nil {
@@ -324,7 +346,7 @@ func sendTelegramNotification(ctx context.Context, c client.Client, clusterNames
 
 func sendSMTPNotification(ctx context.Context, c client.Client, clusterNamespace, clusterName string,
 	clusterType libsveltosv1beta1.ClusterType, n *libsveltosv1beta1.Notification, conditions []libsveltosv1beta1.Condition,
-	logger logr.Logger) error {
+	acknowledged map[string]string, header string, logger logr.Logger) error {
 
 	if n.NotificationRef == nil {
 		return fmt.Errorf("notificationRef is not set")
@@ -338,23 +360,50 @@ func sendSMTPNotification(ctx context.Context, c client.Client, clusterNamespace
 	l := logger.WithValues("notification", n.Name)
 	l.V(logs.LogInfo).Info("send smtp message")
 
-	message, _ := getNotificationMessage(clusterNamespace, clusterName, clusterType, conditions, logger)
+	message, _ := getNotificationMessage(clusterNamespace, clusterName, clusterType, conditions, acknowledged,
+		header, logger)
 
 	return mailer.SendMail("Sveltos Notification", message, false, nil)
 }
 
 func getNotificationMessage(clusterNamespace, clusterName string, clusterType libsveltosv1beta1.ClusterType,
-	conditions []libsveltosv1beta1.Condition, logger logr.Logger) (string, bool) {
+	conditions []libsveltosv1beta1.Condition, acknowledged map[string]string, header string, logger logr.Logger,
+) (string, bool) {
 
 	passing := true
 	message := fmt.Sprintf("Cluster %s:%s/%s  \n", clusterType, clusterNamespace, clusterName)
+	if clusterType == aggregateClusterType {
+		message = fmt.Sprintf("Clusters matching ClusterHealthCheck %s  \n", clusterName)
+	}
+	if header != "" {
+		message = fmt.Sprintf("%s  \n", header) + message
+	}
 	for i := range conditions {
 		c := &conditions[i]
-		if c.Status != corev1.ConditionTrue {
+		switch c.Reason {
+		case FlappingReason:
 			passing = false
-			message += fmt.Sprintf("Liveness check %q failing  \n", c.Type)
-			message += fmt.Sprintf("%s  \n", c.Message)
+			message += fmt.Sprintf("Liveness check %q flapping  \n", c.Type)
+		case FlappingStoppedReason:
+			message += fmt.Sprintf("Liveness check %q stopped flapping  \n", c.Type)
+		}
+		if c.Status == corev1.ConditionTrue {
+			continue
+		}
+		passing = false
+		var duration string
+		if d := formatConditionDuration(c); d != "" {
+			duration = " for " + d
 		}
+		if user, ok := acknowledged[c.Name]; ok {
+			duration += fmt.Sprintf(" (acknowledged by %s)", user)
+		}
+		if c.Status == corev1.ConditionUnknown {
+			message += fmt.Sprintf("Liveness check %q status unknown%s  \n", c.Type, duration)
+		} else {
+			message += fmt.Sprintf("Liveness check %q failing%s  \n", c.Type, duration)
+		}
+		message += fmt.Sprintf("%s  \n", c.Message)
 	}
 
 	if passing {
@@ -702,3 +751,38 @@ func composeSlackMessage(message string, passing bool) (slack.Attachment, error)
 	attachment.Text = markdownText.String()
 	return attachment, nil
 }
+
+// needsNotificationState returns true if any notification sends reminders
+func needsNotificationState(options *clusterHealthCheckOptions) bool {
+	for _, nOptions := range options.Notifications {
+		if nOptions.ReminderInterval != nil {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isReminderDue returns true if a reminder needs to be sent: reminders are enabled, at least one of
+// the liveness checks the notification reports on is failing (and not acknowledged) and reminderInterval
+// has passed since the notification was last delivered.
+func isReminderDue(reminderInterval *metav1.Duration, state notificationState,
+	conditions []libsveltosv1beta1.Condition, acknowledged map[string]string) bool {
+
+	if reminderInterval == nil {
+		return false
+	}
+
+	failing := false
+	for i := range conditions {
+		if _, ok := acknowledged[conditions[i].Name]; !ok && conditions[i].Status == corev1.ConditionFalse {
+			failing = true
+			break
+		}
+	}
+	if !failing {
+		return false
+	}
+
+	return time.Since(state.LastSent.Time) >= reminderInterval.Duration
+}
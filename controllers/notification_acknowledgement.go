@@ -0,0 +1,236 @@
+/*
+Copyright 2023. projectsveltos.io. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/yaml"
+
+	libsveltosv1beta1 "github.com/projectsveltos/libsveltos/api/v1beta1"
+)
+
+const (
+	// ClusterHealthCheckAcknowledgementsAnnotation can be set on a ClusterHealthCheck to acknowledge
+	// failing liveness checks. Its value is a YAML (or JSON) list of acknowledgement.
+	// Acknowledged liveness checks are neither reminded nor escalated. An acknowledgement is removed
+	// when the liveness check recovers or its message changes. Acknowledgements are only kept here:
+	// liveness check conditions are not modified.
+	ClusterHealthCheckAcknowledgementsAnnotation = "healthcheck.projectsveltos.io/acknowledgements"
+)
+
+// acknowledgement marks a failing liveness check (or composite check) in a cluster as acknowledged
+type acknowledgement struct {
+	// ClusterNamespace is the namespace of the cluster
+	ClusterNamespace string `json:"clusterNamespace"`
+
+	// ClusterName is the name of the cluster
+	ClusterName string `json:"clusterName"`
+
+	// ClusterType is the type of the cluster
+	ClusterType libsveltosv1beta1.ClusterType `json:"clusterType"`
+
+	// LivenessCheck is the name of the acknowledged liveness check or composite check
+	LivenessCheck string `json:"livenessCheck"`
+
+	// User is who acknowledged the failure
+	User string `json:"user"`
+
+	// Message is the liveness check message when the acknowledgement was first processed.
+	// Set by healthcheck-manager.
+	Message string `json:"message,omitempty"`
+}
+
+// getAcknowledgements parses the ClusterHealthCheckAcknowledgementsAnnotation.
+// Returns nil if the annotation is not set.
+func getAcknowledgements(chc *libsveltosv1beta1.ClusterHealthCheck) ([]acknowledgement, error) {
+	value, ok := chc.GetAnnotations()[ClusterHealthCheckAcknowledgementsAnnotation]
+	if !ok || value == "" {
+		return nil, nil
+	}
+
+	var acks []acknowledgement
+	if err := yaml.UnmarshalStrict([]byte(value), &acks); err != nil {
+		return nil, fmt.Errorf("failed to parse annotation %s: %w", ClusterHealthCheckAcknowledgementsAnnotation, err)
+	}
+
+	return acks, nil
+}
+
+// isAcknowledgementForCluster returns true if acknowledgement is for the cluster
+func isAcknowledgementForCluster(ack *acknowledgement, clusterNamespace, clusterName string,
+	clusterType libsveltosv1beta1.ClusterType) bool {
+
+	return ack.ClusterNamespace == clusterNamespace && ack.ClusterName == clusterName &&
+		ack.ClusterType == clusterType
+}
+
+// getClusterAcknowledgementsHash returns a string representing acknowledgements for a cluster.
+// Message is set by healthcheck-manager itself, so it is not considered.
+func getClusterAcknowledgementsHash(chc *libsveltosv1beta1.ClusterHealthCheck, clusterNamespace, clusterName string,
+	clusterType libsveltosv1beta1.ClusterType) string {
+
+	acks, err := getAcknowledgements(chc)
+	if err != nil {
+		return ""
+	}
+
+	var config string
+	for i := range acks {
+		if isAcknowledgementForCluster(&acks[i], clusterNamespace, clusterName, clusterType) {
+			config += fmt.Sprintf("%s:%s;", acks[i].LivenessCheck, acks[i].User)
+		}
+	}
+
+	return config
+}
+
+// isAcknowledgementValid returns true if acknowledgement still applies to condition: liveness check is
+// failing with the same message it had when acknowledgement was first processed (if ever)
+func isAcknowledgementValid(ack *acknowledgement, condition *libsveltosv1beta1.Condition) bool {
+	return condition.Status != corev1.ConditionTrue && (ack.Message == "" || ack.Message == condition.Message)
+}
+
+// applyAcknowledgements returns the acknowledgements still valid: acknowledgements for liveness checks
+// which recovered or whose message changed since the acknowledgement was first processed are dropped,
+// while newly processed ones record the current message. changed is true if acknowledgements were modified.
+func applyAcknowledgements(acks []acknowledgement, clusterNamespace, clusterName string,
+	clusterType libsveltosv1beta1.ClusterType, conditions []libsveltosv1beta1.Condition,
+) (result []acknowledgement, changed bool) {
+
+	result = make([]acknowledgement, 0, len(acks))
+	for i := range acks {
+		ack := acks[i]
+		if !isAcknowledgementForCluster(&ack, clusterNamespace, clusterName, clusterType) {
+			result = append(result, ack)
+			continue
+		}
+
+		condition := getConditionByName(conditions, ack.LivenessCheck)
+		if condition == nil {
+			// Liveness check is not evaluated (yet). Keep the acknowledgement.
+			result = append(result, ack)
+			continue
+		}
+
+		if !isAcknowledgementValid(&ack, condition) {
+			// Liveness check recovered or failure is different from the acknowledged one
+			changed = true
+			continue
+		}
+
+		if ack.Message == "" {
+			ack.Message = condition.Message
+			changed = true
+		}
+		result = append(result, ack)
+	}
+
+	return result, changed
+}
+
+// processAcknowledgements updates the ClusterHealthCheck acknowledgements if any was first processed
+// or cleared.
+func processAcknowledgements(ctx context.Context, c client.Client, chc *libsveltosv1beta1.ClusterHealthCheck,
+	clusterNamespace, clusterName string, clusterType libsveltosv1beta1.ClusterType,
+	conditions []libsveltosv1beta1.Condition) error {
+
+	acks, err := getAcknowledgements(chc)
+	if err != nil || acks == nil {
+		return err
+	}
+
+	if _, changed := applyAcknowledgements(acks, clusterNamespace, clusterName, clusterType,
+		conditions); !changed {
+		return nil
+	}
+
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		currentChc := &libsveltosv1beta1.ClusterHealthCheck{}
+		if err := c.Get(ctx, types.NamespacedName{Name: chc.Name}, currentChc); err != nil {
+			return err
+		}
+
+		currentAcks, err := getAcknowledgements(currentChc)
+		if err != nil {
+			return err
+		}
+
+		currentAcks, changed := applyAcknowledgements(currentAcks, clusterNamespace, clusterName, clusterType,
+			conditions)
+		if !changed {
+			return nil
+		}
+
+		// A null value removes the annotation
+		var value *string
+		if len(currentAcks) != 0 {
+			data, err := yaml.Marshal(currentAcks)
+			if err != nil {
+				return err
+			}
+			value = ptr.To(string(data))
+		}
+
+		// Only the annotation is patched. ResourceVersion makes the patch fail on conflict, in which
+		// case acknowledgements are processed again.
+		patch, err := json.Marshal(map[string]interface{}{
+			"metadata": map[string]interface{}{
+				"resourceVersion": currentChc.ResourceVersion,
+				"annotations": map[string]*string{
+					ClusterHealthCheckAcknowledgementsAnnotation: value,
+				},
+			},
+		})
+		if err != nil {
+			return err
+		}
+
+		return c.Patch(ctx, currentChc, client.RawPatch(types.MergePatchType, patch))
+	})
+}
+
+// getAcknowledgedConditions returns, for the conditions of a cluster which are acknowledged, who
+// acknowledged them. Key is the condition name.
+func getAcknowledgedConditions(chc *libsveltosv1beta1.ClusterHealthCheck, clusterNamespace, clusterName string,
+	clusterType libsveltosv1beta1.ClusterType, conditions []libsveltosv1beta1.Condition) map[string]string {
+
+	acks, err := getAcknowledgements(chc)
+	if err != nil {
+		return nil
+	}
+
+	acknowledged := make(map[string]string)
+	for i := range acks {
+		if !isAcknowledgementForCluster(&acks[i], clusterNamespace, clusterName, clusterType) {
+			continue
+		}
+		condition := getConditionByName(conditions, acks[i].LivenessCheck)
+		if condition != nil && isAcknowledgementValid(&acks[i], condition) {
+			acknowledged[condition.Name] = acks[i].User
+		}
+	}
+
+	return acknowledged
+}
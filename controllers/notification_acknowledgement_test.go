@@ -0,0 +1,206 @@
+/*
+Copyright 2023. projectsveltos.io. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers_test
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/projectsveltos/healthcheck-manager/controllers"
+	libsveltosv1beta1 "github.com/projectsveltos/libsveltos/api/v1beta1"
+)
+
+var _ = Describe("Acknowledgements", func() {
+	var clusterNamespace string
+	var clusterName string
+	var livenessCheckName string
+	var conditions []libsveltosv1beta1.Condition
+
+	BeforeEach(func() {
+		clusterNamespace = randomString()
+		clusterName = randomString()
+		livenessCheckName = randomString()
+		conditions = []libsveltosv1beta1.Condition{
+			{
+				Name:    livenessCheckName,
+				Type:    libsveltosv1beta1.ConditionType("Addons:" + livenessCheckName),
+				Status:  corev1.ConditionFalse,
+				Message: randomString(),
+			},
+		}
+	})
+
+	getChc := func(acks string) *libsveltosv1beta1.ClusterHealthCheck {
+		return &libsveltosv1beta1.ClusterHealthCheck{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: randomString(),
+				Annotations: map[string]string{
+					controllers.ClusterHealthCheckAcknowledgementsAnnotation: acks,
+				},
+			},
+		}
+	}
+
+	It("applyAcknowledgements records acknowledged messages and clears acknowledgements", func() {
+		acks := []controllers.Acknowledgement{
+			{
+				ClusterNamespace: clusterNamespace,
+				ClusterName:      clusterName,
+				ClusterType:      libsveltosv1beta1.ClusterTypeCapi,
+				LivenessCheck:    livenessCheckName,
+				User:             randomString(),
+			},
+			{
+				// Different cluster
+				ClusterNamespace: randomString(),
+				ClusterName:      clusterName,
+				ClusterType:      libsveltosv1beta1.ClusterTypeCapi,
+				LivenessCheck:    livenessCheckName,
+				User:             randomString(),
+			},
+		}
+
+		// First processed: message is recorded. Condition is not modified.
+		message := conditions[0].Message
+		result, changed := controllers.ApplyAcknowledgements(acks, clusterNamespace, clusterName,
+			libsveltosv1beta1.ClusterTypeCapi, conditions)
+		Expect(changed).To(BeTrue())
+		Expect(result).To(HaveLen(2))
+		Expect(result[0].Message).To(Equal(message))
+		Expect(conditions[0].Message).To(Equal(message))
+
+		// Nothing changed
+		result, changed = controllers.ApplyAcknowledgements(result, clusterNamespace, clusterName,
+			libsveltosv1beta1.ClusterTypeCapi, conditions)
+		Expect(changed).To(BeFalse())
+
+		// Message changed: acknowledgement is cleared
+		conditions[0].Message = randomString()
+		cleared, changed := controllers.ApplyAcknowledgements(result, clusterNamespace, clusterName,
+			libsveltosv1beta1.ClusterTypeCapi, conditions)
+		Expect(changed).To(BeTrue())
+		Expect(cleared).To(HaveLen(1))
+
+		// Recovered: acknowledgement is cleared
+		conditions[0].Status = corev1.ConditionTrue
+		cleared, changed = controllers.ApplyAcknowledgements(acks, clusterNamespace, clusterName,
+			libsveltosv1beta1.ClusterTypeCapi, conditions)
+		Expect(changed).To(BeTrue())
+		Expect(cleared).To(HaveLen(1))
+	})
+
+	It("getAcknowledgedConditions returns acknowledged failing conditions of the cluster", func() {
+		user := randomString()
+		ack := fmt.Sprintf("- clusterNamespace: %s\n  clusterName: %s\n  clusterType: %s\n  livenessCheck: %s\n  user: %s\n",
+			clusterNamespace, clusterName, libsveltosv1beta1.ClusterTypeSveltos, livenessCheckName, user)
+
+		// Not processed yet
+		chc := getChc(ack)
+		Expect(controllers.GetAcknowledgedConditions(chc, clusterNamespace, clusterName,
+			libsveltosv1beta1.ClusterTypeSveltos, conditions)).To(Equal(map[string]string{livenessCheckName: user}))
+
+		// Other cluster
+		Expect(controllers.GetAcknowledgedConditions(chc, clusterNamespace, randomString(),
+			libsveltosv1beta1.ClusterTypeSveltos, conditions)).To(BeEmpty())
+
+		// A message looking like an acknowledgement is not one
+		conditions[0].Message = "Acknowledged by " + randomString()
+		Expect(controllers.GetAcknowledgedConditions(getChc(""), clusterNamespace, clusterName,
+			libsveltosv1beta1.ClusterTypeSveltos, conditions)).To(BeEmpty())
+
+		// Processed for a different message
+		chc = getChc(ack + "  message: " + randomString() + "\n")
+		Expect(controllers.GetAcknowledgedConditions(chc, clusterNamespace, clusterName,
+			libsveltosv1beta1.ClusterTypeSveltos, conditions)).To(BeEmpty())
+
+		// Processed for current message
+		chc = getChc(ack + fmt.Sprintf("  message: %q\n", conditions[0].Message))
+		Expect(controllers.GetAcknowledgedConditions(chc, clusterNamespace, clusterName,
+			libsveltosv1beta1.ClusterTypeSveltos, conditions)).To(HaveKey(livenessCheckName))
+
+		// Recovered
+		conditions[0].Status = corev1.ConditionTrue
+		Expect(controllers.GetAcknowledgedConditions(chc, clusterNamespace, clusterName,
+			libsveltosv1beta1.ClusterTypeSveltos, conditions)).To(BeEmpty())
+	})
+
+	It("processAcknowledgements updates ClusterHealthCheck acknowledgements", func() {
+		chc := getChc(fmt.Sprintf(
+			"- clusterNamespace: %s\n  clusterName: %s\n  clusterType: %s\n  livenessCheck: %s\n  user: %s\n",
+			clusterNamespace, clusterName, libsveltosv1beta1.ClusterTypeCapi, livenessCheckName, randomString()))
+
+		c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(chc).Build()
+
+		message := conditions[0].Message
+		Expect(controllers.ProcessAcknowledgements(context.TODO(), c, chc, clusterNamespace, clusterName,
+			libsveltosv1beta1.ClusterTypeCapi, conditions)).To(Succeed())
+		Expect(conditions[0].Message).To(Equal(message))
+
+		currentChc := &libsveltosv1beta1.ClusterHealthCheck{}
+		Expect(c.Get(context.TODO(), types.NamespacedName{Name: chc.Name}, currentChc)).To(Succeed())
+		acks, err := controllers.GetAcknowledgements(currentChc)
+		Expect(err).To(BeNil())
+		Expect(acks).To(HaveLen(1))
+		Expect(acks[0].Message).To(Equal(message))
+		// Only the annotation was patched
+		Expect(currentChc.Generation).To(Equal(chc.Generation))
+		Expect(controllers.GetAcknowledgedConditions(currentChc, clusterNamespace, clusterName,
+			libsveltosv1beta1.ClusterTypeCapi, conditions)).To(HaveKey(livenessCheckName))
+
+		// Liveness check recovers: annotation is removed
+		conditions[0].Status = corev1.ConditionTrue
+		conditions[0].Message = ""
+		Expect(controllers.ProcessAcknowledgements(context.TODO(), c, currentChc, clusterNamespace, clusterName,
+			libsveltosv1beta1.ClusterTypeCapi, conditions)).To(Succeed())
+		Expect(c.Get(context.TODO(), types.NamespacedName{Name: chc.Name}, currentChc)).To(Succeed())
+		Expect(currentChc.Annotations).ToNot(HaveKey(controllers.ClusterHealthCheckAcknowledgementsAnnotation))
+	})
+
+	It("acknowledged conditions are not escalated and keep their flapping reason", func() {
+		conditions[0].Reason = controllers.FlappingReason
+		conditions[0].LastTransitionTime = metav1.Time{Time: time.Now().Add(-time.Hour)}
+
+		chc := getChc(fmt.Sprintf(
+			"- clusterNamespace: %s\n  clusterName: %s\n  clusterType: %s\n  livenessCheck: %s\n  user: %s\n",
+			clusterNamespace, clusterName, libsveltosv1beta1.ClusterTypeSveltos, livenessCheckName, randomString()))
+		chc.Annotations[controllers.ClusterHealthCheckOptionsAnnotation] =
+			"escalations:\n- after: 30m\n  notifications: [pagerduty]\n"
+		options, err := controllers.GetClusterHealthCheckOptions(chc)
+		Expect(err).To(BeNil())
+
+		acknowledged := controllers.GetAcknowledgedConditions(chc, clusterNamespace, clusterName,
+			libsveltosv1beta1.ClusterTypeSveltos, conditions)
+		Expect(acknowledged).To(HaveKey(livenessCheckName))
+
+		escalations, _ := controllers.GetEscalations(options, controllers.ClusterEscalationStates{}, conditions,
+			acknowledged)
+		Expect(escalations).To(BeEmpty())
+		Expect(conditions[0].Reason).To(Equal(controllers.FlappingReason))
+
+		escalations, _ = controllers.GetEscalations(options, controllers.ClusterEscalationStates{}, conditions, nil)
+		Expect(escalations).To(HaveKey("pagerduty"))
+	})
+})
@@ -0,0 +1,167 @@
+/*
+Copyright 2023. projectsveltos.io. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	libsveltosv1beta1 "github.com/projectsveltos/libsveltos/api/v1beta1"
+)
+
+const (
+	// escalationHeader is added to notifications sent because a liveness check reached an escalation tier
+	escalationHeader = "Escalation: liveness checks failing for too long"
+
+	// escalationRecoveryHeader is added to notifications sent to escalation targets because liveness checks
+	// they were notified about recovered
+	escalationRecoveryHeader = "Escalation resolved: liveness checks recovered"
+)
+
+// escalationState contains the escalation progress of a failing liveness check in a cluster
+type escalationState struct {
+	// Tier is the number of escalation tiers already notified
+	Tier int `json:"tier,omitempty"`
+}
+
+// clusterEscalationStates contains the escalation state of all failing liveness checks in a cluster.
+// Key is the liveness check condition type.
+type clusterEscalationStates map[string]escalationState
+
+// getEscalationStates returns the escalation state of all liveness checks of a ClusterHealthCheck in
+// a cluster. Empty if none was stored yet.
+func getEscalationStates(ctx context.Context, c client.Client, chcName, clusterNamespace, clusterName string,
+	clusterType libsveltosv1beta1.ClusterType) (clusterEscalationStates, error) {
+
+	states := clusterEscalationStates{}
+	err := getStateValue(ctx, c, getClusterStateConfigMapName(chcName, clusterNamespace, clusterName, clusterType),
+		escalationStateKey, &states)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse escalation state: %w", err)
+	}
+
+	return states, nil
+}
+
+// updateEscalationStates stores the escalation state of all liveness checks of a ClusterHealthCheck
+// in a cluster.
+func updateEscalationStates(ctx context.Context, c client.Client, chc *libsveltosv1beta1.ClusterHealthCheck,
+	clusterNamespace, clusterName string, clusterType libsveltosv1beta1.ClusterType,
+	states clusterEscalationStates) error {
+
+	return updateStateValue(ctx, c, chc,
+		getClusterStateConfigMapName(chc.Name, clusterNamespace, clusterName, clusterType), escalationStateKey, states)
+}
+
+// needsEscalationState returns true if escalation tiers are defined
+func needsEscalationState(options *clusterHealthCheckOptions) bool {
+	return len(options.Escalations) > 0
+}
+
+// isEscalationNotification returns true if the notification is listed in an escalation tier.
+// Such notifications are only delivered when a liveness check reaches their tier and, later, when
+// that liveness check recovers.
+func isEscalationNotification(options *clusterHealthCheckOptions, notificationName string) bool {
+	for i := range options.Escalations {
+		for _, name := range options.Escalations[i].Notifications {
+			if name == notificationName {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// getEscalations returns, per escalation notification name:
+// - escalations: the failing conditions which reached, since last evaluation, an escalation tier listing
+// that notification;
+// - recoveries: the conditions which recovered after reaching an escalation tier listing that notification.
+// states is updated: conditions not failing are reset, others record the tiers reached.
+// Acknowledged conditions are not escalated further.
+func getEscalations(options *clusterHealthCheckOptions, states clusterEscalationStates,
+	conditions []libsveltosv1beta1.Condition, acknowledged map[string]string,
+) (escalations, recoveries map[string][]libsveltosv1beta1.Condition) {
+
+	escalations = make(map[string][]libsveltosv1beta1.Condition)
+	recoveries = make(map[string][]libsveltosv1beta1.Condition)
+
+	for i := range conditions {
+		condition := &conditions[i]
+		key := string(condition.Type)
+		if condition.Status != corev1.ConditionFalse {
+			// Escalation targets already notified are notified of the recovery. Escalation starts over.
+			if state, ok := states[key]; ok {
+				addEscalationTargets(options, 0, state.Tier, condition, recoveries)
+				delete(states, key)
+			}
+			continue
+		}
+		if _, ok := acknowledged[condition.Name]; ok {
+			continue
+		}
+
+		failingFor := time.Since(condition.LastTransitionTime.Time)
+		previous := states[key].Tier
+		reached := previous
+		for reached < len(options.Escalations) && options.Escalations[reached].After.Duration <= failingFor {
+			reached++
+		}
+		if reached == previous {
+			continue
+		}
+
+		addEscalationTargets(options, previous, reached, condition, escalations)
+		states[key] = escalationState{Tier: reached}
+	}
+
+	return escalations, recoveries
+}
+
+// addEscalationTargets adds condition, once per notification, to all notifications listed in escalation
+// tiers [from, to)
+func addEscalationTargets(options *clusterHealthCheckOptions, from, to int, condition *libsveltosv1beta1.Condition,
+	targets map[string][]libsveltosv1beta1.Condition) {
+
+	notified := make(map[string]bool)
+	for tier := from; tier < to && tier < len(options.Escalations); tier++ {
+		for _, name := range options.Escalations[tier].Notifications {
+			if notified[name] {
+				continue
+			}
+			notified[name] = true
+			targets[name] = append(targets[name], *condition)
+		}
+	}
+}
+
+// resetEscalations restores the escalation state, for conditions, to previous. Used when delivering an
+// escalation (or a recovery) failed, so it is attempted again at next evaluation.
+func resetEscalations(previous, states clusterEscalationStates, conditions []libsveltosv1beta1.Condition) {
+	for i := range conditions {
+		key := string(conditions[i].Type)
+		if state, ok := previous[key]; ok {
+			states[key] = state
+		} else {
+			delete(states, key)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+/*
+Copyright 2023. projectsveltos.io. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers_test
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/projectsveltos/healthcheck-manager/controllers"
+	libsveltosv1beta1 "github.com/projectsveltos/libsveltos/api/v1beta1"
+)
+
+var _ = Describe("Notification escalation", func() {
+	var chc *libsveltosv1beta1.ClusterHealthCheck
+
+	BeforeEach(func() {
+		chc = &libsveltosv1beta1.ClusterHealthCheck{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: randomString(),
+				Annotations: map[string]string{
+					controllers.ClusterHealthCheckOptionsAnnotation: "escalations:\n" +
+						"- after: 30m\n  notifications: [pagerduty]\n" +
+						"- after: 4h\n  notifications: [email]\n",
+				},
+			},
+		}
+	})
+
+	It("isEscalationNotification returns true only for notifications listed in a tier", func() {
+		options, err := controllers.GetClusterHealthCheckOptions(chc)
+		Expect(err).To(BeNil())
+
+		Expect(controllers.IsEscalationNotification(options, "pagerduty")).To(BeTrue())
+		Expect(controllers.IsEscalationNotification(options, "email")).To(BeTrue())
+		Expect(controllers.IsEscalationNotification(options, "slack")).To(BeFalse())
+	})
+
+	It("getEscalations escalates failing liveness checks once per tier and notifies recovery", func() {
+		options, err := controllers.GetClusterHealthCheckOptions(chc)
+		Expect(err).To(BeNil())
+
+		conditions := []libsveltosv1beta1.Condition{
+			{
+				Type:               libsveltosv1beta1.ConditionType("Addons:" + randomString()),
+				Status:             corev1.ConditionFalse,
+				LastTransitionTime: metav1.Time{Time: time.Now().Add(-time.Minute)},
+			},
+		}
+		key := string(conditions[0].Type)
+
+		states := controllers.ClusterEscalationStates{}
+		// Failing for less than first tier
+		escalations, recoveries := controllers.GetEscalations(options, states, conditions, nil)
+		Expect(escalations).To(BeEmpty())
+		Expect(recoveries).To(BeEmpty())
+		Expect(states).To(BeEmpty())
+
+		// First tier reached
+		conditions[0].LastTransitionTime = metav1.Time{Time: time.Now().Add(-time.Hour)}
+		escalations, _ = controllers.GetEscalations(options, states, conditions, nil)
+		Expect(escalations).To(HaveLen(1))
+		Expect(escalations["pagerduty"]).To(HaveLen(1))
+		Expect(states[key].Tier).To(Equal(1))
+
+		// First tier already notified
+		escalations, _ = controllers.GetEscalations(options, states, conditions, nil)
+		Expect(escalations).To(BeEmpty())
+
+		// Second tier reached
+		conditions[0].LastTransitionTime = metav1.Time{Time: time.Now().Add(-5 * time.Hour)}
+		escalations, _ = controllers.GetEscalations(options, states, conditions, nil)
+		Expect(escalations).To(HaveLen(1))
+		Expect(escalations["email"]).To(HaveLen(1))
+		Expect(states[key].Tier).To(Equal(2))
+
+		// Recovery is sent to all escalation targets notified and resets escalation
+		conditions[0].Status = corev1.ConditionTrue
+		escalations, recoveries = controllers.GetEscalations(options, states, conditions, nil)
+		Expect(escalations).To(BeEmpty())
+		Expect(recoveries).To(HaveLen(2))
+		Expect(recoveries["pagerduty"]).To(HaveLen(1))
+		Expect(recoveries["email"]).To(HaveLen(1))
+		Expect(states).To(BeEmpty())
+
+		// Recovery is notified once
+		_, recoveries = controllers.GetEscalations(options, states, conditions, nil)
+		Expect(recoveries).To(BeEmpty())
+
+		// Failing again for long reaches all tiers at once
+		conditions[0].Status = corev1.ConditionFalse
+		escalations, _ = controllers.GetEscalations(options, states, conditions, nil)
+		Expect(escalations).To(HaveLen(2))
+		Expect(states[key].Tier).To(Equal(2))
+	})
+
+	It("getEscalations notifies recovery only to escalation targets already notified", func() {
+		options, err := controllers.GetClusterHealthCheckOptions(chc)
+		Expect(err).To(BeNil())
+
+		conditions := []libsveltosv1beta1.Condition{
+			{
+				Type:               libsveltosv1beta1.ConditionType("Addons:" + randomString()),
+				Status:             corev1.ConditionFalse,
+				LastTransitionTime: metav1.Time{Time: time.Now().Add(-time.Hour)},
+			},
+			{
+				Type:               libsveltosv1beta1.ConditionType("Addons:" + randomString()),
+				Status:             corev1.ConditionFalse,
+				LastTransitionTime: metav1.Time{Time: time.Now().Add(-time.Minute)},
+			},
+		}
+
+		states := controllers.ClusterEscalationStates{}
+		escalations, _ := controllers.GetEscalations(options, states, conditions, nil)
+		Expect(escalations).To(HaveLen(1))
+		Expect(escalations["pagerduty"]).To(HaveLen(1))
+
+		conditions[0].Status = corev1.ConditionTrue
+		conditions[1].Status = corev1.ConditionTrue
+		_, recoveries := controllers.GetEscalations(options, states, conditions, nil)
+		Expect(recoveries).To(HaveLen(1))
+		Expect(recoveries["pagerduty"]).To(HaveLen(1))
+		Expect(recoveries["pagerduty"][0].Type).To(Equal(conditions[0].Type))
+	})
+})
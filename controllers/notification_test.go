@@ -18,11 +18,13 @@ package controllers_test
 
 import (
 	"context"
+	"time"
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2/textlogger"
 	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/client/fake"
@@ -176,4 +178,64 @@ var _ = Describe("Notification", func() {
 		Expect(controllers.GetSlackChannelID(slackInfo)).To(Equal(slackChannelID))
 		Expect(controllers.GetSlackToken(slackInfo)).To(Equal(slackToken))
 	})
+
+	It("getNotificationMessage reports for how long liveness checks are failing", func() {
+		conditions := []libsveltosv1beta1.Condition{
+			{
+				Name:               randomString(),
+				Type:               libsveltosv1beta1.ConditionType("Addons:" + randomString()),
+				Status:             corev1.ConditionFalse,
+				Message:            randomString(),
+				LastTransitionTime: metav1.Time{Time: time.Now().Add(-(2*time.Hour + 13*time.Minute))},
+			},
+		}
+
+		message, passing := controllers.GetNotificationMessage(randomString(), randomString(),
+			libsveltosv1beta1.ClusterTypeCapi, conditions, nil, "", textlogger.NewLogger(textlogger.NewConfig()))
+		Expect(passing).To(BeFalse())
+		Expect(message).To(ContainSubstring("failing for 2h13m  \n"))
+
+		header := randomString()
+		message, _ = controllers.GetNotificationMessage(randomString(), randomString(),
+			libsveltosv1beta1.ClusterTypeCapi, conditions, nil, header, textlogger.NewLogger(textlogger.NewConfig()))
+		Expect(message).To(HavePrefix(header + "  \n"))
+
+		user := randomString()
+		acknowledged := map[string]string{conditions[0].Name: user}
+		message, _ = controllers.GetNotificationMessage(randomString(), randomString(),
+			libsveltosv1beta1.ClusterTypeCapi, conditions, acknowledged, "", textlogger.NewLogger(textlogger.NewConfig()))
+		Expect(message).To(ContainSubstring("failing for 2h13m (acknowledged by " + user + ")  \n"))
+		Expect(message).To(ContainSubstring(conditions[0].Message + "  \n"))
+	})
+
+	It("isReminderDue returns true only while failing and once reminder interval has passed", func() {
+		interval := &metav1.Duration{Duration: time.Hour}
+		conditions := []libsveltosv1beta1.Condition{
+			{
+				Name:   randomString(),
+				Type:   libsveltosv1beta1.ConditionType("Addons:" + randomString()),
+				Status: corev1.ConditionFalse,
+			},
+		}
+
+		// No reminder interval
+		Expect(controllers.IsReminderDue(nil, controllers.NotificationState{}, conditions, nil)).To(BeFalse())
+
+		// Never sent
+		Expect(controllers.IsReminderDue(interval, controllers.NotificationState{}, conditions, nil)).To(BeTrue())
+
+		recent := controllers.NotificationState{LastSent: metav1.Time{Time: time.Now().Add(-time.Minute)}}
+		Expect(controllers.IsReminderDue(interval, recent, conditions, nil)).To(BeFalse())
+
+		old := controllers.NotificationState{LastSent: metav1.Time{Time: time.Now().Add(-2 * time.Hour)}}
+		Expect(controllers.IsReminderDue(interval, old, conditions, nil)).To(BeTrue())
+
+		// Acknowledged
+		acknowledged := map[string]string{conditions[0].Name: randomString()}
+		Expect(controllers.IsReminderDue(interval, old, conditions, acknowledged)).To(BeFalse())
+
+		// Recovered
+		conditions[0].Status = corev1.ConditionTrue
+		Expect(controllers.IsReminderDue(interval, old, conditions, nil)).To(BeFalse())
+	})
 })
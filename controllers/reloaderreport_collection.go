@@ -26,6 +26,7 @@ import (
 	corev1 "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 
 	libsveltosv1beta1 "github.com/projectsveltos/libsveltos/api/v1beta1"
@@ -35,27 +36,68 @@ import (
 )
 
 // Periodically collects ReloaderReports from each managed cluster.
-func collectReloaderReports(c client.Client, collectionInterval int, shardKey, version string, logger logr.Logger) {
+// If watchReports is set, ReloaderReports are watched in each managed cluster. Only clusters which
+// cannot be watched are then polled.
+func collectReloaderReports(ctx context.Context, c client.Client, collectionInterval int, shardKey, version string,
+	settings CollectionSettings, watchReports bool, logger logr.Logger) {
+
 	logger.V(logs.LogInfo).Info(fmt.Sprintf("collection time is set to %d seconds", collectionInterval))
 
-	ctx := context.TODO()
+	status := reloaderReportCollectionStatus
+	collect := func(ctx context.Context, cluster *corev1.ObjectReference) error {
+		err := collectAndProcessReloaderReportsFromCluster(ctx, c, cluster, version, logger)
+		status.record(cluster, err)
+		return err
+	}
+	collector := newClusterCollector(settings, time.Duration(collectionInterval)*time.Second, "ReloaderReports",
+		collect)
+
+	var watcher *reportWatcher
+	if watchReports {
+		watcher = newReportWatcher(c, version, "ReloaderReports", settings,
+			func() client.ObjectList { return &libsveltosv1beta1.ReloaderReportList{} }, collect,
+			func(ctx context.Context, remoteClient client.Client, cluster *corev1.ObjectReference,
+				eventType watch.EventType, obj client.Object, logger logr.Logger) {
+
+				rr, ok := obj.(*libsveltosv1beta1.ReloaderReport)
+				if !ok || eventType == watch.Deleted {
+					// ReloaderReports are deleted in the managed cluster once moved to the management cluster
+					return
+				}
+				processCollectedReloaderReport(ctx, c, remoteClient, cluster, rr, logger)
+			})
+	}
+
 	for {
 		logger.V(logs.LogDebug).Info("collecting ReloaderReports")
 		clusterList, err := clusterproxy.GetListOfClustersForShardKey(ctx, c, "", shardKey, logger)
 		if err != nil {
 			logger.V(logs.LogInfo).Info(fmt.Sprintf("failed to get clusters: %v", err))
+		} else {
+			status.prune(clusterList)
 		}
 
-		for i := range clusterList {
-			cluster := &clusterList[i]
-			err = collectAndProcessReloaderReportsFromCluster(ctx, c, cluster, version, logger)
-			if err != nil {
-				logger.V(logs.LogInfo).Info(fmt.Sprintf("failed to collect ReloaderReports from cluster: %s %s/%s %v",
-					cluster.Kind, cluster.Namespace, cluster.Name, err))
-			}
+		if watcher != nil {
+			watcher.sync(ctx, clusterList, logger)
+			// Clusters being watched do not need to be polled
+			clusterList = watcher.unwatchedClusters(clusterList)
 		}
 
-		time.Sleep(time.Duration(collectionInterval) * time.Second)
+		collector.sweep(ctx, clusterList, logger)
+
+		if err := status.flush(ctx, c); err != nil {
+			logger.V(logs.LogInfo).Info(fmt.Sprintf("failed to store ReloaderReport collection status: %v", err))
+		}
+
+		select {
+		case <-ctx.Done():
+			if watcher != nil {
+				watcher.wait()
+			}
+			logger.V(logs.LogInfo).Info("stop collecting ReloaderReports")
+			return
+		case <-time.After(time.Duration(collectionInterval) * time.Second):
+		}
 	}
 }
 
@@ -80,8 +122,8 @@ func collectAndProcessReloaderReportsFromCluster(ctx context.Context, c client.C
 	}
 
 	var remoteClient client.Client
-	remoteClient, err = clusterproxy.GetKubernetesClient(ctx, c, cluster.Namespace, cluster.Name,
-		"", "", clusterproxy.GetClusterType(clusterRef), logger)
+	remoteClient, err = getRemoteClient(ctx, c, cluster.Namespace, cluster.Name,
+		clusterproxy.GetClusterType(clusterRef), logger)
 	if err != nil {
 		return err
 	}
@@ -100,33 +142,39 @@ func collectAndProcessReloaderReportsFromCluster(ctx context.Context, c client.C
 
 	for i := range reloaderReportList.Items {
 		rr := &reloaderReportList.Items[i]
-		l := logger.WithValues("reloaderReport", rr.Name)
-		if !rr.DeletionTimestamp.IsZero() {
-			// ReloaderReports are deleted automatically by ReloaderReport controller
-			// after it processes them
-			logger.V(logs.LogDebug).Info("mark as deleted. Ignore it")
-			continue
-		} else {
-			logger.V(logs.LogDebug).Info("updating in management cluster")
-			err = updateReloaderReport(ctx, c, cluster, rr, l)
-			if err != nil {
-				logger.V(logs.LogInfo).Info(
-					fmt.Sprintf("failed to update ReloaderReport in management cluster. Err: %v", err))
-				continue
-			}
-			logger.V(logs.LogDebug).Info("delete ReloaderReport in managed cluster")
-			err = remoteClient.Delete(ctx, rr)
-			if err != nil {
-				logger.V(logs.LogInfo).Info(
-					fmt.Sprintf("failed to deletd ReloaderReport in managed cluster. Err: %v", err))
-				continue
-			}
-		}
+		processCollectedReloaderReport(ctx, c, remoteClient, cluster, rr, logger.WithValues("reloaderReport", rr.Name))
 	}
 
 	return nil
 }
 
+// processCollectedReloaderReport moves a ReloaderReport collected from a managed cluster to the
+// management cluster
+func processCollectedReloaderReport(ctx context.Context, c, remoteClient client.Client,
+	cluster *corev1.ObjectReference, rr *libsveltosv1beta1.ReloaderReport, logger logr.Logger) {
+
+	if !rr.DeletionTimestamp.IsZero() {
+		// ReloaderReports are deleted automatically by ReloaderReport controller
+		// after it processes them
+		logger.V(logs.LogDebug).Info("mark as deleted. Ignore it")
+		return
+	}
+
+	logger.V(logs.LogDebug).Info("updating in management cluster")
+	err := updateReloaderReport(ctx, c, cluster, rr, logger)
+	if err != nil {
+		logger.V(logs.LogInfo).Info(
+			fmt.Sprintf("failed to update ReloaderReport in management cluster. Err: %v", err))
+		return
+	}
+	logger.V(logs.LogDebug).Info("delete ReloaderReport in managed cluster")
+	err = remoteClient.Delete(ctx, rr)
+	if err != nil {
+		logger.V(logs.LogInfo).Info(
+			fmt.Sprintf("failed to deletd ReloaderReport in managed cluster. Err: %v", err))
+	}
+}
+
 func updateReloaderReport(ctx context.Context, c client.Client, cluster *corev1.ObjectReference,
 	reloaderReport *libsveltosv1beta1.ReloaderReport, logger logr.Logger) error {
 
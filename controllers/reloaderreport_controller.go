@@ -30,10 +30,10 @@ import (
 	"sigs.k8s.io/cluster-api/util"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 
 	libsveltosv1beta1 "github.com/projectsveltos/libsveltos/api/v1beta1"
-	"github.com/projectsveltos/libsveltos/lib/clusterproxy"
 	logs "github.com/projectsveltos/libsveltos/lib/logsettings"
 )
 
@@ -48,6 +48,7 @@ type ReloaderReportReconciler struct {
 	ReloaderReportMode ReportMode
 	ShardKey           string // when set, only clusters matching the ShardKey will be reconciled
 	Version            string
+	CollectionSettings CollectionSettings
 }
 
 //+kubebuilder:rbac:groups=lib.projectsveltos.io,resources=reloaderreports,verbs=create;get;list;watch;update;patch;delete
@@ -91,8 +92,16 @@ func (r *ReloaderReportReconciler) Reconcile(ctx context.Context, req ctrl.Reque
 
 // SetupWithManager sets up the controller with the Manager.
 func (r *ReloaderReportReconciler) SetupWithManager(mgr ctrl.Manager, collectionInterval int) error {
-	if r.ReloaderReportMode == CollectFromManagementCluster {
-		go collectReloaderReports(mgr.GetClient(), collectionInterval, r.ShardKey, r.Version, mgr.GetLogger())
+	if r.ReloaderReportMode == CollectFromManagementCluster || r.ReloaderReportMode == WatchFromManagementCluster {
+		// Added as a Runnable: collection only runs on the leader and stops when the manager stops
+		err := mgr.Add(manager.RunnableFunc(func(ctx context.Context) error {
+			collectReloaderReports(ctx, mgr.GetClient(), collectionInterval, r.ShardKey, r.Version,
+				r.CollectionSettings, r.ReloaderReportMode == WatchFromManagementCluster, mgr.GetLogger())
+			return nil
+		}))
+		if err != nil {
+			return err
+		}
 	}
 
 	return ctrl.NewControllerManagedBy(mgr).
@@ -117,8 +126,8 @@ func (r *ReloaderReportReconciler) processReloaderReport(ctx context.Context,
 			kind, namespace, name))
 	}
 
-	remoteClient, err := clusterproxy.GetKubernetesClient(ctx, r.Client, reloaderReport.Spec.ClusterNamespace,
-		reloaderReport.Spec.ClusterName, "", "", reloaderReport.Spec.ClusterType, logger)
+	remoteClient, err := getRemoteClient(ctx, r.Client, reloaderReport.Spec.ClusterNamespace,
+		reloaderReport.Spec.ClusterName, reloaderReport.Spec.ClusterType, logger)
 	if err != nil {
 		return err
 	}
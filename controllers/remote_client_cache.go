@@ -0,0 +1,367 @@
+/*
+Copyright 2023. projectsveltos.io. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/rest"
+	toolscache "k8s.io/client-go/tools/cache"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/cluster-api/util/secret"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+
+	libsveltosv1beta1 "github.com/projectsveltos/libsveltos/api/v1beta1"
+	"github.com/projectsveltos/libsveltos/lib/clusterproxy"
+	logs "github.com/projectsveltos/libsveltos/lib/logsettings"
+)
+
+const (
+	// DefaultRemoteClientCacheTTL is the default time a client to a managed cluster is reused for
+	DefaultRemoteClientCacheTTL = 10 * time.Minute
+
+	// sveltosKubeconfigSecretNamePostfix is the postfix of the default name of the Secret containing
+	// the SveltosCluster kubeconfig
+	sveltosKubeconfigSecretNamePostfix = "-sveltos-kubeconfig"
+)
+
+var (
+	remoteClients = newRemoteClientCache(DefaultRemoteClientCacheTTL)
+)
+
+// SetRemoteClientCacheTTL sets the time a client to a managed cluster is reused for.
+// A ttl of zero disables caching.
+func SetRemoteClientCacheTTL(ttl time.Duration) {
+	remoteClients = newRemoteClientCache(ttl)
+}
+
+// remoteClientEntry is a cached client to a managed cluster
+type remoteClientEntry struct {
+	client client.WithWatch
+	// created is when the client was created. Client is not reused after ttl.
+	created time.Time
+	// namespace and secretName identify the Secret containing the cluster kubeconfig
+	namespace  string
+	secretName string
+	// secretVersion is the kubeconfig Secret resourceVersion when the client was created
+	secretVersion string
+	// generation is the cluster (SveltosCluster or Cluster) generation when the client was created
+	generation int64
+}
+
+// remoteClientCache caches clients to managed clusters, so a client (and its discovery RESTMapper) is
+// not built at every call. A cached client is discarded when its ttl expires, when the Secret containing
+// the cluster kubeconfig changes or when the cluster spec changes.
+type remoteClientCache struct {
+	ttl time.Duration
+	now func() time.Time
+
+	mux sync.Mutex
+	// key: managed cluster
+	entries map[string]*remoteClientEntry
+	// invalidations counts, per managed cluster, how many times its client was invalidated.
+	// A client built while this changed might come from a stale kubeconfig and is not cached.
+	invalidations map[string]uint64
+	// building counts, per managed cluster, the clients being built
+	building map[string]int
+}
+
+func newRemoteClientCache(ttl time.Duration) *remoteClientCache {
+	return &remoteClientCache{
+		ttl:           ttl,
+		now:           time.Now,
+		entries:       make(map[string]*remoteClientEntry),
+		invalidations: make(map[string]uint64),
+		building:      make(map[string]int),
+	}
+}
+
+// get returns a client to the managed cluster, creating it if none is cached or the cached one expired
+func (rc *remoteClientCache) get(ctx context.Context, c client.Client, clusterNamespace, clusterName string,
+	clusterType libsveltosv1beta1.ClusterType, logger logr.Logger) (client.WithWatch, error) {
+
+	if rc.ttl <= 0 {
+		return newRemoteClient(ctx, c, clusterNamespace, clusterName, clusterType, logger)
+	}
+
+	key := getClusterKey(clusterNamespace, clusterName, clusterType)
+
+	rc.mux.Lock()
+	entry, ok := rc.entries[key]
+	if ok && rc.now().Sub(entry.created) < rc.ttl {
+		rc.mux.Unlock()
+		recordRemoteClientCacheHit()
+		return entry.client, nil
+	}
+	invalidations := rc.invalidations[key]
+	rc.building[key]++
+	rc.mux.Unlock()
+	recordRemoteClientCacheMiss()
+
+	defer func() {
+		rc.mux.Lock()
+		defer rc.mux.Unlock()
+		rc.building[key]--
+		if rc.building[key] == 0 {
+			delete(rc.building, key)
+		}
+	}()
+
+	secretName, generation, err := getClusterKubeconfigInfo(ctx, c, clusterNamespace, clusterName, clusterType)
+	if err != nil {
+		return nil, err
+	}
+
+	secretVersion, err := getKubeconfigSecretVersion(ctx, c, clusterNamespace, secretName)
+	if err != nil {
+		return nil, err
+	}
+
+	remoteClient, err := newRemoteClient(ctx, c, clusterNamespace, clusterName, clusterType, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	rc.mux.Lock()
+	defer rc.mux.Unlock()
+
+	if rc.invalidations[key] != invalidations {
+		// Cluster or kubeconfig changed while client was being built. Client is used for this call only.
+		logger.V(logs.LogDebug).Info("remote client invalidated while being built. Not caching it.")
+		return remoteClient, nil
+	}
+
+	rc.entries[key] = &remoteClientEntry{
+		client:        remoteClient,
+		created:       rc.now(),
+		namespace:     clusterNamespace,
+		secretName:    secretName,
+		secretVersion: secretVersion,
+		generation:    generation,
+	}
+	setRemoteClientCacheSize(len(rc.entries))
+
+	return remoteClient, nil
+}
+
+// newRemoteClient returns a new client to the managed cluster. Same credentials clusterproxy.GetKubernetesClient
+// uses, but client also supports watch (used to watch reports).
+func newRemoteClient(ctx context.Context, c client.Client, clusterNamespace, clusterName string,
+	clusterType libsveltosv1beta1.ClusterType, logger logr.Logger) (client.WithWatch, error) {
+
+	restConfig, err := clusterproxy.GetKubernetesRestConfig(ctx, c, clusterNamespace, clusterName,
+		"", "", clusterType, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	return client.NewWithWatch(restConfig, client.Options{Scheme: c.Scheme()})
+}
+
+// discard removes the cached client for key and marks any client being built for key as stale.
+// Must be called with rc.mux held.
+func (rc *remoteClientCache) discard(key string) {
+	delete(rc.entries, key)
+	rc.invalidations[key]++
+}
+
+// invalidate discards the cached client to a managed cluster
+func (rc *remoteClientCache) invalidate(clusterNamespace, clusterName string,
+	clusterType libsveltosv1beta1.ClusterType) {
+
+	rc.mux.Lock()
+	defer rc.mux.Unlock()
+
+	rc.discard(getClusterKey(clusterNamespace, clusterName, clusterType))
+	setRemoteClientCacheSize(len(rc.entries))
+}
+
+// invalidateOnClusterChange discards the cached client to a managed cluster if it was created for a
+// different cluster generation or kubeconfig Secret resourceVersion. A client being built is
+// conservatively discarded as well, since what it was built from is not known yet.
+func (rc *remoteClientCache) invalidateOnClusterChange(clusterNamespace, clusterName string,
+	clusterType libsveltosv1beta1.ClusterType, generation int64, secretVersion string) {
+
+	key := getClusterKey(clusterNamespace, clusterName, clusterType)
+
+	rc.mux.Lock()
+	defer rc.mux.Unlock()
+
+	entry, ok := rc.entries[key]
+	if (ok && (entry.generation != generation || entry.secretVersion != secretVersion)) ||
+		rc.building[key] > 0 {
+
+		rc.discard(key)
+		setRemoteClientCacheSize(len(rc.entries))
+	}
+}
+
+// invalidateOnSecretChange discards cached clients created from the kubeconfig in Secret namespace/name.
+// Which Secret a client being built uses is not known yet, so every client being built is discarded.
+func (rc *remoteClientCache) invalidateOnSecretChange(namespace, name string) {
+	rc.mux.Lock()
+	defer rc.mux.Unlock()
+
+	for key, entry := range rc.entries {
+		if entry.namespace == namespace && entry.secretName == name {
+			rc.discard(key)
+		}
+	}
+	for key := range rc.building {
+		rc.invalidations[key]++
+	}
+	setRemoteClientCacheSize(len(rc.entries))
+}
+
+// getKubeconfigSecretName returns the name of the Secret containing the cluster kubeconfig
+func getKubeconfigSecretName(cluster client.Object) string {
+	if sveltosCluster, ok := cluster.(*libsveltosv1beta1.SveltosCluster); ok {
+		if sveltosCluster.Spec.KubeconfigName != "" {
+			return sveltosCluster.Spec.KubeconfigName
+		}
+		return sveltosCluster.Name + sveltosKubeconfigSecretNamePostfix
+	}
+
+	return secret.Name(cluster.GetName(), secret.Kubeconfig)
+}
+
+// getClusterKubeconfigInfo returns the name of the Secret containing the cluster kubeconfig and the
+// cluster generation
+func getClusterKubeconfigInfo(ctx context.Context, c client.Client, clusterNamespace, clusterName string,
+	clusterType libsveltosv1beta1.ClusterType) (secretName string, generation int64, err error) {
+
+	key := types.NamespacedName{Namespace: clusterNamespace, Name: clusterName}
+
+	var cluster client.Object = &clusterv1.Cluster{}
+	if clusterType == libsveltosv1beta1.ClusterTypeSveltos {
+		cluster = &libsveltosv1beta1.SveltosCluster{}
+	}
+	if err := c.Get(ctx, key, cluster); err != nil {
+		return "", 0, err
+	}
+
+	return getKubeconfigSecretName(cluster), cluster.GetGeneration(), nil
+}
+
+// getKubeconfigSecretVersion returns the resourceVersion of the Secret containing the cluster kubeconfig.
+// An empty string is returned if the Secret does not exist.
+func getKubeconfigSecretVersion(ctx context.Context, c client.Client, namespace, secretName string) (string, error) {
+	kubeconfigSecret := &corev1.Secret{}
+	err := c.Get(ctx, types.NamespacedName{Namespace: namespace, Name: secretName}, kubeconfigSecret)
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return "", nil
+		}
+		return "", err
+	}
+
+	return kubeconfigSecret.ResourceVersion, nil
+}
+
+// kubeconfigSecretWatcher discards cached clients to managed clusters when the Secret containing their
+// kubeconfig changes or is deleted. Only metadata of ClusterAPI kubeconfig Secrets (those labeled with
+// the cluster name) is cached. SveltosCluster kubeconfig Secrets carry no common label: SveltosClusterReconciler
+// watches them instead and discards the cached client when the Secret resourceVersion changes.
+type kubeconfigSecretWatcher struct {
+	config *rest.Config
+	scheme *runtime.Scheme
+	mapper meta.RESTMapper
+	logger logr.Logger
+}
+
+// NewKubeconfigSecretWatcher returns a manager Runnable discarding cached clients to managed clusters
+// when their kubeconfig Secret changes
+func NewKubeconfigSecretWatcher(mgr manager.Manager, logger logr.Logger) *kubeconfigSecretWatcher {
+	return &kubeconfigSecretWatcher{
+		config: mgr.GetConfig(),
+		scheme: mgr.GetScheme(),
+		mapper: mgr.GetRESTMapper(),
+		logger: logger.WithName("kubeconfig-secret-watcher"),
+	}
+}
+
+// NeedLeaderElection returns false: clients are cached by every replica
+func (w *kubeconfigSecretWatcher) NeedLeaderElection() bool {
+	return false
+}
+
+// Start watches kubeconfig Secrets till ctx is canceled
+func (w *kubeconfigSecretWatcher) Start(ctx context.Context) error {
+	selector, err := labels.Parse(clusterv1.ClusterNameLabel)
+	if err != nil {
+		return err
+	}
+
+	secret := &metav1.PartialObjectMetadata{}
+	secret.SetGroupVersionKind(corev1.SchemeGroupVersion.WithKind("Secret"))
+
+	secretCache, err := cache.New(w.config, cache.Options{
+		Scheme: w.scheme,
+		Mapper: w.mapper,
+		ByObject: map[client.Object]cache.ByObject{
+			secret: {Label: selector},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	informer, err := secretCache.GetInformer(ctx, secret)
+	if err != nil {
+		return err
+	}
+
+	invalidate := func(obj interface{}) {
+		if tombstone, ok := obj.(toolscache.DeletedFinalStateUnknown); ok {
+			obj = tombstone.Obj
+		}
+		if s, ok := obj.(*metav1.PartialObjectMetadata); ok {
+			w.logger.V(logs.LogVerbose).Info("secret changed", "secret", s.Namespace+"/"+s.Name)
+			remoteClients.invalidateOnSecretChange(s.Namespace, s.Name)
+		}
+	}
+
+	_, err = informer.AddEventHandler(toolscache.ResourceEventHandlerFuncs{
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			oldSecret, oldOk := oldObj.(*metav1.PartialObjectMetadata)
+			newSecret, newOk := newObj.(*metav1.PartialObjectMetadata)
+			if oldOk && newOk && oldSecret.ResourceVersion == newSecret.ResourceVersion {
+				// Periodic resync
+				return
+			}
+			invalidate(newObj)
+		},
+		DeleteFunc: invalidate,
+	})
+	if err != nil {
+		return err
+	}
+
+	return secretCache.Start(ctx)
+}
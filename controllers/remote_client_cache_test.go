@@ -0,0 +1,261 @@
+/*
+Copyright 2023. projectsveltos.io. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers_test
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2/textlogger"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"github.com/projectsveltos/healthcheck-manager/controllers"
+	libsveltosv1beta1 "github.com/projectsveltos/libsveltos/api/v1beta1"
+)
+
+const (
+	testKubeconfig = `apiVersion: v1
+kind: Config
+clusters:
+- cluster:
+    server: https://127.0.0.1:6443
+    insecure-skip-tls-verify: true
+  name: managed
+contexts:
+- context:
+    cluster: managed
+    user: sveltos
+  name: managed
+current-context: managed
+users:
+- name: sveltos
+  user:
+    token: token
+`
+)
+
+var _ = Describe("Remote client cache", func() {
+	var sveltosCluster *libsveltosv1beta1.SveltosCluster
+	var c client.Client
+
+	BeforeEach(func() {
+		sveltosCluster = &libsveltosv1beta1.SveltosCluster{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace:  randomString(),
+				Name:       randomString(),
+				Generation: 1,
+			},
+		}
+
+		secret := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: sveltosCluster.Namespace,
+				Name:      sveltosCluster.Name + "-sveltos-kubeconfig",
+			},
+			Data: map[string][]byte{
+				"kubeconfig": []byte(testKubeconfig),
+			},
+		}
+
+		c = fake.NewClientBuilder().WithScheme(scheme).WithObjects(sveltosCluster, secret).Build()
+	})
+
+	It("reuses clients till the kubeconfig Secret or the cluster changes", func() {
+		logger := textlogger.NewLogger(textlogger.NewConfig())
+		cache := controllers.NewRemoteClientCache(time.Minute)
+
+		get := func() client.Client {
+			remoteClient, err := controllers.GetCachedRemoteClient(cache, context.TODO(), c,
+				sveltosCluster.Namespace, sveltosCluster.Name, libsveltosv1beta1.ClusterTypeSveltos, logger)
+			Expect(err).To(BeNil())
+			return remoteClient
+		}
+
+		remoteClient := get()
+		Expect(get()).To(BeIdenticalTo(remoteClient))
+		Expect(controllers.GetRemoteClientCacheSize(cache)).To(Equal(1))
+
+		// A Secret not containing cluster kubeconfig does not invalidate the cached client
+		controllers.InvalidateRemoteClientOnSecret(cache, sveltosCluster.Namespace, randomString())
+		Expect(get()).To(BeIdenticalTo(remoteClient))
+
+		controllers.InvalidateRemoteClientOnSecret(cache, sveltosCluster.Namespace,
+			sveltosCluster.Name+"-sveltos-kubeconfig")
+		Expect(controllers.GetRemoteClientCacheSize(cache)).To(BeZero())
+		newRemoteClient := get()
+		Expect(newRemoteClient).ToNot(BeIdenticalTo(remoteClient))
+
+		currentCluster := &libsveltosv1beta1.SveltosCluster{}
+		Expect(c.Get(context.TODO(), client.ObjectKeyFromObject(sveltosCluster), currentCluster)).To(Succeed())
+		currentSecret := &corev1.Secret{}
+		Expect(c.Get(context.TODO(), types.NamespacedName{Namespace: sveltosCluster.Namespace,
+			Name: sveltosCluster.Name + "-sveltos-kubeconfig"}, currentSecret)).To(Succeed())
+
+		// Same generation and kubeconfig: cached client is still valid
+		controllers.InvalidateRemoteClientOnCluster(cache, sveltosCluster.Namespace, sveltosCluster.Name,
+			libsveltosv1beta1.ClusterTypeSveltos, currentCluster.Generation, currentSecret.ResourceVersion)
+		Expect(get()).To(BeIdenticalTo(newRemoteClient))
+
+		controllers.InvalidateRemoteClientOnCluster(cache, sveltosCluster.Namespace, sveltosCluster.Name,
+			libsveltosv1beta1.ClusterTypeSveltos, currentCluster.Generation+1, currentSecret.ResourceVersion)
+		newRemoteClient = get()
+		Expect(newRemoteClient).ToNot(BeIdenticalTo(remoteClient))
+
+		// Kubeconfig Secret changed
+		controllers.InvalidateRemoteClientOnCluster(cache, sveltosCluster.Namespace, sveltosCluster.Name,
+			libsveltosv1beta1.ClusterTypeSveltos, currentCluster.Generation, randomString())
+		Expect(get()).ToNot(BeIdenticalTo(newRemoteClient))
+	})
+
+	It("does not cache a client invalidated while being built", func() {
+		logger := textlogger.NewLogger(textlogger.NewConfig())
+		cache := controllers.NewRemoteClientCache(time.Minute)
+		secretName := sveltosCluster.Name + "-sveltos-kubeconfig"
+
+		// Kubeconfig Secret changes while the client is being built from it
+		invalidated := false
+		interceptorClient := interceptor.NewClient(c.(client.WithWatch), interceptor.Funcs{
+			Get: func(ctx context.Context, c client.WithWatch, key client.ObjectKey, obj client.Object,
+				opts ...client.GetOption) error {
+
+				if _, ok := obj.(*corev1.Secret); ok && key.Name == secretName && !invalidated {
+					invalidated = true
+					controllers.InvalidateRemoteClientOnSecret(cache, key.Namespace, key.Name)
+				}
+				return c.Get(ctx, key, obj, opts...)
+			},
+		})
+
+		remoteClient, err := controllers.GetCachedRemoteClient(cache, context.TODO(), interceptorClient,
+			sveltosCluster.Namespace, sveltosCluster.Name, libsveltosv1beta1.ClusterTypeSveltos, logger)
+		Expect(err).To(BeNil())
+		Expect(remoteClient).ToNot(BeNil())
+		Expect(invalidated).To(BeTrue())
+		Expect(controllers.GetRemoteClientCacheSize(cache)).To(BeZero())
+
+		newRemoteClient, err := controllers.GetCachedRemoteClient(cache, context.TODO(), interceptorClient,
+			sveltosCluster.Namespace, sveltosCluster.Name, libsveltosv1beta1.ClusterTypeSveltos, logger)
+		Expect(err).To(BeNil())
+		Expect(newRemoteClient).ToNot(BeIdenticalTo(remoteClient))
+		Expect(controllers.GetRemoteClientCacheSize(cache)).To(Equal(1))
+	})
+
+	It("discards the cached client when the SveltosCluster kubeconfig Secret changes", func() {
+		logger := textlogger.NewLogger(textlogger.NewConfig())
+		controllers.SetRemoteClientCacheTTL(time.Minute)
+		defer controllers.SetRemoteClientCacheTTL(controllers.DefaultRemoteClientCacheTTL)
+		cache := controllers.GetRemoteClientCache()
+
+		reconciler := &controllers.SveltosClusterReconciler{Client: c, Scheme: scheme}
+
+		remoteClient, err := controllers.GetCachedRemoteClient(cache, context.TODO(), c,
+			sveltosCluster.Namespace, sveltosCluster.Name, libsveltosv1beta1.ClusterTypeSveltos, logger)
+		Expect(err).To(BeNil())
+
+		kubeconfigSecret := &corev1.Secret{}
+		Expect(c.Get(context.TODO(), types.NamespacedName{Namespace: sveltosCluster.Namespace,
+			Name: sveltosCluster.Name + "-sveltos-kubeconfig"}, kubeconfigSecret)).To(Succeed())
+
+		// Only SveltosClusters using the Secret are requeued
+		requests := controllers.RequeueSveltosClusterForSecret(reconciler, context.TODO(), kubeconfigSecret)
+		Expect(requests).To(ConsistOf(reconcile.Request{NamespacedName: client.ObjectKeyFromObject(sveltosCluster)}))
+		otherSecret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: sveltosCluster.Namespace,
+			Name: randomString()}}
+		Expect(controllers.RequeueSveltosClusterForSecret(reconciler, context.TODO(), otherSecret)).To(BeEmpty())
+
+		// Kubeconfig not changed: cached client is still valid
+		req := ctrl.Request{NamespacedName: client.ObjectKeyFromObject(sveltosCluster)}
+		_, err = reconciler.Reconcile(context.TODO(), req)
+		Expect(err).To(BeNil())
+		Expect(controllers.GetRemoteClientCacheSize(cache)).To(Equal(1))
+
+		kubeconfigSecret.Data["kubeconfig"] = []byte(testKubeconfig + "\n")
+		Expect(c.Update(context.TODO(), kubeconfigSecret)).To(Succeed())
+
+		_, err = reconciler.Reconcile(context.TODO(), req)
+		Expect(err).To(BeNil())
+		Expect(controllers.GetRemoteClientCacheSize(cache)).To(BeZero())
+
+		newRemoteClient, err := controllers.GetCachedRemoteClient(cache, context.TODO(), c,
+			sveltosCluster.Namespace, sveltosCluster.Name, libsveltosv1beta1.ClusterTypeSveltos, logger)
+		Expect(err).To(BeNil())
+		Expect(newRemoteClient).ToNot(BeIdenticalTo(remoteClient))
+	})
+
+	It("discards the cached client when the cluster rejects its credentials", func() {
+		logger := textlogger.NewLogger(textlogger.NewConfig())
+		cache := controllers.GetRemoteClientCache()
+
+		get := func() client.Client {
+			remoteClient, err := controllers.GetCachedRemoteClient(cache, context.TODO(), c,
+				sveltosCluster.Namespace, sveltosCluster.Name, libsveltosv1beta1.ClusterTypeSveltos, logger)
+			Expect(err).To(BeNil())
+			return remoteClient
+		}
+
+		remoteClient := get()
+		Expect(get()).To(BeIdenticalTo(remoteClient))
+
+		unauthorizedClient := fake.NewClientBuilder().WithScheme(scheme).WithInterceptorFuncs(interceptor.Funcs{
+			Get: func(ctx context.Context, c client.WithWatch, key client.ObjectKey, obj client.Object,
+				opts ...client.GetOption) error {
+
+				return apierrors.NewUnauthorized("token expired")
+			},
+		}).Build()
+		breakerClient := controllers.NewCircuitBreakerClient(unauthorizedClient, sveltosCluster.Namespace,
+			sveltosCluster.Name, libsveltosv1beta1.ClusterTypeSveltos)
+		err := breakerClient.Get(context.TODO(), types.NamespacedName{Name: randomString()}, &corev1.Namespace{})
+		Expect(apierrors.IsUnauthorized(err)).To(BeTrue())
+
+		Expect(get()).ToNot(BeIdenticalTo(remoteClient))
+	})
+
+	It("creates a new client once ttl expires", func() {
+		logger := textlogger.NewLogger(textlogger.NewConfig())
+		cache := controllers.NewRemoteClientCache(time.Minute)
+		now := time.Now()
+		controllers.SetRemoteClientCacheClock(cache, func() time.Time { return now })
+
+		remoteClient, err := controllers.GetCachedRemoteClient(cache, context.TODO(), c,
+			sveltosCluster.Namespace, sveltosCluster.Name, libsveltosv1beta1.ClusterTypeSveltos, logger)
+		Expect(err).To(BeNil())
+
+		now = now.Add(59 * time.Second)
+		cachedClient, err := controllers.GetCachedRemoteClient(cache, context.TODO(), c,
+			sveltosCluster.Namespace, sveltosCluster.Name, libsveltosv1beta1.ClusterTypeSveltos, logger)
+		Expect(err).To(BeNil())
+		Expect(cachedClient).To(BeIdenticalTo(remoteClient))
+
+		now = now.Add(time.Second)
+		newRemoteClient, err := controllers.GetCachedRemoteClient(cache, context.TODO(), c,
+			sveltosCluster.Namespace, sveltosCluster.Name, libsveltosv1beta1.ClusterTypeSveltos, logger)
+		Expect(err).To(BeNil())
+		Expect(newRemoteClient).ToNot(BeIdenticalTo(remoteClient))
+	})
+})
@@ -0,0 +1,287 @@
+/*
+Copyright 2023. projectsveltos.io. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/projectsveltos/libsveltos/lib/clusterproxy"
+	logs "github.com/projectsveltos/libsveltos/lib/logsettings"
+)
+
+const (
+	// DefaultCollectionWorkers is the default number of clusters reports are collected from in parallel
+	DefaultCollectionWorkers = 10
+
+	// DefaultCollectionClusterTimeout is the default maximum time spent collecting reports from a
+	// single cluster
+	DefaultCollectionClusterTimeout = 30 * time.Second
+
+	// DefaultCollectionSweepTimeout is the default maximum time spent collecting reports from all
+	// clusters
+	DefaultCollectionSweepTimeout = 5 * time.Minute
+
+	// maxCollectionBackoff is the maximum interval between attempts to collect reports from a cluster
+	// which keeps failing
+	maxCollectionBackoff = 10 * time.Minute
+)
+
+// collectionBackoff tracks failed collection attempts from a cluster
+type collectionBackoff struct {
+	// cluster is the cluster collection is failing from
+	cluster corev1.ObjectReference
+	// failures is the number of consecutive failed collections
+	failures int
+	// backoff is the current interval between collection attempts
+	backoff time.Duration
+	// nextAttempt is when collection from the cluster will be attempted again
+	nextAttempt time.Time
+}
+
+// CollectionSettings contains settings used when collecting reports (HealthCheckReports and
+// ReloaderReports) from managed clusters
+type CollectionSettings struct {
+	// Workers is the number of clusters reports are collected from in parallel
+	Workers int
+
+	// ClusterTimeout is the maximum time spent collecting reports from a single cluster
+	ClusterTimeout time.Duration
+
+	// SweepTimeout is the maximum time spent collecting reports from all clusters. Clusters not
+	// processed by then are skipped till next sweep.
+	SweepTimeout time.Duration
+
+	// ResyncPeriod is, when reports are watched (WatchFromManagementCluster), the interval at which
+	// all reports are collected again from each watched cluster
+	ResyncPeriod time.Duration
+}
+
+// clusterCollectFunc collects reports from a managed cluster
+type clusterCollectFunc func(ctx context.Context, cluster *corev1.ObjectReference) error
+
+// clusterCollector collects reports from managed clusters using a bounded pool of workers
+type clusterCollector struct {
+	settings CollectionSettings
+	// interval is the interval between sweeps. Used as initial backoff for failing clusters.
+	interval time.Duration
+	// reportKind is used for logging only
+	reportKind string
+	collect    clusterCollectFunc
+
+	// next is the index, in the cluster list, the next sweep starts dispatching from. When a sweep
+	// deadline is reached, next sweep starts from the first cluster not dispatched, so the same
+	// clusters are not skipped at every sweep. Only accessed by sweep, which is never called concurrently.
+	next int
+
+	// mux protects inFlight and backoffs
+	mux sync.Mutex
+	// inFlight contains the clusters reports are being collected from. Sweep waits for all collections
+	// to return, so a collection not honoring its context blocks the whole sweep.
+	inFlight map[string]bool
+	// backoffs contains clusters collection keeps failing from. Such clusters are polled less often
+	// (exponential backoff, starting from interval, up to maxCollectionBackoff) till collection succeeds.
+	backoffs map[string]*collectionBackoff
+}
+
+func newClusterCollector(settings CollectionSettings, interval time.Duration, reportKind string,
+	collect clusterCollectFunc) *clusterCollector {
+
+	if settings.Workers <= 0 {
+		settings.Workers = DefaultCollectionWorkers
+	}
+	if settings.ClusterTimeout <= 0 {
+		settings.ClusterTimeout = DefaultCollectionClusterTimeout
+	}
+	if settings.SweepTimeout <= 0 {
+		settings.SweepTimeout = DefaultCollectionSweepTimeout
+	}
+
+	return &clusterCollector{
+		settings:   settings,
+		interval:   interval,
+		reportKind: reportKind,
+		collect:    collect,
+		inFlight:   make(map[string]bool),
+		backoffs:   make(map[string]*collectionBackoff),
+	}
+}
+
+// sweep collects reports from all clusters in clusterList. It returns once all clusters were processed
+// or, when SweepTimeout expires, once the collections in progress are canceled. Clusters are dispatched
+// starting from where previous sweep deadline was reached.
+func (cc *clusterCollector) sweep(ctx context.Context, clusterList []corev1.ObjectReference, logger logr.Logger) {
+	sweepCtx, cancel := context.WithTimeout(ctx, cc.settings.SweepTimeout)
+	defer cancel()
+
+	cc.pruneBackoffs(clusterList)
+
+	jobs := make(chan *corev1.ObjectReference)
+	wg := sync.WaitGroup{}
+	for range cc.settings.Workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for cluster := range jobs {
+				cc.collectFromCluster(sweepCtx, cluster, logger)
+			}
+		}()
+	}
+
+	start := 0
+	if len(clusterList) > 0 {
+		start = cc.next % len(clusterList)
+	}
+
+	dispatched := 0
+dispatch:
+	for i := range clusterList {
+		select {
+		case jobs <- &clusterList[(start+i)%len(clusterList)]:
+			dispatched++
+		case <-sweepCtx.Done():
+			break dispatch
+		}
+	}
+	close(jobs)
+
+	// Once sweep deadline is reached, sweepCtx is canceled and so are the collections in progress.
+	// Wait for workers anyway, so none is still running (and updating collection status) after sweep returns.
+	wg.Wait()
+
+	if len(clusterList) > 0 {
+		cc.next = (start + dispatched) % len(clusterList)
+	}
+
+	if dispatched < len(clusterList) || sweepCtx.Err() != nil {
+		logger.V(logs.LogInfo).Info(fmt.Sprintf("%s collection sweep deadline reached: %d of %d clusters dispatched",
+			cc.reportKind, dispatched, len(clusterList)))
+	}
+}
+
+// collectFromCluster collects reports from a cluster, unless a previous collection from the same
+// cluster is still in progress or the cluster is backing off after failures
+func (cc *clusterCollector) collectFromCluster(ctx context.Context, cluster *corev1.ObjectReference,
+	logger logr.Logger) {
+
+	key := getClusterKey(cluster.Namespace, cluster.Name, clusterproxy.GetClusterType(cluster))
+
+	cc.mux.Lock()
+	if cc.inFlight[key] {
+		cc.mux.Unlock()
+		logger.V(logs.LogDebug).Info(fmt.Sprintf("%s collection from cluster %s/%s still in progress",
+			cc.reportKind, cluster.Namespace, cluster.Name))
+		return
+	}
+	if b, ok := cc.backoffs[key]; ok && time.Now().Before(b.nextAttempt) {
+		cc.mux.Unlock()
+		logger.V(logs.LogDebug).Info(fmt.Sprintf("%s collection from cluster %s/%s backing off till %s",
+			cc.reportKind, cluster.Namespace, cluster.Name, b.nextAttempt.Format(time.RFC3339)))
+		return
+	}
+	cc.inFlight[key] = true
+	cc.mux.Unlock()
+
+	defer func() {
+		cc.mux.Lock()
+		delete(cc.inFlight, key)
+		cc.mux.Unlock()
+	}()
+
+	clusterCtx, cancel := context.WithTimeout(ctx, cc.settings.ClusterTimeout)
+	defer cancel()
+
+	err := cc.collect(clusterCtx, cluster)
+	if err != nil {
+		logger.V(logs.LogInfo).Info(fmt.Sprintf("failed to collect %s from cluster: %s %s/%s %v",
+			cc.reportKind, cluster.Kind, cluster.Namespace, cluster.Name, err))
+	}
+	cc.recordCollectionResult(cluster, err, logger)
+}
+
+// recordCollectionResult updates cluster backoff: a failure increases it, a success resets it
+func (cc *clusterCollector) recordCollectionResult(cluster *corev1.ObjectReference, collectionErr error,
+	logger logr.Logger) {
+
+	clusterType := clusterproxy.GetClusterType(cluster)
+	key := getClusterKey(cluster.Namespace, cluster.Name, clusterType)
+
+	cc.mux.Lock()
+	defer cc.mux.Unlock()
+
+	if collectionErr == nil {
+		if _, ok := cc.backoffs[key]; ok {
+			logger.V(logs.LogInfo).Info(fmt.Sprintf("%s collection from cluster %s/%s recovered",
+				cc.reportKind, cluster.Namespace, cluster.Name))
+			delete(cc.backoffs, key)
+			deleteCollectionBackoffMetric(cc.reportKind, cluster.Namespace, cluster.Name, clusterType)
+		}
+		return
+	}
+
+	b, ok := cc.backoffs[key]
+	if !ok {
+		b = &collectionBackoff{cluster: *cluster}
+		cc.backoffs[key] = b
+	}
+	b.failures++
+	b.backoff = getCollectionBackoff(cc.interval, b.failures)
+	b.nextAttempt = time.Now().Add(b.backoff)
+	setCollectionBackoffMetric(cc.reportKind, cluster.Namespace, cluster.Name, clusterType, b.backoff)
+	logger.V(logs.LogDebug).Info(fmt.Sprintf("%s collection from cluster %s/%s failed %d times. Backing off %s",
+		cc.reportKind, cluster.Namespace, cluster.Name, b.failures, b.backoff))
+}
+
+// pruneBackoffs removes backoffs for clusters which are not in clusterList anymore
+func (cc *clusterCollector) pruneBackoffs(clusterList []corev1.ObjectReference) {
+	current := make(map[string]bool, len(clusterList))
+	for i := range clusterList {
+		current[getClusterKey(clusterList[i].Namespace, clusterList[i].Name,
+			clusterproxy.GetClusterType(&clusterList[i]))] = true
+	}
+
+	cc.mux.Lock()
+	defer cc.mux.Unlock()
+
+	for key, b := range cc.backoffs {
+		if !current[key] {
+			delete(cc.backoffs, key)
+			deleteCollectionBackoffMetric(cc.reportKind, b.cluster.Namespace, b.cluster.Name,
+				clusterproxy.GetClusterType(&b.cluster))
+		}
+	}
+}
+
+// getCollectionBackoff returns the interval before collecting again from a cluster after failures
+// consecutive failures. The first failure does not back off; interval is doubled at each following one.
+func getCollectionBackoff(interval time.Duration, failures int) time.Duration {
+	if failures <= 1 || interval <= 0 {
+		return 0
+	}
+
+	backoff := interval
+	for i := 1; i < failures && backoff < maxCollectionBackoff; i++ {
+		backoff *= 2
+	}
+
+	return min(backoff, maxCollectionBackoff)
+}
@@ -0,0 +1,207 @@
+/*
+Copyright 2023. projectsveltos.io. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2/textlogger"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/projectsveltos/healthcheck-manager/controllers"
+	libsveltosv1beta1 "github.com/projectsveltos/libsveltos/api/v1beta1"
+)
+
+var _ = Describe("Report collection", func() {
+	var clusterList []corev1.ObjectReference
+
+	BeforeEach(func() {
+		clusterList = make([]corev1.ObjectReference, 0)
+		for range 10 {
+			clusterList = append(clusterList, corev1.ObjectReference{
+				Namespace:  randomString(),
+				Name:       randomString(),
+				Kind:       libsveltosv1beta1.SveltosClusterKind,
+				APIVersion: libsveltosv1beta1.GroupVersion.String(),
+			})
+		}
+	})
+
+	It("sweep collects from all clusters and a slow cluster does not delay the others", func() {
+		slowCluster := clusterList[0].Name
+
+		mux := sync.Mutex{}
+		collected := make(map[string]bool)
+		collect := func(ctx context.Context, cluster *corev1.ObjectReference) error {
+			if cluster.Name == slowCluster {
+				// Blocks till the per cluster timeout
+				<-ctx.Done()
+				return ctx.Err()
+			}
+			mux.Lock()
+			defer mux.Unlock()
+			collected[cluster.Name] = true
+			return nil
+		}
+
+		settings := controllers.CollectionSettings{
+			Workers:        2,
+			ClusterTimeout: time.Second,
+			SweepTimeout:   time.Minute,
+		}
+
+		start := time.Now()
+		collector := controllers.NewClusterCollector(settings, time.Second, collect)
+		controllers.Sweep(collector, context.TODO(), clusterList, textlogger.NewLogger(textlogger.NewConfig()))
+		Expect(time.Since(start)).To(BeNumerically("<", 5*time.Second))
+		Expect(collected).To(HaveLen(len(clusterList) - 1))
+	})
+
+	It("sweep cancels collections and waits for them when sweep deadline is reached", func() {
+		started := make(chan struct{})
+		release := make(chan struct{})
+		// Not protected by any lock: run with -race to verify no worker is running once sweep returns
+		var canceled int
+		collect := func(ctx context.Context, _ *corev1.ObjectReference) error {
+			if canceled == 0 {
+				close(started)
+			}
+			<-ctx.Done()
+			<-release
+			canceled++
+			return ctx.Err()
+		}
+
+		settings := controllers.CollectionSettings{
+			Workers:        1,
+			ClusterTimeout: time.Minute,
+			SweepTimeout:   time.Minute,
+		}
+
+		ctx, cancel := context.WithCancel(context.TODO())
+		defer cancel()
+
+		done := make(chan struct{})
+		collector := controllers.NewClusterCollector(settings, time.Second, collect)
+		go func() {
+			defer close(done)
+			controllers.Sweep(collector, ctx, clusterList, textlogger.NewLogger(textlogger.NewConfig()))
+		}()
+
+		<-started
+		cancel()
+
+		// Collection in progress was canceled but did not return yet
+		Consistently(done).ShouldNot(BeClosed())
+
+		close(release)
+		Eventually(done).Should(BeClosed())
+		Expect(canceled).To(Equal(1))
+	})
+
+	It("sweep starts from the first cluster not dispatched by previous sweep", func() {
+		ctx, cancel := context.WithCancel(context.TODO())
+		defer cancel()
+
+		var collected []string
+		collect := func(_ context.Context, cluster *corev1.ObjectReference) error {
+			collected = append(collected, cluster.Name)
+			if len(collected) == 3 {
+				// Sweep deadline is reached
+				cancel()
+			}
+			return nil
+		}
+
+		collector := controllers.NewClusterCollector(controllers.CollectionSettings{Workers: 1}, time.Second, collect)
+		logger := textlogger.NewLogger(textlogger.NewConfig())
+
+		controllers.Sweep(collector, ctx, clusterList, logger)
+		dispatched := len(collected)
+		Expect(dispatched).To(BeNumerically(">=", 3))
+
+		collected = nil
+		controllers.Sweep(collector, context.TODO(), clusterList, logger)
+		Expect(collected).To(HaveLen(len(clusterList)))
+		Expect(collected[0]).To(Equal(clusterList[dispatched%len(clusterList)].Name))
+	})
+
+	It("sweep backs off from clusters collection keeps failing from", func() {
+		failingCluster := clusterList[0].Name
+
+		mux := sync.Mutex{}
+		attempts := 0
+		collect := func(_ context.Context, cluster *corev1.ObjectReference) error {
+			if cluster.Name == failingCluster {
+				mux.Lock()
+				defer mux.Unlock()
+				attempts++
+				return errors.New("cluster unreachable")
+			}
+			return nil
+		}
+
+		collector := controllers.NewClusterCollector(controllers.CollectionSettings{}, time.Hour, collect)
+		logger := textlogger.NewLogger(textlogger.NewConfig())
+
+		// First failure does not back off
+		controllers.Sweep(collector, context.TODO(), clusterList, logger)
+		controllers.Sweep(collector, context.TODO(), clusterList, logger)
+		Expect(attempts).To(Equal(2))
+		Expect(controllers.GetCollectionBackoffs(collector)).To(Equal(1))
+
+		// Now backing off
+		controllers.Sweep(collector, context.TODO(), clusterList, logger)
+		Expect(attempts).To(Equal(2))
+
+		// Cluster is gone
+		controllers.Sweep(collector, context.TODO(), clusterList[1:], logger)
+		Expect(controllers.GetCollectionBackoffs(collector)).To(BeZero())
+	})
+
+	It("getCollectionBackoff doubles interval up to a maximum", func() {
+		Expect(controllers.GetCollectionBackoff(10*time.Second, 1)).To(BeZero())
+		Expect(controllers.GetCollectionBackoff(10*time.Second, 2)).To(Equal(20 * time.Second))
+		Expect(controllers.GetCollectionBackoff(10*time.Second, 3)).To(Equal(40 * time.Second))
+		Expect(controllers.GetCollectionBackoff(10*time.Second, 100)).To(Equal(10 * time.Minute))
+	})
+
+	It("reportWatcher starts and stops watches as clusters match or leave", func() {
+		c := fake.NewClientBuilder().WithScheme(scheme).Build()
+		watcher := controllers.NewHealthCheckReportWatcher(c)
+		logger := textlogger.NewLogger(textlogger.NewConfig())
+
+		ctx, cancel := context.WithCancel(context.TODO())
+		defer cancel()
+
+		controllers.SyncWatches(watcher, ctx, clusterList, logger)
+		Expect(controllers.GetWatches(watcher)).To(Equal(len(clusterList)))
+
+		// Clusters do not exist, so no watch can be established. Those are still polled.
+		Expect(controllers.UnwatchedClusters(watcher, clusterList)).To(HaveLen(len(clusterList)))
+
+		controllers.SyncWatches(watcher, ctx, clusterList[:1], logger)
+		Expect(controllers.GetWatches(watcher)).To(Equal(1))
+	})
+})
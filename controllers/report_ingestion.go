@@ -0,0 +1,368 @@
+/*
+Copyright 2023. projectsveltos.io. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/controller-runtime/pkg/certwatcher"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	libsveltosv1beta1 "github.com/projectsveltos/libsveltos/api/v1beta1"
+	"github.com/projectsveltos/libsveltos/lib/clusterproxy"
+	logs "github.com/projectsveltos/libsveltos/lib/logsettings"
+)
+
+const (
+	// ReportIngestionTokenKey is the key, in the report ingestion Secret, containing the token a managed
+	// cluster authenticates with
+	ReportIngestionTokenKey = "token"
+
+	// reportIngestionSecretNamePostfix is the postfix of the name of the Secret, in the cluster namespace,
+	// containing the report ingestion token of a managed cluster
+	reportIngestionSecretNamePostfix = "-report-ingestion"
+
+	// maxIngestedReportSize is the maximum size of a report posted to the ingestion endpoint
+	maxIngestedReportSize = 1 << 20
+
+	reportIngestionReadHeaderTimeout = 10 * time.Second
+	reportIngestionShutdownTimeout   = 10 * time.Second
+)
+
+// ReportIngestionSettings contains settings of the report ingestion endpoint. Managed clusters whose API
+// server cannot be reached from the management cluster can POST their reports there.
+type ReportIngestionSettings struct {
+	// BindAddress is the address the HTTPS endpoint binds to (e.g. ":9444")
+	BindAddress string
+
+	// CertDir is the directory containing tls.crt and tls.key used to serve HTTPS
+	CertDir string
+}
+
+// reportIngestionServer is an HTTPS endpoint where agents (or sidecars) running in managed clusters POST
+// HealthCheckReports and ReloaderReports:
+//
+//	POST /v1/clusters/{clusterType}/{clusterNamespace}/{clusterName}/healthcheckreports
+//	POST /v1/clusters/{clusterType}/{clusterNamespace}/{clusterName}/reloaderreports
+//
+// Body is a single report (JSON). Requests authenticate with "Authorization: Bearer <token>", where
+// token must match the ReportIngestionTokenKey of Secret <clusterName>-<clusterType>-report-ingestion
+// in the cluster namespace. Ingested reports are processed (and their collection status tracked) as the
+// collected ones.
+type reportIngestionServer struct {
+	c        client.Client
+	settings ReportIngestionSettings
+	logger   logr.Logger
+}
+
+// NewReportIngestionServer returns a manager Runnable serving the report ingestion endpoint
+func NewReportIngestionServer(c client.Client, settings ReportIngestionSettings,
+	logger logr.Logger) *reportIngestionServer {
+
+	return &reportIngestionServer{
+		c:        c,
+		settings: settings,
+		logger:   logger.WithName("report-ingestion"),
+	}
+}
+
+// NeedLeaderElection returns true: only the leader serves the endpoint. Time reports were last received
+// from each cluster is kept in memory and verified (sveltos-agent liveness check) by the leader.
+func (s *reportIngestionServer) NeedLeaderElection() bool {
+	return true
+}
+
+// Start serves the report ingestion endpoint till ctx is canceled
+func (s *reportIngestionServer) Start(ctx context.Context) error {
+	startHealthCheckReportCollection()
+	go s.flushCollectionStatus(ctx)
+
+	watcher, err := certwatcher.New(filepath.Join(s.settings.CertDir, "tls.crt"),
+		filepath.Join(s.settings.CertDir, "tls.key"))
+	if err != nil {
+		return fmt.Errorf("failed to load report ingestion certificate: %w", err)
+	}
+	go func() {
+		if err := watcher.Start(ctx); err != nil {
+			s.logger.Error(err, "certificate watcher failed")
+		}
+	}()
+
+	server := &http.Server{
+		Addr:              s.settings.BindAddress,
+		Handler:           s.handler(),
+		ReadHeaderTimeout: reportIngestionReadHeaderTimeout,
+		TLSConfig: &tls.Config{
+			MinVersion:     tls.VersionTLS12,
+			GetCertificate: watcher.GetCertificate,
+		},
+	}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), reportIngestionShutdownTimeout)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			s.logger.Error(err, "failed to shutdown report ingestion endpoint")
+		}
+	}()
+
+	s.logger.V(logs.LogInfo).Info(fmt.Sprintf("serving report ingestion endpoint on %s", s.settings.BindAddress))
+	if err := server.ListenAndServeTLS("", ""); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// flushCollectionStatus periodically stores the status of ingested reports till ctx is canceled.
+// Same ConfigMaps (and trackers) are used when reports are also collected.
+func (s *reportIngestionServer) flushCollectionStatus(ctx context.Context) {
+	ticker := time.NewTicker(collectionStatusFlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for _, status := range []*collectionStatusTracker{healthCheckReportCollectionStatus,
+				reloaderReportCollectionStatus} {
+
+				if err := status.flush(ctx, s.c); err != nil {
+					s.logger.V(logs.LogInfo).Info(fmt.Sprintf("failed to store %s collection status: %v",
+						status.reportKind, err))
+				}
+			}
+		}
+	}
+}
+
+func (s *reportIngestionServer) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /v1/clusters/{clusterType}/{clusterNamespace}/{clusterName}/healthcheckreports",
+		func(w http.ResponseWriter, r *http.Request) {
+			s.ingest(w, r, libsveltosv1beta1.HealthCheckReportKind, s.ingestHealthCheckReport,
+				healthCheckReportCollectionStatus)
+		})
+	mux.HandleFunc("POST /v1/clusters/{clusterType}/{clusterNamespace}/{clusterName}/reloaderreports",
+		func(w http.ResponseWriter, r *http.Request) {
+			s.ingest(w, r, libsveltosv1beta1.ReloaderReportKind, s.ingestReloaderReport,
+				reloaderReportCollectionStatus)
+		})
+	return mux
+}
+
+// ingestFunc processes a report posted by a managed cluster
+type ingestFunc func(ctx context.Context, cluster *corev1.ObjectReference, body []byte, logger logr.Logger) error
+
+var (
+	errInvalidReport = errors.New("invalid report")
+)
+
+func (s *reportIngestionServer) ingest(w http.ResponseWriter, r *http.Request, reportKind string, ingest ingestFunc,
+	status *collectionStatusTracker) {
+
+	clusterType, ok := parseIngestionClusterType(r.PathValue("clusterType"))
+	if !ok {
+		s.reply(w, reportKind, http.StatusNotFound)
+		return
+	}
+	clusterNamespace := r.PathValue("clusterNamespace")
+	clusterName := r.PathValue("clusterName")
+	logger := s.logger.WithValues("cluster", fmt.Sprintf("%s:%s/%s", clusterType, clusterNamespace, clusterName),
+		"kind", reportKind)
+
+	authorized, err := s.isAuthorized(r.Context(), r, clusterNamespace, clusterName, clusterType)
+	if err != nil {
+		logger.V(logs.LogInfo).Info(fmt.Sprintf("failed to verify token: %v", err))
+		s.reply(w, reportKind, http.StatusInternalServerError)
+		return
+	}
+	if !authorized {
+		logger.V(logs.LogDebug).Info("unauthorized request")
+		s.reply(w, reportKind, http.StatusUnauthorized)
+		return
+	}
+
+	cluster, err := clusterproxy.GetCluster(r.Context(), s.c, clusterNamespace, clusterName, clusterType)
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			s.reply(w, reportKind, http.StatusNotFound)
+			return
+		}
+		s.reply(w, reportKind, http.StatusInternalServerError)
+		return
+	}
+	if !cluster.GetDeletionTimestamp().IsZero() {
+		s.reply(w, reportKind, http.StatusNotFound)
+		return
+	}
+
+	var body json.RawMessage
+	r.Body = http.MaxBytesReader(w, r.Body, maxIngestedReportSize)
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		s.reply(w, reportKind, http.StatusBadRequest)
+		return
+	}
+
+	clusterRef := getIngestionClusterReference(clusterNamespace, clusterName, clusterType)
+	err = ingest(r.Context(), clusterRef, body, logger)
+	status.record(clusterRef, err)
+	if err != nil {
+		logger.V(logs.LogInfo).Info(fmt.Sprintf("failed to ingest report: %v", err))
+		if errors.Is(err, errInvalidReport) {
+			s.reply(w, reportKind, http.StatusBadRequest)
+			return
+		}
+		s.reply(w, reportKind, http.StatusInternalServerError)
+		return
+	}
+
+	s.reply(w, reportKind, http.StatusNoContent)
+}
+
+func (s *reportIngestionServer) reply(w http.ResponseWriter, reportKind string, code int) {
+	recordReportIngestionRequest(reportKind, code)
+	if code == http.StatusNoContent {
+		w.WriteHeader(code)
+		return
+	}
+	http.Error(w, http.StatusText(code), code)
+}
+
+// isAuthorized returns true if the request bearer token matches the cluster report ingestion token
+func (s *reportIngestionServer) isAuthorized(ctx context.Context, r *http.Request, clusterNamespace, clusterName string,
+	clusterType libsveltosv1beta1.ClusterType) (bool, error) {
+
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		return false, nil
+	}
+
+	secret := &corev1.Secret{}
+	err := s.c.Get(ctx, types.NamespacedName{Namespace: clusterNamespace,
+		Name: getReportIngestionSecretName(clusterName, clusterType)}, secret)
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	expected := secret.Data[ReportIngestionTokenKey]
+	if len(expected) == 0 {
+		return false, nil
+	}
+
+	return subtle.ConstantTimeCompare([]byte(token), expected) == 1, nil
+}
+
+func (s *reportIngestionServer) ingestHealthCheckReport(ctx context.Context, cluster *corev1.ObjectReference,
+	body []byte, logger logr.Logger) error {
+
+	hcr := &libsveltosv1beta1.HealthCheckReport{}
+	if err := json.Unmarshal(body, hcr); err != nil {
+		return fmt.Errorf("%w: %v", errInvalidReport, err)
+	}
+	if _, ok := hcr.Labels[libsveltosv1beta1.HealthCheckNameLabel]; !ok {
+		return fmt.Errorf("%w: %s", errInvalidReport, missingLabelError)
+	}
+	logger = logger.WithValues("report", hcr.Name)
+
+	recordHealthCheckReportCollection(cluster.Namespace, cluster.Name, clusterproxy.GetClusterType(cluster))
+
+	if !hcr.DeletionTimestamp.IsZero() {
+		logger.V(logs.LogDebug).Info("deleting from management cluster")
+		return deleteHealthCheckReport(ctx, s.c, cluster, hcr, logger)
+	}
+
+	logger.V(logs.LogDebug).Info("updating in management cluster")
+	return updateHealthCheckReport(ctx, s.c, cluster, hcr, logger)
+}
+
+func (s *reportIngestionServer) ingestReloaderReport(ctx context.Context, cluster *corev1.ObjectReference,
+	body []byte, logger logr.Logger) error {
+
+	rr := &libsveltosv1beta1.ReloaderReport{}
+	if err := json.Unmarshal(body, rr); err != nil {
+		return fmt.Errorf("%w: %v", errInvalidReport, err)
+	}
+	if rr.Name == "" {
+		return fmt.Errorf("%w: name is not set", errInvalidReport)
+	}
+	logger = logger.WithValues("report", rr.Name)
+
+	if !rr.DeletionTimestamp.IsZero() {
+		// ReloaderReports are deleted automatically by ReloaderReport controller after it processes them
+		logger.V(logs.LogDebug).Info("mark as deleted. Ignore it")
+		return nil
+	}
+
+	logger.V(logs.LogDebug).Info("updating in management cluster")
+	return updateReloaderReport(ctx, s.c, cluster, rr, logger)
+}
+
+// getReportIngestionSecretName returns the name of the Secret containing the report ingestion token
+// of a managed cluster
+func getReportIngestionSecretName(clusterName string, clusterType libsveltosv1beta1.ClusterType) string {
+	return fmt.Sprintf("%s-%s%s", clusterName, strings.ToLower(string(clusterType)), reportIngestionSecretNamePostfix)
+}
+
+func parseIngestionClusterType(value string) (libsveltosv1beta1.ClusterType, bool) {
+	switch {
+	case strings.EqualFold(value, string(libsveltosv1beta1.ClusterTypeSveltos)):
+		return libsveltosv1beta1.ClusterTypeSveltos, true
+	case strings.EqualFold(value, string(libsveltosv1beta1.ClusterTypeCapi)):
+		return libsveltosv1beta1.ClusterTypeCapi, true
+	default:
+		return "", false
+	}
+}
+
+func getIngestionClusterReference(clusterNamespace, clusterName string,
+	clusterType libsveltosv1beta1.ClusterType) *corev1.ObjectReference {
+
+	if clusterType == libsveltosv1beta1.ClusterTypeSveltos {
+		return &corev1.ObjectReference{
+			Namespace:  clusterNamespace,
+			Name:       clusterName,
+			Kind:       libsveltosv1beta1.SveltosClusterKind,
+			APIVersion: libsveltosv1beta1.GroupVersion.String(),
+		}
+	}
+
+	return &corev1.ObjectReference{
+		Namespace:  clusterNamespace,
+		Name:       clusterName,
+		Kind:       clusterv1.ClusterKind,
+		APIVersion: clusterv1.GroupVersion.String(),
+	}
+}
@@ -0,0 +1,191 @@
+/*
+Copyright 2023. projectsveltos.io. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2/textlogger"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/projectsveltos/healthcheck-manager/controllers"
+	libsveltosv1beta1 "github.com/projectsveltos/libsveltos/api/v1beta1"
+)
+
+const (
+	testIngestionToken = "ingestion-token"
+)
+
+var _ = Describe("Report ingestion", func() {
+	var sveltosCluster *libsveltosv1beta1.SveltosCluster
+	var c client.Client
+	var handler http.Handler
+
+	BeforeEach(func() {
+		sveltosCluster = &libsveltosv1beta1.SveltosCluster{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: randomString(),
+				Name:      randomString(),
+			},
+		}
+
+		secret := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: sveltosCluster.Namespace,
+				Name: controllers.GetReportIngestionSecretName(sveltosCluster.Name,
+					libsveltosv1beta1.ClusterTypeSveltos),
+			},
+			Data: map[string][]byte{
+				controllers.ReportIngestionTokenKey: []byte(testIngestionToken),
+			},
+		}
+
+		c = fake.NewClientBuilder().WithScheme(scheme).WithObjects(sveltosCluster, secret).Build()
+
+		logger := textlogger.NewLogger(textlogger.NewConfig())
+		handler = controllers.GetReportIngestionHandler(
+			controllers.NewReportIngestionServer(c, controllers.ReportIngestionSettings{}, logger))
+	})
+
+	post := func(clusterType, reportPath, token string, report any) int {
+		body, err := json.Marshal(report)
+		Expect(err).To(BeNil())
+
+		url := fmt.Sprintf("/v1/clusters/%s/%s/%s/%s", clusterType, sveltosCluster.Namespace,
+			sveltosCluster.Name, reportPath)
+		req := httptest.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	getHealthCheckReport := func(healthCheckName string) *libsveltosv1beta1.HealthCheckReport {
+		return &libsveltosv1beta1.HealthCheckReport{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: randomString(),
+				Name:      randomString(),
+				Labels: map[string]string{
+					libsveltosv1beta1.HealthCheckNameLabel: healthCheckName,
+				},
+			},
+			Spec: libsveltosv1beta1.HealthCheckReportSpec{
+				HealthCheckName: healthCheckName,
+			},
+		}
+	}
+
+	It("rejects requests without a valid token", func() {
+		healthCheckReport := getHealthCheckReport(randomString())
+
+		Expect(post("sveltos", "healthcheckreports", "", healthCheckReport)).To(Equal(http.StatusUnauthorized))
+		Expect(post("sveltos", "healthcheckreports", randomString(), healthCheckReport)).To(
+			Equal(http.StatusUnauthorized))
+
+		// Token of a different cluster type is not valid
+		Expect(post("capi", "healthcheckreports", testIngestionToken, healthCheckReport)).To(
+			Equal(http.StatusUnauthorized))
+
+		healthCheckReports := &libsveltosv1beta1.HealthCheckReportList{}
+		Expect(c.List(context.TODO(), healthCheckReports)).To(Succeed())
+		Expect(healthCheckReports.Items).To(BeEmpty())
+	})
+
+	It("rejects unknown cluster types and malformed reports", func() {
+		Expect(post(randomString(), "healthcheckreports", testIngestionToken,
+			getHealthCheckReport(randomString()))).To(Equal(http.StatusNotFound))
+
+		// HealthCheckReport without HealthCheck name label
+		healthCheckReport := getHealthCheckReport(randomString())
+		healthCheckReport.Labels = nil
+		Expect(post("sveltos", "healthcheckreports", testIngestionToken, healthCheckReport)).To(
+			Equal(http.StatusBadRequest))
+
+		Expect(post("sveltos", "reloaderreports", testIngestionToken, "not a report")).To(
+			Equal(http.StatusBadRequest))
+
+		status := controllers.GetCollectionStatus("ReloaderReports", sveltosCluster.Namespace,
+			sveltosCluster.Name, libsveltosv1beta1.ClusterTypeSveltos)
+		Expect(status).ToNot(BeNil())
+		Expect(status.LastSuccessfulCollection).To(BeNil())
+		Expect(status.ConsecutiveFailures).To(Equal(1))
+	})
+
+	It("stores posted HealthCheckReports in the cluster namespace", func() {
+		healthCheck := &libsveltosv1beta1.HealthCheck{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: randomString(),
+			},
+		}
+		Expect(c.Create(context.TODO(), healthCheck)).To(Succeed())
+		healthCheckName := healthCheck.Name
+
+		Expect(post("sveltos", "healthcheckreports", testIngestionToken,
+			getHealthCheckReport(healthCheckName))).To(Equal(http.StatusNoContent))
+
+		clusterType := libsveltosv1beta1.ClusterTypeSveltos
+		currentHealthCheckReport := &libsveltosv1beta1.HealthCheckReport{}
+		Expect(c.Get(context.TODO(),
+			types.NamespacedName{Namespace: sveltosCluster.Namespace,
+				Name: libsveltosv1beta1.GetHealthCheckReportName(healthCheckName, sveltosCluster.Name, &clusterType)},
+			currentHealthCheckReport)).To(Succeed())
+		Expect(currentHealthCheckReport.Spec.ClusterNamespace).To(Equal(sveltosCluster.Namespace))
+		Expect(currentHealthCheckReport.Spec.ClusterName).To(Equal(sveltosCluster.Name))
+		Expect(currentHealthCheckReport.Spec.ClusterType).To(Equal(clusterType))
+
+		// Ingested reports are tracked as the collected ones
+		status := controllers.GetCollectionStatus("HealthCheckReports", sveltosCluster.Namespace,
+			sveltosCluster.Name, clusterType)
+		Expect(status).ToNot(BeNil())
+		Expect(status.LastSuccessfulCollection).ToNot(BeNil())
+		Expect(status.ConsecutiveFailures).To(BeZero())
+	})
+
+	It("stores posted ReloaderReports in the cluster namespace", func() {
+		reloaderReport := &libsveltosv1beta1.ReloaderReport{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: randomString(),
+				Name:      randomString(),
+			},
+		}
+
+		Expect(post("sveltos", "reloaderreports", testIngestionToken, reloaderReport)).To(
+			Equal(http.StatusNoContent))
+
+		currentReloaderReport := &libsveltosv1beta1.ReloaderReport{}
+		Expect(c.Get(context.TODO(),
+			types.NamespacedName{Namespace: sveltosCluster.Namespace, Name: reloaderReport.Name},
+			currentReloaderReport)).To(Succeed())
+		Expect(currentReloaderReport.Spec.ClusterName).To(Equal(sveltosCluster.Name))
+		Expect(currentReloaderReport.Spec.ClusterType).To(Equal(libsveltosv1beta1.ClusterTypeSveltos))
+	})
+})
@@ -0,0 +1,269 @@
+/*
+Copyright 2023. projectsveltos.io. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/watch"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/projectsveltos/libsveltos/lib/clusterproxy"
+	logs "github.com/projectsveltos/libsveltos/lib/logsettings"
+	"github.com/projectsveltos/libsveltos/lib/sveltos_upgrade"
+)
+
+const (
+	// DefaultReportResyncPeriod is the default interval at which all reports are collected again from
+	// a watched managed cluster
+	DefaultReportResyncPeriod = 2 * time.Minute
+
+	// minWatchReconnectDelay and maxWatchReconnectDelay bound the delay before a watch which failed
+	// or was closed is established again
+	minWatchReconnectDelay = 5 * time.Second
+	maxWatchReconnectDelay = 5 * time.Minute
+)
+
+var (
+	errWatchClosed = errors.New("watch closed")
+)
+
+// reportEventFunc processes a report received by watching a managed cluster
+type reportEventFunc func(ctx context.Context, remoteClient client.Client, cluster *corev1.ObjectReference,
+	eventType watch.EventType, obj client.Object, logger logr.Logger)
+
+// clusterWatch is a watch open against a managed cluster
+type clusterWatch struct {
+	cluster corev1.ObjectReference
+	cancel  context.CancelFunc
+	// established is true while the watch is open
+	established bool
+}
+
+// reportWatcher keeps a watch open, for reports, against each managed cluster. Each report event is
+// processed as it is received. All reports are also collected again periodically (resync).
+// A watch failing or being closed is established again, after a delay.
+type reportWatcher struct {
+	c            client.Client
+	version      string
+	reportKind   string
+	settings     CollectionSettings
+	newList      func() client.ObjectList
+	collect      clusterCollectFunc
+	processEvent reportEventFunc
+
+	// mux protects watches
+	mux sync.Mutex
+	// key: managed cluster
+	watches map[string]*clusterWatch
+
+	// wg tracks running watches
+	wg sync.WaitGroup
+}
+
+func newReportWatcher(c client.Client, version, reportKind string, settings CollectionSettings,
+	newList func() client.ObjectList, collect clusterCollectFunc, processEvent reportEventFunc) *reportWatcher {
+
+	if settings.ResyncPeriod <= 0 {
+		settings.ResyncPeriod = DefaultReportResyncPeriod
+	}
+	if settings.ClusterTimeout <= 0 {
+		settings.ClusterTimeout = DefaultCollectionClusterTimeout
+	}
+
+	return &reportWatcher{
+		c:            c,
+		version:      version,
+		reportKind:   reportKind,
+		settings:     settings,
+		newList:      newList,
+		collect:      collect,
+		processEvent: processEvent,
+		watches:      make(map[string]*clusterWatch),
+	}
+}
+
+// sync starts a watch for each cluster in clusterList not watched yet and stops watches for clusters
+// not in clusterList anymore (for instance a cluster which left the shard)
+func (w *reportWatcher) sync(ctx context.Context, clusterList []corev1.ObjectReference, logger logr.Logger) {
+	current := make(map[string]bool, len(clusterList))
+
+	w.mux.Lock()
+	defer w.mux.Unlock()
+
+	for i := range clusterList {
+		cluster := &clusterList[i]
+		key := getClusterKey(cluster.Namespace, cluster.Name, clusterproxy.GetClusterType(cluster))
+		current[key] = true
+		if _, ok := w.watches[key]; ok {
+			continue
+		}
+
+		watchCtx, cancel := context.WithCancel(ctx)
+		cw := &clusterWatch{cluster: *cluster, cancel: cancel}
+		w.watches[key] = cw
+		logger.V(logs.LogDebug).Info(fmt.Sprintf("start watching %s in cluster %s/%s",
+			w.reportKind, cluster.Namespace, cluster.Name))
+		w.wg.Add(1)
+		go func() {
+			defer w.wg.Done()
+			w.run(watchCtx, cw, logger.WithValues("cluster", fmt.Sprintf("%s/%s", cluster.Namespace, cluster.Name)))
+		}()
+	}
+
+	for key, cw := range w.watches {
+		if !current[key] {
+			logger.V(logs.LogDebug).Info(fmt.Sprintf("stop watching %s in cluster %s/%s",
+				w.reportKind, cw.cluster.Namespace, cw.cluster.Name))
+			cw.cancel()
+			delete(w.watches, key)
+		}
+	}
+}
+
+// unwatchedClusters returns the clusters in clusterList with no watch currently open. Reports from
+// those clusters must still be collected by polling.
+func (w *reportWatcher) unwatchedClusters(clusterList []corev1.ObjectReference) []corev1.ObjectReference {
+	w.mux.Lock()
+	defer w.mux.Unlock()
+
+	result := make([]corev1.ObjectReference, 0)
+	for i := range clusterList {
+		cluster := &clusterList[i]
+		key := getClusterKey(cluster.Namespace, cluster.Name, clusterproxy.GetClusterType(cluster))
+		if cw, ok := w.watches[key]; ok && cw.established {
+			continue
+		}
+		result = append(result, *cluster)
+	}
+
+	return result
+}
+
+// wait blocks till all watches returned. Watches return once the context passed to sync is canceled.
+func (w *reportWatcher) wait() {
+	w.wg.Wait()
+}
+
+func (w *reportWatcher) setEstablished(cw *clusterWatch, established bool) {
+	w.mux.Lock()
+	defer w.mux.Unlock()
+	cw.established = established
+}
+
+// run keeps a watch open against a cluster till ctx is canceled
+func (w *reportWatcher) run(ctx context.Context, cw *clusterWatch, logger logr.Logger) {
+	delay := minWatchReconnectDelay
+	for {
+		established, err := w.watchCluster(ctx, cw, logger)
+		if ctx.Err() != nil {
+			return
+		}
+		if established {
+			// Watch was working. Reconnect quickly.
+			delay = minWatchReconnectDelay
+		}
+		logger.V(logs.LogDebug).Info(fmt.Sprintf("watch on %s ended: %v. Reconnecting in %s",
+			w.reportKind, err, delay))
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		delay = min(2*delay, maxWatchReconnectDelay)
+	}
+}
+
+// watchCluster opens a watch against a cluster and processes events till the watch fails, is closed
+// or ctx is canceled. Returns whether the watch was established.
+func (w *reportWatcher) watchCluster(ctx context.Context, cw *clusterWatch, logger logr.Logger) (bool, error) {
+	cluster := &cw.cluster
+	clusterType := clusterproxy.GetClusterType(cluster)
+
+	ready, err := clusterproxy.IsClusterReadyToBeConfigured(ctx, w.c, cluster, logger)
+	if err != nil {
+		return false, err
+	}
+	if !ready {
+		return false, errors.New("cluster is not ready yet")
+	}
+
+	// Cached client, tracked by the cluster circuit breaker. Fails fast if circuit breaker is open.
+	remoteClient, err := getRemoteWatchClient(ctx, w.c, cluster.Namespace, cluster.Name, clusterType, logger)
+	if err != nil {
+		return false, err
+	}
+
+	if !sveltos_upgrade.IsSveltosAgentVersionCompatible(ctx, remoteClient, w.version) {
+		return false, errors.New(compatibilityErrorMsg)
+	}
+
+	watcher, err := remoteClient.Watch(ctx, w.newList())
+	if err != nil {
+		return false, err
+	}
+	defer watcher.Stop()
+
+	w.setEstablished(cw, true)
+	defer w.setEstablished(cw, false)
+
+	// No resync now: an ADDED event is received for each existing report.
+	ticker := time.NewTicker(w.settings.ResyncPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return true, ctx.Err()
+		case <-ticker.C:
+			w.resync(ctx, cluster, logger)
+		case event, ok := <-watcher.ResultChan():
+			if !ok {
+				return true, errWatchClosed
+			}
+			if event.Type == watch.Error {
+				return true, apierrors.FromObject(event.Object)
+			}
+			obj, ok := event.Object.(client.Object)
+			if !ok {
+				continue
+			}
+			eventCtx, cancel := context.WithTimeout(ctx, w.settings.ClusterTimeout)
+			w.processEvent(eventCtx, remoteClient, cluster, event.Type, obj,
+				logger.WithValues("report", obj.GetName()))
+			cancel()
+		}
+	}
+}
+
+// resync collects all reports from cluster
+func (w *reportWatcher) resync(ctx context.Context, cluster *corev1.ObjectReference, logger logr.Logger) {
+	resyncCtx, cancel := context.WithTimeout(ctx, w.settings.ClusterTimeout)
+	defer cancel()
+
+	if err := w.collect(resyncCtx, cluster); err != nil {
+		logger.V(logs.LogInfo).Info(fmt.Sprintf("failed to collect %s from cluster: %v", w.reportKind, err))
+	}
+}
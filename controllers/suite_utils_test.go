@@ -133,6 +133,7 @@ func getClusterHealthCheckReconciler(c client.Client) *controllers.ClusterHealth
 		ClusterHealthChecks: make(map[corev1.ObjectReference]libsveltosv1beta1.Selector),
 		HealthCheckMap:      make(map[corev1.ObjectReference]*libsveltosset.Set),
 		CHCToHealthCheckMap: make(map[types.NamespacedName]*libsveltosset.Set),
+		NextEvaluations:     make(map[types.NamespacedName]map[corev1.ObjectReference]time.Time),
 		ClusterLabels:       make(map[corev1.ObjectReference]map[string]string),
 		Mux:                 sync.Mutex{},
 	}
@@ -18,10 +18,15 @@ package controllers
 
 import (
 	"context"
+	"fmt"
 
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 
 	libsveltosv1beta1 "github.com/projectsveltos/libsveltos/api/v1beta1"
 	logs "github.com/projectsveltos/libsveltos/lib/logsettings"
@@ -49,5 +54,35 @@ func (r *SveltosClusterReconciler) Reconcile(ctx context.Context, req ctrl.Reque
 func (r *SveltosClusterReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&libsveltosv1beta1.SveltosCluster{}).
+		Watches(&corev1.Secret{},
+			handler.EnqueueRequestsFromMapFunc(r.requeueSveltosClusterForSecret),
+		).
 		Complete(r)
 }
+
+// requeueSveltosClusterForSecret returns the SveltosClusters whose kubeconfig is in the Secret, so a
+// cached client to those clusters is discarded when the kubeconfig changes
+func (r *SveltosClusterReconciler) requeueSveltosClusterForSecret(
+	ctx context.Context, o client.Object,
+) []reconcile.Request {
+
+	sveltosClusters := &libsveltosv1beta1.SveltosClusterList{}
+	if err := r.List(ctx, sveltosClusters, client.InNamespace(o.GetNamespace())); err != nil {
+		ctrl.LoggerFrom(ctx).V(logs.LogInfo).Info(fmt.Sprintf("failed to list SveltosClusters: %v", err))
+		return nil
+	}
+
+	requests := make([]reconcile.Request, 0)
+	for i := range sveltosClusters.Items {
+		if getKubeconfigSecretName(&sveltosClusters.Items[i]) == o.GetName() {
+			requests = append(requests, reconcile.Request{
+				NamespacedName: types.NamespacedName{
+					Namespace: sveltosClusters.Items[i].Namespace,
+					Name:      sveltosClusters.Items[i].Name,
+				},
+			})
+		}
+	}
+
+	return requests
+}
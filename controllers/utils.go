@@ -17,6 +17,8 @@ limitations under the License.
 package controllers
 
 import (
+	"fmt"
+
 	corev1 "k8s.io/api/core/v1"
 	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
 	"k8s.io/apimachinery/pkg/runtime"
@@ -86,3 +88,8 @@ func getKeyFromObject(scheme *runtime.Scheme, obj client.Object) *corev1.ObjectR
 		APIVersion: apiVersion,
 	}
 }
+
+// getClusterKey returns a string uniquely identifying a managed cluster
+func getClusterKey(clusterNamespace, clusterName string, clusterType libsveltosv1beta1.ClusterType) string {
+	return fmt.Sprintf("%s:%s/%s", clusterType, clusterNamespace, clusterName)
+}